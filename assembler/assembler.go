@@ -9,6 +9,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
 )
 
 type symbolResolver interface {
@@ -59,6 +61,8 @@ type Assembler struct {
 	extended bool
 
 	symbols    map[string]uint16
+	labels     map[string]psudoAddress
+	lines      map[psudoAddress]symtab.Location
 	Problems   []Problem
 	errorCount int
 
@@ -318,6 +322,11 @@ func (a *Assembler) addLabel(pl problemLogger, s string) {
 
 	val = pa.asOperand()
 	a.defineSymbol(pl, s, val)
+
+	if a.labels == nil {
+		a.labels = make(map[string]psudoAddress)
+	}
+	a.labels[s] = pa
 }
 
 func (a *Assembler) writeWordToImage(pl problemLogger, v uint16) bool {
@@ -341,10 +350,32 @@ func (a *Assembler) writeWordToImage(pl problemLogger, v uint16) bool {
 
 	bank[o] = v
 
+	if a.lines == nil {
+		a.lines = make(map[psudoAddress]symtab.Location)
+	}
+	a.lines[loc] = pl.sourceLocation()
+
 	a.incLocation()
 	return true
 }
 
+//SymbolTable builds a table of all the symbols defined during assembly, where labels
+//are resolved to their psudo-address, along with the source line of every word written.
+func (a *Assembler) SymbolTable() *symtab.Table {
+	t := symtab.New()
+	for s, v := range a.symbols {
+		if pa, ok := a.labels[s]; ok {
+			t.Define(s, uint16(pa))
+		} else {
+			t.Define(s, v)
+		}
+	}
+	for pa, loc := range a.lines {
+		t.SetLine(uint16(pa), loc)
+	}
+	return t
+}
+
 //ImageBuilt indicates that an AGC image has been successfully built and can be extracted with WriteOut.
 func (a *Assembler) ImageBuilt() bool {
 	return a.image != nil
@@ -678,3 +678,29 @@ func assertImageValue(t *testing.T, expected uint16, a *Assembler, bank uint16,
 		}
 	}
 }
+
+func Test_assembler_SymbolTable(t *testing.T) {
+	// arrange
+	a := new(Assembler)
+	prog := "FOO = 0123\nSETLOC 020000\nSTART\tTCF START\n\tNOOP\n"
+	ok := a.assembleReader(strings.NewReader(prog), "fake_file.asm")
+	require.True(t, ok, "arrange failed")
+
+	// act
+	tbl := a.SymbolTable()
+
+	// assert
+	v, ok := tbl.Lookup("FOO")
+	if assert.True(t, ok, "FOO defined") {
+		assert.EqualValues(t, 0123, v, "FOO value")
+	}
+	// labels are reported by psudo-address rather than operand value
+	v, ok = tbl.Lookup("START")
+	if assert.True(t, ok, "START defined") {
+		assert.EqualValues(t, 020000, v, "START value")
+	}
+	if loc, ok := tbl.Line(020001); assert.True(t, ok, "line exists") {
+		assert.Equal(t, "fake_file.asm", loc.File, "line file")
+		assert.Equal(t, 4, loc.Line, "line number")
+	}
+}
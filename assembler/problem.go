@@ -2,6 +2,8 @@ package assembler
 
 import (
 	"fmt"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
 )
 
 //ProblemKind is an enumeration for defining the severity of a Problem.
@@ -46,6 +48,7 @@ type problemLogger interface {
 	LogWarningf(format string, a ...interface{})
 	LogInfo(msg string)
 	LogInfof(format string, a ...interface{})
+	sourceLocation() symtab.Location
 }
 
 type assemblerLogger struct {
@@ -78,3 +81,7 @@ func (al *assemblerLogger) LogInfo(msg string) {
 func (al *assemblerLogger) LogInfof(format string, a ...interface{}) {
 	al.LogInfo(fmt.Sprintf(format, a...))
 }
+
+func (al *assemblerLogger) sourceLocation() symtab.Location {
+	return symtab.Location{File: al.fileName, Line: al.lineNum}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/Elsewhen-Studios/go-agc/dsky"
+	"github.com/pkg/errors"
+)
+
+// dskyKeys maps the keys of the terminal onto the DSKY's.
+var dskyKeys = map[byte]dsky.Key{
+	'0': dsky.Key0, '1': dsky.Key1, '2': dsky.Key2, '3': dsky.Key3, '4': dsky.Key4,
+	'5': dsky.Key5, '6': dsky.Key6, '7': dsky.Key7, '8': dsky.Key8, '9': dsky.Key9,
+	'v': dsky.KeyVerb, 'n': dsky.KeyNoun,
+	'+': dsky.KeyPlus, '=': dsky.KeyPlus, '-': dsky.KeyMinus, '_': dsky.KeyMinus,
+	'c': dsky.KeyClear, 'p': dsky.KeyPro, 'k': dsky.KeyRel, 'r': dsky.KeyReset,
+	'e': dsky.KeyEnter, '\n': dsky.KeyEnter, '\r': dsky.KeyEnter,
+}
+
+const dskyHelp = "0-9 digits  v VERB  n NOUN  + -  c CLR  p PRO  k KEY REL  e/Enter ENTR  r RSET  Tab station  Ctrl-C quit"
+
+// runDSKY attaches the main panel and nav bay DSKYs to c and shows their
+// display in the terminal until the returned function is called. Keys read
+// from stdin go to one of them, and Tab switches between them.
+func runDSKY(c *cpu.CPU) func() {
+	restore, err := cbreakTerminal()
+	if err != nil {
+		fatal("failed to set up the terminal for the DSKY", err)
+	}
+
+	dskys := []*dsky.DSKY{dsky.New(), dsky.NewStation(dsky.NavBay)}
+	for _, d := range dskys {
+		c.Attach(d)
+	}
+	// keys is the index of the DSKY the keys go to
+	var keys int32
+	switched := make(chan struct{}, 1)
+
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if b == '\t' {
+				atomic.StoreInt32(&keys, (atomic.LoadInt32(&keys)+1)%int32(len(dskys)))
+				select {
+				case switched <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			if b >= 'A' && b <= 'Z' {
+				b += 'a' - 'A'
+			}
+			if k, ok := dskyKeys[b]; ok {
+				dskys[atomic.LoadInt32(&keys)].Press(k)
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// VERB and NOUN flash at about 1.5Hz
+		flash := time.NewTicker(330 * time.Millisecond)
+		defer flash.Stop()
+		on := true
+		fmt.Print("\x1b[?25l\x1b[2J")
+		for {
+			// both DSKYs show the same display
+			fmt.Print("\x1b[H")
+			renderDSKY(os.Stdout, dskys[0].Display(), on, dskys[atomic.LoadInt32(&keys)].Station())
+			select {
+			case <-dskys[0].Changed():
+			case <-switched:
+			case <-flash.C:
+				on = !on
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		fmt.Print("\x1b[?25h\n")
+		restore()
+	}
+}
+
+// cbreakTerminal stops the terminal from waiting for a whole line and from
+// echoing the keys, returning a function which puts it back how it was.
+func cbreakTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, errors.Wrap(err, "stdin is not a terminal")
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), errors.Wrap(err, "stty failed")
+}
+
+// The ANSI styles of the display.
+const (
+	styleOff     = "\x1b[0m"
+	styleUnlit   = "\x1b[2m"
+	styleStatus  = "\x1b[30;47m"
+	styleCaution = "\x1b[30;43m"
+	styleActy    = "\x1b[30;42m"
+	styleDigits  = "\x1b[1;32m"
+)
+
+// dskyLamp is one of the lamps on the left of the DSKY.
+type dskyLamp struct {
+	name  string
+	style string
+	lit   func(l dsky.Lamps) bool
+}
+
+// dskyLamps are laid out in two columns as on the real DSKY.
+var dskyLamps = [][2]dskyLamp{
+	{{"UPLINK ACTY", styleStatus, func(l dsky.Lamps) bool { return l.UplinkActy }},
+		{"TEMP", styleCaution, func(l dsky.Lamps) bool { return l.Temp }}},
+	{{"NO ATT", styleStatus, func(l dsky.Lamps) bool { return l.NoAtt }},
+		{"GIMBAL LOCK", styleCaution, func(l dsky.Lamps) bool { return l.GimbalLock }}},
+	{{"KEY REL", styleStatus, func(l dsky.Lamps) bool { return l.KeyRel }},
+		{"PROG", styleCaution, func(l dsky.Lamps) bool { return l.Prog }}},
+	{{"OPR ERR", styleStatus, func(l dsky.Lamps) bool { return l.OprErr }},
+		{"TRACKER", styleCaution, func(l dsky.Lamps) bool { return l.Tracker }}},
+	{{"PRIO DISP", styleStatus, func(l dsky.Lamps) bool { return l.PrioDisp }},
+		{"ALT", styleCaution, func(l dsky.Lamps) bool { return l.Alt }}},
+	{{"NO DAP", styleStatus, func(l dsky.Lamps) bool { return l.NoDAP }},
+		{"VEL", styleCaution, func(l dsky.Lamps) bool { return l.Vel }}},
+}
+
+func lamp(name, style string, lit bool) string {
+	if !lit {
+		style = styleUnlit
+	}
+	return fmt.Sprintf("%s %-11s %s", style, name, styleOff)
+}
+
+// renderDSKY draws the DSKY, with VERB and NOUN blanked if they're flashing
+// and flashOn is false, saying which station the keys go to.
+func renderDSKY(w io.Writer, disp dsky.Display, flashOn bool, keys dsky.Station) {
+	digits := func(d []byte) string {
+		return styleDigits + string(d) + styleOff
+	}
+	verb, noun := disp.Verb[:], disp.Noun[:]
+	if disp.Flash && !flashOn {
+		verb, noun = []byte("  "), []byte("  ")
+	}
+
+	right := []string{
+		lamp("COMP ACTY", styleActy, disp.Lamps.CompActy) + "   PROG " + digits(disp.Prog[:]),
+		"",
+		"   VERB " + digits(verb) + "      NOUN " + digits(noun),
+		"",
+		"         " + digits(disp.R[0][:]),
+		"         " + digits(disp.R[1][:]),
+		"         " + digits(disp.R[2][:]),
+	}
+
+	var b strings.Builder
+	for i, r := range right {
+		left := strings.Repeat(" ", 2*13+1)
+		if i < len(dskyLamps) {
+			pair := dskyLamps[i]
+			left = lamp(pair[0].name, pair[0].style, pair[0].lit(disp.Lamps)) + " " +
+				lamp(pair[1].name, pair[1].style, pair[1].lit(disp.Lamps))
+		}
+		fmt.Fprintf(&b, "  %s    %s\x1b[K\n", left, r)
+	}
+	fmt.Fprintf(&b, "\n  keys go to the %s DSKY\x1b[K\n", keys)
+	fmt.Fprintf(&b, "  %s\x1b[K\n", dskyHelp)
+	io.WriteString(w, b.String())
+}
+
+// pacer is a device which holds the CPU back to the speed of the real AGC,
+// so that the program behaves as it would in flight for the people and
+// peripherals using it.
+type pacer struct {
+	start time.Time
+	// last is the time in memory cycles when the pace was last checked
+	last uint64
+}
+
+// paceInterval is how often, in memory cycles, the pace is checked.
+const paceInterval = cpu.CyclesPer10ms
+
+func newPacer() *pacer {
+	return new(pacer)
+}
+
+func (p *pacer) ChannelWritten(ch, val uint16) {}
+
+func (p *pacer) Step(io cpu.DeviceIO) {
+	now := io.Cycles()
+	if p.start.IsZero() {
+		p.start, p.last = time.Now(), now
+		return
+	}
+	if now-p.last < paceInterval {
+		return
+	}
+	p.last = now
+	agc := time.Duration(float64(now) * 11.71875 * float64(time.Microsecond))
+	if ahead := agc - time.Since(p.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
@@ -1,25 +1,77 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/Elsewhen-Studios/go-agc/downlink"
+	"github.com/Elsewhen-Studios/go-agc/imu"
 	"github.com/Elsewhen-Studios/go-agc/memory"
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/Elsewhen-Studios/go-agc/uplink"
+	"github.com/Elsewhen-Studios/go-agc/yaagc"
+	"github.com/pkg/errors"
 )
 
 var (
 	yaAGCFormat = flag.Bool("yaagc", false, "Indicates that the memory file is in the yaAGC format")
 	debug       = flag.Bool("debug", false, "Execute with debugger attached")
+	symbolFile  = flag.String("symbols", "", "A symbol table from the assembler for the debugger to use")
+	historySize = flag.Int("history", 10000, "The number of steps the debugger can run backwards")
+	dap         = flag.Bool("dap", false, "Serve the Debug Adapter Protocol on stdin and stdout, running the program the client launches")
+	script      = flag.String("script", "", "Run the debugger commands in this file and then quit, exiting with status 1 if any of them failed")
+	noInit      = flag.Bool("noinit", false, "Don't run the debugger commands in "+initFile+" from the home and current directories")
+	debugListen = flag.String("debug-listen", "", "Serve the debugger to remote clients on this TCP address instead of the console")
+	logEvents   = flag.String("log", "", "Log these events as the program runs: a comma separated list of instructions, timers, sequences and interrupts, or all")
+	logFile     = flag.String("log-file", "", "Write the log to this file instead of stdout")
+	logFormat   = flag.String("log-format", "text", "The format of the log, either text or json")
+	logRange    = flag.String("log-range", "", "Only log instructions in these comma separated ranges of octal psudo-addresses, such as 4000-4777")
+	traceFile   = flag.String("trace", "", "Write a trace of the registers before each instruction to this file, for comparing with tracediff")
+	profileFile = flag.String("profile", "", "Write a pprof profile of the cycles spent in each call stack to this file")
+	coverFile   = flag.String("coverage", "", "Write a report of the code executed to this file")
+	coverFormat = flag.String("coverage-format", "text", "The format of the coverage report: text, html or lcov")
+	vcdFile     = flag.String("vcd", "", "Write a Value Change Dump of channels, counters and interrupts to this file")
+	vcdChannels = flag.String("vcd-channels", "10,11,12,13,14,30,31,32,33", "The comma separated octal channels to include in the Value Change Dump")
+	vcdCounters = flag.String("vcd-counters", "TIME1,TIME3,TIME4,TIME5,TIME6", "The comma separated counters to include in the Value Change Dump")
+	timeline    = flag.String("timeline", "", "Write a timeline of interrupts, timers and counters to this file in the Chrome trace event format")
+	timelineSub = flag.Bool("timeline-calls", false, "Include a span for every subroutine call in the timeline, using the symbol table")
+	heatmapFile = flag.String("heatmap", "", "Write the reads, writes and executions of every word of memory to this file")
+	heatmapFmt  = flag.String("heatmap-format", "html", "The format of the memory heatmap: html, png, csv or json")
+	crashSteps  = flag.Int("crash-history", 100, "The number of steps to include in the crash report if the emulator faults (0 disables the report)")
+	crashFile   = flag.String("crash-report", "", "Write the crash report to this file instead of stderr")
+	stats       = flag.Bool("stats", false, "Print statistics of interrupt latencies and durations and of the time spent idle on exit")
+	statsIdle   = flag.String("stats-idle", "", "Count instructions in these comma separated ranges of octal psudo-addresses as idle, besides those which jump to themselves")
+	dskyMode    = flag.Bool("dsky", false, "Show the DSKY in the terminal and type on the keyboard of the main panel or (switching with Tab) nav bay DSKY, running the program in real time")
+	yaAGCListen = flag.String("yaagc-listen", "", "Serve the I/O channels to yaAGC peripherals such as yaDSKY2 on this TCP address (they expect :19697), running the program in real time")
+	downlinkCap = flag.String("downlink", "", "Raise DOWNRUPT every 20ms and write the pairs of words the program sends on channels 34 and 35 to this file")
+	dlLists     = flag.String("downlink-lists", "", "Raise DOWNRUPT every 20ms and decode the downlink into named fields using the list definitions in this JSON file")
+	dlDecoded   = flag.String("downlink-decoded", "", "Write the decoded downlink lists to this file instead of stdout")
+	uplinkFile  = flag.String("uplink", "", "Send the program the DSKY keys and words in this uplink script through INLINK and UPRUPT")
+	uplinkLog   = flag.String("uplink-log", "", "Write the words uplinked and the changes to UPLINK ACTY to this file")
+	imuFile     = flag.String("imu", "", "Attach an IMU whose gimbal angles, accelerations and discretes follow this script, counting into the CDU and PIPA counters")
+	cycleLimit  = flag.Uint64("cycles", 0, "Stop after this many memory cycles (0 runs forever)")
 )
 
 func main() {
 	flag.Parse()
 
+	if *dap {
+		serveDAP()
+		return
+	}
+
 	if flag.NArg() != 1 {
 		// wrong number of arguments provided
 		fmt.Fprintln(os.Stderr, "incorrect number of arguments")
@@ -27,19 +79,179 @@ func main() {
 		return
 	}
 
-	binFile := flag.Arg(0)
+	mm, err := loadCore(flag.Arg(0), *yaAGCFormat)
+	if err != nil {
+		fatal("failed to load main memory", err)
+	}
+
+	var symbols *symtab.Table
+	if *symbolFile != "" {
+		symbols = loadSymbols(*symbolFile)
+	}
+
+	theCPU := cpu.NewCPU(mm)
+	flushLog := setupLogging(theCPU, symbols)
+	if *dskyMode {
+		if *debug || *script != "" {
+			fatal("bad DSKY options", errors.New("the DSKY and the debugger console can't share the terminal"))
+		}
+		stopDSKY := runDSKY(theCPU)
+		flush := flushLog
+		flushLog = func() {
+			stopDSKY()
+			flush()
+		}
+	}
+	flushDevices := setupDevices(theCPU)
+	flush := flushLog
+	flushLog = func() {
+		flushDevices()
+		flush()
+	}
+	defer flushLog()
+
+	if *debugListen != "" {
+		ln, err := net.Listen("tcp", *debugListen)
+		if err != nil {
+			fatal("failed to listen for debugger clients", err)
+		}
+		engine := cpu.NewDebugEngine()
+		engine.Symbols = symbols
+		go engine.Serve(ln)
+		theCPU.Debugger = engine
+		theCPU.RecordHistory(*historySize)
+	} else if *debug || *script != "" {
+		d := cpu.NewInteractiveDebugger()
+		d.Symbols = symbols
+		theCPU.Debugger = d
+		theCPU.RecordHistory(*historySize)
+
+		// break into the program on Ctrl-C instead of exiting
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt)
+		go func() {
+			for range sigc {
+				d.Pause()
+			}
+		}()
+
+		if *script != "" {
+			status := make(chan int)
+			go func() {
+				status <- runScript(d)
+			}()
+			run(theCPU, flushLog)
+			// fail the command the script is waiting on if the CPU
+			// halted or ran out of cycles before the script quit
+			d.Halted()
+			flushLog()
+			os.Exit(<-status)
+		}
+
+		go func() {
+			runInitFiles(d)
+			d.Run()
+		}()
+	} else {
+		// stop on Ctrl-C so the logs, reports and statistics are written out
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt)
+		go func() {
+			<-sigc
+			signal.Stop(sigc)
+			theCPU.Stop()
+		}()
+	}
+
+	run(theCPU, flushLog)
+}
+
+// run runs the CPU. If it faults with a crash report being kept, the report
+// has all the details, so the program just exits instead of panicking.
+func run(c *cpu.CPU, flushLog func()) {
+	if c.Crash != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				flushLog()
+				fatal("the AGC faulted", fmt.Errorf("%v", r))
+			}
+		}()
+	}
+	c.Run()
+}
+
+// initFile holds debugger commands which are run whenever the debugger starts.
+const initFile = ".agcdbinit"
+
+// runInitFiles runs the startup files in the home and current directories,
+// returning the number of commands in them which failed.
+func runInitFiles(d *cpu.InteractiveDebugger) int {
+	if *noInit {
+		return 0
+	}
 
+	var files []string
+	if home := os.Getenv("HOME"); home != "" {
+		files = append(files, filepath.Join(home, initFile))
+	}
+	if wd, err := os.Getwd(); err == nil && (len(files) == 0 || filepath.Join(wd, initFile) != files[0]) {
+		files = append(files, filepath.Join(wd, initFile))
+	}
+
+	failed := 0
+	for _, path := range files {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			fatal("failed to open debugger startup file", err)
+		}
+		n, err := d.Source(f)
+		f.Close()
+		if err != nil {
+			fatal("failed to read debugger startup file", err)
+		}
+		failed += n
+	}
+	return failed
+}
+
+// runScript runs the startup files and then the script, quitting at the end
+// of the script if it didn't quit itself. It returns the exit status.
+func runScript(d *cpu.InteractiveDebugger) int {
+	failed := runInitFiles(d)
+
+	f, err := os.Open(*script)
+	if err != nil {
+		fatal("failed to open debugger script", err)
+	}
+	defer f.Close()
+
+	n, err := d.Source(io.MultiReader(f, strings.NewReader("\nquit\n")))
+	if err != nil {
+		fatal("failed to read debugger script", err)
+	}
+	if failed += n; failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d debugger commands failed\n", failed)
+		return 1
+	}
+	return 0
+}
+
+// loadCore loads a core rope image into a new main memory.
+func loadCore(binFile string, yaAGC bool) (*memory.Main, error) {
 	var (
 		coreMemReader io.Reader
 		leftAligned   bool
 	)
-	if *yaAGCFormat {
+	if yaAGC {
 		// the core memory file is in the yaAGC format so we
 		// have to do some manipulation
 		// see http://www.ibiblio.org/apollo/developer.html#CoreFormat
 		raw, err := ioutil.ReadFile(binFile)
 		if err != nil {
-			fatal("failed to read core rope file", err)
+			return nil, errors.Wrap(err, "failed to read core rope file")
 		}
 
 		// the order of the banks in the file is 2, 3, 0, 1, 4, 5, 6, etc
@@ -57,7 +269,7 @@ func main() {
 	} else {
 		f, err := os.Open(binFile)
 		if err != nil {
-			fatal("failed to open core rope file", err)
+			return nil, errors.Wrap(err, "failed to open core rope file")
 		}
 		defer f.Close()
 		coreMemReader = f
@@ -66,18 +278,441 @@ func main() {
 	mm := new(memory.Main)
 	l := &memory.Loader{MM: mm, LeftAligned: leftAligned}
 	if _, err := io.Copy(l, coreMemReader); err != nil {
-		fatal("failed to load main memory", err)
+		return nil, err
 	}
+	return mm, nil
+}
 
-	theCPU := cpu.NewCPU(mm)
+func loadSymbols(path string) *symtab.Table {
+	t, err := readSymbols(path)
+	if err != nil {
+		fatal("failed to read symbol table", err)
+	}
+	return t
+}
 
-	if *debug {
-		d := cpu.NewInteractiveDebugger()
-		go d.Run()
-		theCPU.Debugger = d
+func readSymbols(path string) (*symtab.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
+	return symtab.Read(f)
+}
+
+// serveDAP runs a Debug Adapter Protocol server for an editor. The protocol
+// uses stdout, so anything the emulator prints is sent to stderr instead.
+func serveDAP() {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	flushLog := func() {}
+
+	err := cpu.ServeDAP(os.Stdin, out, func(raw json.RawMessage) (*cpu.CPU, *symtab.Table, error) {
+		var args struct {
+			Program string `json:"program"`
+			Symbols string `json:"symbols"`
+			YaAGC   bool   `json:"yaagc"`
+		}
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, nil, errors.Wrap(err, "bad launch arguments")
+		}
+		if args.Program == "" {
+			return nil, nil, errors.New("no program given to launch")
+		}
 
-	theCPU.Run()
+		mm, err := loadCore(args.Program, args.YaAGC)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to load main memory")
+		}
+		var t *symtab.Table
+		if args.Symbols != "" {
+			if t, err = readSymbols(args.Symbols); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to read symbol table")
+			}
+		}
+
+		c := cpu.NewCPU(mm)
+		c.RecordHistory(*historySize)
+		flush := setupLogging(c, t)
+		flushDevices := setupDevices(c)
+		flushLog = func() {
+			flushDevices()
+			flush()
+		}
+		return c, t, nil
+	})
+	flushLog()
+	if err != nil {
+		fatal("debug adapter failed", err)
+	}
+}
+
+// setupLogging applies the flags for the logging, tracing, profiling, coverage,
+// waveforms, timeline, heatmap, crash report, statistics and cycle limit to c, returning a function which writes
+// out whichever of them are in use once the CPU has stopped running.
+func setupLogging(c *cpu.CPU, symbols *symtab.Table) func() {
+	opts, err := parseLogOptions(*logEvents, *logFormat, *logRange)
+	if err != nil {
+		fatal("bad logging options", err)
+	}
+	c.CycleLimit = *cycleLimit
+
+	var flushes []func()
+	if *logEvents != "" {
+		if *logFile == "" {
+			// stdout is left unbuffered so the log stays in step with the debugger
+			opts.Output = os.Stdout
+		} else {
+			w, flush := createOutput(*logFile)
+			opts.Output = w
+			flushes = append(flushes, flush)
+		}
+		c.Logging = opts
+	}
+	if *traceFile != "" {
+		w, flush := createOutput(*traceFile)
+		c.Trace = w
+		flushes = append(flushes, flush)
+	}
+	if *profileFile != "" {
+		c.Profile = cpu.NewProfile()
+		c.Profile.Symbols = symbols
+		flushes = append(flushes, func() {
+			f, err := os.Create(*profileFile)
+			if err == nil {
+				err = c.Profile.Write(f)
+				f.Close()
+			}
+			if err != nil {
+				fatal("failed to write profile", err)
+			}
+		})
+	}
+
+	if *coverFile != "" {
+		write := map[string]func(*cpu.Coverage, io.Writer) error{
+			"text": (*cpu.Coverage).WriteText,
+			"html": (*cpu.Coverage).WriteHTML,
+			"lcov": (*cpu.Coverage).WriteLCOV,
+		}[*coverFormat]
+		if write == nil {
+			fatal("bad coverage options", errors.Errorf("unknown format %q", *coverFormat))
+		}
+		c.Coverage = cpu.NewCoverage()
+		c.Coverage.Symbols = symbols
+		flushes = append(flushes, func() {
+			f, err := os.Create(*coverFile)
+			if err == nil {
+				err = write(c.Coverage, f)
+				f.Close()
+			}
+			if err != nil {
+				fatal("failed to write coverage report", err)
+			}
+		})
+	}
+
+	if *vcdFile != "" {
+		var channels []uint16
+		for _, ch := range strings.Split(*vcdChannels, ",") {
+			n, err := strconv.ParseUint(strings.TrimSpace(ch), 8, 16)
+			if err != nil {
+				fatal("bad Value Change Dump channels", err)
+			}
+			channels = append(channels, uint16(n))
+		}
+		var counters []string
+		for _, name := range strings.Split(*vcdCounters, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				counters = append(counters, name)
+			}
+		}
+
+		w, flush := createOutput(*vcdFile)
+		vcd, err := cpu.NewVCD(w, channels, counters)
+		if err != nil {
+			fatal("bad Value Change Dump options", err)
+		}
+		c.VCD = vcd
+		flushes = append(flushes, func() {
+			vcd.Flush()
+			flush()
+		})
+	}
+
+	if *timeline != "" {
+		w, flush := createOutput(*timeline)
+		c.Timeline = cpu.NewTimeline(w)
+		if *timelineSub {
+			c.Timeline.Symbols = symbols
+		}
+		flushes = append(flushes, func() {
+			c.Timeline.Close()
+			flush()
+		})
+	}
+
+	if *heatmapFile != "" {
+		write := map[string]func(*cpu.Heatmap, io.Writer) error{
+			"html": (*cpu.Heatmap).WriteHTML,
+			"png":  (*cpu.Heatmap).WritePNG,
+			"csv":  (*cpu.Heatmap).WriteCSV,
+			"json": (*cpu.Heatmap).WriteJSON,
+		}[*heatmapFmt]
+		if write == nil {
+			fatal("bad heatmap options", errors.Errorf("unknown format %q", *heatmapFmt))
+		}
+		c.Heatmap = cpu.NewHeatmap()
+		c.Heatmap.Symbols = symbols
+		flushes = append(flushes, func() {
+			f, err := os.Create(*heatmapFile)
+			if err == nil {
+				err = write(c.Heatmap, f)
+				f.Close()
+			}
+			if err != nil {
+				fatal("failed to write heatmap", err)
+			}
+		})
+	}
+
+	if *crashSteps > 0 {
+		var w io.Writer = os.Stderr
+		if *crashFile != "" {
+			f := &lazyFile{path: *crashFile}
+			w = f
+			flushes = append(flushes, f.close)
+		}
+		c.Crash = cpu.NewCrashReport(*crashSteps, w)
+		c.Crash.Symbols = symbols
+	}
+
+	if *stats {
+		idle, err := parseRanges(*statsIdle)
+		if err != nil {
+			fatal("bad statistics options", err)
+		}
+		c.Stats = cpu.NewStats()
+		c.Stats.Idle = idle
+		flushes = append(flushes, func() {
+			c.Stats.WriteText(os.Stderr)
+		})
+	}
+
+	return func() {
+		for _, flush := range flushes {
+			flush()
+		}
+	}
+}
+
+// setupDevices attaches the devices the flags ask for, apart from the terminal
+// DSKY, to c, returning a function which writes out what they gathered once
+// the CPU has stopped running.
+func setupDevices(c *cpu.CPU) func() {
+	var flushes []func()
+	if *downlinkCap != "" || *dlLists != "" {
+		d := downlink.New()
+		if *downlinkCap != "" {
+			w, flush := createOutput(*downlinkCap)
+			d.Capture = w
+			flushes = append(flushes, flush)
+		}
+		if *dlLists != "" {
+			d.Decoder = loadDownlinkLists(*dlLists)
+			d.Lists = os.Stdout
+			if *dlDecoded != "" {
+				w, flush := createOutput(*dlDecoded)
+				d.Lists = w
+				flushes = append(flushes, flush)
+			}
+		}
+		flushes = append(flushes, func() {
+			if err := d.Err(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		})
+		c.Attach(d)
+	}
+
+	if *uplinkFile != "" {
+		u := loadUplink(*uplinkFile)
+		if *uplinkLog != "" {
+			w, flush := createOutput(*uplinkLog)
+			u.Log = w
+			flushes = append(flushes, flush)
+		}
+		c.Attach(u)
+	}
+
+	if *imuFile != "" {
+		c.Attach(loadIMU(*imuFile))
+	}
+
+	if *yaAGCListen != "" {
+		ln, err := net.Listen("tcp", *yaAGCListen)
+		if err != nil {
+			fatal("failed to listen for yaAGC peripherals", err)
+		}
+		server := yaagc.NewServer()
+		go server.Serve(ln)
+		c.Attach(server)
+	}
+
+	if *dskyMode || *yaAGCListen != "" {
+		c.Attach(newPacer())
+	}
+
+	return func() {
+		for _, flush := range flushes {
+			flush()
+		}
+	}
+}
+
+// loadIMU makes an IMU which follows the script in a file.
+func loadIMU(path string) *imu.IMU {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal("failed to open IMU script", err)
+	}
+	defer f.Close()
+	m := imu.New()
+	if err := m.Load(f); err != nil {
+		fatal("bad IMU script", err)
+	}
+	return m
+}
+
+// loadUplink makes an uplink device which sends the script in a file.
+func loadUplink(path string) *uplink.Uplink {
+	f, err := os.Open(path)
+	if err != nil {
+		fatal("failed to open uplink script", err)
+	}
+	defer f.Close()
+	u := uplink.New()
+	if err := u.Load(f); err != nil {
+		fatal("bad uplink script", err)
+	}
+	return u
+}
+
+// loadDownlinkLists makes a decoder for the downlink list definitions in a file.
+func loadDownlinkLists(path string) *downlink.Decoder {
+	d, err := readDownlinkLists(path)
+	if err != nil {
+		fatal("failed to read downlink lists", err)
+	}
+	return d
+}
+
+func readDownlinkLists(path string) (*downlink.Decoder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lists, err := downlink.ReadLists(f)
+	if err != nil {
+		return nil, err
+	}
+	return downlink.NewDecoder(lists)
+}
+
+// createOutput creates a buffered file, returning a function which flushes and closes it.
+func createOutput(path string) (io.Writer, func()) {
+	f, err := os.Create(path)
+	if err != nil {
+		fatal("failed to create output file", err)
+	}
+	w := bufio.NewWriter(f)
+	return w, func() {
+		w.Flush()
+		f.Close()
+	}
+}
+
+// lazyFile creates its file the first time it is written to, for
+// outputs which usually have nothing written to them.
+type lazyFile struct {
+	path string
+	f    *os.File
+}
+
+func (l *lazyFile) Write(p []byte) (int, error) {
+	if l.f == nil {
+		f, err := os.Create(l.path)
+		if err != nil {
+			return 0, err
+		}
+		l.f = f
+	}
+	return l.f.Write(p)
+}
+
+func (l *lazyFile) close() {
+	if l.f != nil {
+		l.f.Close()
+	}
+}
+
+// parseLogOptions parses the values of the logging flags, leaving the output unset.
+func parseLogOptions(events, format, ranges string) (cpu.LogOptions, error) {
+	var opts cpu.LogOptions
+	if events != "" {
+		for _, e := range strings.Split(events, ",") {
+			switch strings.TrimSpace(e) {
+			case "all":
+				opts.Instructions, opts.Timers, opts.Sequences, opts.Interrupts = true, true, true, true
+			case "instructions":
+				opts.Instructions = true
+			case "timers":
+				opts.Timers = true
+			case "sequences":
+				opts.Sequences = true
+			case "interrupts":
+				opts.Interrupts = true
+			default:
+				return opts, errors.Errorf("unknown event %q", e)
+			}
+		}
+	}
+
+	switch format {
+	case "text":
+	case "json":
+		opts.JSON = true
+	default:
+		return opts, errors.Errorf("unknown format %q", format)
+	}
+
+	var err error
+	opts.Ranges, err = parseRanges(ranges)
+	return opts, err
+}
+
+// parseRanges parses comma separated ranges of octal psudo-addresses.
+func parseRanges(ranges string) ([]cpu.AddressRange, error) {
+	if ranges == "" {
+		return nil, nil
+	}
+	var parsed []cpu.AddressRange
+	for _, r := range strings.Split(ranges, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+		start, err := strconv.ParseUint(bounds[0], 8, 16)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad range %q", r)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.ParseUint(bounds[1], 8, 16); err != nil {
+				return nil, errors.Wrapf(err, "bad range %q", r)
+			}
+		}
+		parsed = append(parsed, cpu.AddressRange{Start: uint16(start), End: uint16(end)})
+	}
+	return parsed, nil
 }
 
 func fatal(msg string, err error) {
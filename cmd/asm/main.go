@@ -12,6 +12,7 @@ import (
 func main() {
 	sourceFile := flag.String("source", "", "the assembly source file")
 	outputFile := flag.String("output", "image.bin", "the binary output file")
+	symbolFile := flag.String("symbols", "", "the symbol table output file (used by the debugger)")
 
 	flag.Parse()
 
@@ -41,6 +42,10 @@ func main() {
 			if err := os.Remove(*outputFile); err != nil {
 				log.Println(err)
 			}
+		} else if *symbolFile != "" {
+			if err := writeSymbols(&a, *symbolFile); err != nil {
+				log.Println(err)
+			}
 		}
 	}
 
@@ -48,3 +53,16 @@ func main() {
 		fmt.Println(p)
 	}
 }
+
+func writeSymbols(a *assembler.Assembler, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.SymbolTable().WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,61 @@
+// Command dldecode decodes a downlink capture written by the emulator's
+// -downlink flag into named fields, using downlink list definitions.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Elsewhen-Studios/go-agc/downlink"
+)
+
+func main() {
+	listsFile := flag.String("lists", "", "the JSON file defining the downlink lists")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dldecode -lists <lists> <capture>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *listsFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*listsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lists, err := downlink.ReadLists(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := downlink.NewDecoder(lists)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	capture, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer capture.Close()
+	pairs, err := downlink.ReadCapture(capture)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, p := range pairs {
+		if s := d.Add(p); s != nil {
+			if err := s.WriteText(w); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
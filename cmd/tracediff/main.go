@@ -0,0 +1,125 @@
+// Command tracediff compares two execution traces written by the emulator's
+// -trace flag (or a reference trace rewritten into the same format),
+// reporting the first instruction at which they differ.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	context := flag.Int("context", 5, "the number of matching lines to show before the divergence")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tracediff [-context n] <reference trace> <trace>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ref, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ref.Close()
+	got, err := os.Open(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer got.Close()
+
+	same, err := diff(os.Stdout, ref, got, *context)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !same {
+		os.Exit(1)
+	}
+}
+
+// diff compares the traces line by line, writing a report to w and returning
+// whether they matched. Only the keys both lines have are compared, and the
+// trace may carry on after the reference ends.
+func diff(w io.Writer, ref, got io.Reader, context int) (bool, error) {
+	refLines := bufio.NewScanner(ref)
+	gotLines := bufio.NewScanner(got)
+
+	var history []string
+	for n := 1; ; n++ {
+		refOK, gotOK := refLines.Scan(), gotLines.Scan()
+		if err := refLines.Err(); err != nil {
+			return false, err
+		}
+		if err := gotLines.Err(); err != nil {
+			return false, err
+		}
+
+		if !refOK {
+			// a run can't be stopped at exactly the same instruction
+			// as the reference, so the trace is allowed to go further
+			fmt.Fprintf(w, "traces match for the %d lines of the reference\n", n-1)
+			return true, nil
+		}
+		if !gotOK {
+			fmt.Fprintf(w, "the trace ends after %d lines, before the reference\n", n-1)
+			return false, nil
+		}
+
+		if diffs := compare(parseLine(refLines.Text()), parseLine(gotLines.Text())); len(diffs) > 0 {
+			fmt.Fprintf(w, "traces diverge at line %d: %s\n", n, strings.Join(diffs, ", "))
+			for i, l := range history {
+				fmt.Fprintf(w, "  %d: %s\n", n-len(history)+i, l)
+			}
+			fmt.Fprintf(w, "- %d: %s\n", n, refLines.Text())
+			fmt.Fprintf(w, "+ %d: %s\n", n, gotLines.Text())
+			return false, nil
+		}
+
+		if context > 0 {
+			if len(history) == context {
+				history = history[1:]
+			}
+			history = append(history, gotLines.Text())
+		}
+	}
+}
+
+type field struct {
+	key, value string
+}
+
+// parseLine splits a trace line into its key=value fields, ignoring anything else.
+func parseLine(line string) []field {
+	var fields []field
+	for _, f := range strings.Fields(line) {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			fields = append(fields, field{kv[0], kv[1]})
+		}
+	}
+	return fields
+}
+
+// compare describes each key both lines have whose values differ.
+func compare(ref, got []field) []string {
+	values := make(map[string]string)
+	for _, f := range got {
+		values[f.key] = f.value
+	}
+
+	var diffs []string
+	for _, f := range ref {
+		if v, ok := values[f.key]; ok && v != f.value {
+			diffs = append(diffs, fmt.Sprintf("%s is %s, expected %s", f.key, v, f.value))
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	line1 = "Z=04000 EB=00000 FB=00000 BB=00000 A=000000 L=00000 Q=000000 CYCLES=0"
+	line2 = "Z=04001 EB=00000 FB=00000 BB=00000 A=000005 L=00000 Q=000000 CYCLES=2"
+	line3 = "Z=04002 EB=00000 FB=00000 BB=00000 A=000005 L=00000 Q=000000 CYCLES=4"
+	line4 = "Z=04003 EB=00000 FB=00000 BB=00000 A=000005 L=00000 Q=000000 CYCLES=5"
+)
+
+func TestDiff(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		ref, got []string
+		context  int
+		same     bool
+		report   string
+	}{
+		{
+			name:   "matching",
+			ref:    []string{line1, line2, line3},
+			got:    []string{line1, line2, line3},
+			same:   true,
+			report: "traces match for the 3 lines of the reference\n",
+		},
+		{
+			name:   "reference missing registers",
+			ref:    []string{"Z=04000 A=000000", "Z=04001 A=000005"},
+			got:    []string{line1, line2},
+			same:   true,
+			report: "traces match for the 2 lines of the reference\n",
+		},
+		{
+			name:    "first divergence",
+			ref:     []string{line1, line2, line3, line4},
+			got:     []string{line1, line2, strings.Replace(line3, "A=000005", "A=000006", 1), line1},
+			context: 1,
+			report: "traces diverge at line 3: A is 000006, expected 000005\n" +
+				"  2: " + line2 + "\n" +
+				"- 3: " + line3 + "\n" +
+				"+ 3: " + strings.Replace(line3, "A=000005", "A=000006", 1) + "\n",
+		},
+		{
+			name: "several registers diverge",
+			ref:  []string{line1},
+			got:  []string{"Z=04001 EB=00000 FB=00000 BB=00000 A=000000 L=00000 Q=000000 CYCLES=1"},
+			report: "traces diverge at line 1: Z is 04001, expected 04000, CYCLES is 1, expected 0\n" +
+				"- 1: " + line1 + "\n" +
+				"+ 1: Z=04001 EB=00000 FB=00000 BB=00000 A=000000 L=00000 Q=000000 CYCLES=1\n",
+		},
+		{
+			name:   "trace longer than the reference",
+			ref:    []string{line1, line2},
+			got:    []string{line1, line2, line3, line4},
+			same:   true,
+			report: "traces match for the 2 lines of the reference\n",
+		},
+		{
+			name:   "trace shorter than the reference",
+			ref:    []string{line1, line2, line3},
+			got:    []string{line1},
+			report: "the trace ends after 1 lines, before the reference\n",
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			// arrange
+			ref := strings.NewReader(strings.Join(scenario.ref, "\n"))
+			got := strings.NewReader(strings.Join(scenario.got, "\n"))
+			out := new(bytes.Buffer)
+
+			// act
+			same, err := diff(out, ref, got, scenario.context)
+
+			// assert
+			require.NoError(t, err)
+			assert.Equal(t, scenario.same, same)
+			assert.Equal(t, scenario.report, out.String())
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cpu
+
+// maxFrames bounds the call stack so that code which never
+// returns from its subroutines can't grow it forever.
+const maxFrames = 256
+
+type frameKind int
+
+const (
+	callFrame frameKind = iota
+	interruptFrame
+)
+
+// frame records how execution arrived at a subroutine or interrupt service
+// routine, which is reconstructed from the TC/RETURN linkage through Q and
+// from interrupt entry and RESUME.
+type frame struct {
+	kind frameKind
+	// entry is the psudo-address of the first instruction of the
+	// subroutine or interrupt service routine.
+	entry uint16
+	// from is the psudo-address of the calling TC instruction or
+	// the instruction which was interrupted.
+	from uint16
+	// ret is the CPU address execution will resume at.
+	ret  uint16
+	rupt interrupt
+}
+
+type callStack []frame
+
+func (cs *callStack) push(f frame) {
+	if len(*cs) >= maxFrames {
+		copy(*cs, (*cs)[1:])
+		*cs = (*cs)[:len(*cs)-1]
+	}
+	*cs = append(*cs, f)
+}
+
+// returnTo pops call frames for a return to the given address. Code doesn't
+// always return from every subroutine it calls, so if a frame further down the
+// stack matches the return address then everything above it is discarded.
+// Interrupt frames are never popped by a return.
+func (cs *callStack) returnTo(ret uint16) {
+	s := *cs
+	for i := len(s) - 1; i >= 0 && s[i].kind == callFrame; i-- {
+		if s[i].ret == ret {
+			*cs = s[:i]
+			return
+		}
+	}
+	// no matching frame, assume the innermost call is returning
+	if len(s) > 0 && s[len(s)-1].kind == callFrame {
+		*cs = s[:len(s)-1]
+	}
+}
+
+// resume pops everything up to and including the innermost interrupt frame.
+func (cs *callStack) resume() {
+	s := *cs
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].kind == interruptFrame {
+			*cs = s[:i]
+			return
+		}
+	}
+}
+
+// interrupt returns the interrupt being serviced by the innermost interrupt frame.
+func (cs callStack) interrupt() (interrupt, bool) {
+	for i := len(cs) - 1; i >= 0; i-- {
+		if cs[i].kind == interruptFrame {
+			return cs[i].rupt, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallStackReturnToOuterFrame(t *testing.T) {
+	// arrange
+	var cs callStack
+	cs.push(frame{kind: callFrame, ret: 04001})
+	cs.push(frame{kind: callFrame, ret: 04101})
+	cs.push(frame{kind: callFrame, ret: 04201})
+
+	// act
+	// the inner subroutines never returned, the outer one did
+	cs.returnTo(04001)
+
+	// assert
+	assert.Len(t, cs, 0)
+}
+
+func TestCallStackReturnStopsAtInterrupt(t *testing.T) {
+	// arrange
+	var cs callStack
+	cs.push(frame{kind: callFrame, ret: 04001})
+	cs.push(frame{kind: interruptFrame, ret: 04001})
+
+	// act
+	cs.returnTo(04001)
+
+	// assert
+	if assert.Len(t, cs, 2) {
+		assert.Equal(t, interruptFrame, cs[1].kind)
+	}
+}
+
+func TestCallStackResume(t *testing.T) {
+	// arrange
+	var cs callStack
+	cs.push(frame{kind: callFrame, ret: 04001})
+	cs.push(frame{kind: interruptFrame, ret: 04002})
+	cs.push(frame{kind: callFrame, ret: 04101})
+
+	// act
+	cs.resume()
+
+	// assert
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, uint16(04001), cs[0].ret)
+	}
+}
+
+func TestCallStackBounded(t *testing.T) {
+	// arrange
+	var cs callStack
+
+	// act
+	for i := 0; i < maxFrames+10; i++ {
+		cs.push(frame{kind: callFrame, ret: uint16(i)})
+	}
+
+	// assert
+	if assert.Len(t, cs, maxFrames) {
+		assert.Equal(t, uint16(10), cs[0].ret)
+	}
+}
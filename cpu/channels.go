@@ -0,0 +1,54 @@
+package cpu
+
+const channelCount = 01000
+
+// I/O channel numbers.
+const (
+	chanL        = 001
+	chanQ        = 002
+	chanSUPERBNK = 007
+)
+
+// channelNames holds the names of the channels which have one.
+var channelNames = map[uint16]string{
+	chanL:        "L",
+	chanQ:        "Q",
+	chanSUPERBNK: "SUPERBNK",
+}
+
+type channels [channelCount]uint16
+
+// readChannel gets the value of an I/O channel as it would be loaded into
+// register A. Channels 1 and 2 are the L and Q registers; all of the other
+// channels are 15 bits wide and so get sign extended.
+func (c *CPU) readChannel(ch uint16) uint16 {
+	switch ch {
+	case chanL:
+		return c.reg[regL]
+	case chanQ:
+		return c.reg[regQ]
+	}
+	v := c.chans[ch%channelCount]
+	return v&077777 | (v&040000)<<1
+}
+
+// writeChannel stores a value from register A into an I/O channel. Values
+// written to the 15 bit channels are overflow corrected like memory is.
+func (c *CPU) writeChannel(ch, val uint16) {
+	switch ch {
+	case chanL:
+		c.reg.Set(regL, val)
+		return
+	case chanQ:
+		c.reg.Set(regQ, val)
+		return
+	}
+
+	ch %= channelCount
+	c.history.wroteChannel(ch, c.chans[ch])
+	c.chans[ch] = (val&0100000)>>1 | val&037777
+	if c.events.wants(EventChannel) {
+		c.events.publish(&ChannelEvent{Cycles: c.cycles, Channel: ch, Value: c.chans[ch]})
+	}
+	c.channelWritten(ch)
+}
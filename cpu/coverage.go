@@ -0,0 +1,453 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/Elsewhen-Studios/go-agc/memory"
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+)
+
+// Coverage records which words of memory the CPU executed and, for the
+// instructions which branch on a value (CCS, BZF and BZMF), which of their
+// outcomes happened, so that tests can find the code they didn't exercise.
+//
+// The reports group words by bank, by label and by source line. With a symbol
+// table every word the assembler generated is counted, so constants in fixed
+// memory show up as words which weren't executed; without one only the words
+// which were executed are known.
+//
+// A Coverage must not be reported on while the CPU it is attached to is running.
+type Coverage struct {
+	// Symbols, if set, gives the words, labels and source lines to report on.
+	Symbols *symtab.Table
+
+	// hits counts the executions of each psudo-address
+	hits     []int64
+	branches map[uint16]*branchCoverage
+	// mm is the memory of the CPU, set when it runs
+	mm *memory.Main
+}
+
+type branchCoverage struct {
+	instr    string
+	outcomes []int64
+}
+
+// branchOutcomes names the outcomes of each branching instruction. For CCS
+// they're in the order of the instructions it picks between; for BZF and
+// BZMF the branch being taken comes first.
+var branchOutcomes = map[string][]string{
+	"CCS":  {">0", "+0", "<0", "-0"},
+	"BZF":  {"zero", "non-zero"},
+	"BZMF": {"zero or negative", "positive"},
+}
+
+// NewCoverage creates an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		hits:     make([]int64, 1<<16),
+		branches: make(map[uint16]*branchCoverage),
+	}
+}
+
+// instruction records an execution of the instruction at pa, where skip is
+// how far past the next instruction it sent the CPU.
+func (cv *Coverage) instruction(pa uint16, instr *instruction, skip uint16) {
+	if cv == nil {
+		return
+	}
+	cv.hits[pa]++
+
+	names, ok := branchOutcomes[instr.name]
+	if !ok {
+		return
+	}
+	b := cv.branches[pa]
+	if b == nil {
+		b = &branchCoverage{instr: instr.name, outcomes: make([]int64, len(names))}
+		cv.branches[pa] = b
+	}
+	outcome := int(skip)
+	if instr.name != "CCS" {
+		// BZF and BZMF either branch or carry on
+		outcome = 0
+		if skip == 0 {
+			outcome = 1
+		}
+	}
+	if outcome < len(b.outcomes) {
+		b.outcomes[outcome]++
+	}
+}
+
+// branch returns the outcomes of the branching instruction at pa, which
+// are all zero if it never executed, or nil if it isn't a branch.
+func (cv *Coverage) branch(pa uint16) *branchCoverage {
+	if b := cv.branches[pa]; b != nil {
+		return b
+	}
+	name := cv.instrAt(pa)
+	if outcomes, ok := branchOutcomes[name]; ok {
+		return &branchCoverage{instr: name, outcomes: make([]int64, len(outcomes))}
+	}
+	return nil
+}
+
+// words returns every psudo-address to report on, in order.
+func (cv *Coverage) words() []uint16 {
+	var addrs []uint16
+	if cv.Symbols != nil {
+		addrs = cv.Symbols.Addresses()
+	}
+	known := make(map[uint16]bool, len(addrs))
+	for _, a := range addrs {
+		known[a] = true
+	}
+	// code executed from erasable memory has no source lines
+	for a, n := range cv.hits {
+		if n > 0 && !known[uint16(a)] {
+			addrs = append(addrs, uint16(a))
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// coverageGroup totals the coverage of a group of words.
+type coverageGroup struct {
+	name            string
+	words, executed int
+	outcomes, taken int
+}
+
+func (g *coverageGroup) add(cv *Coverage, pa uint16) {
+	g.words++
+	if cv.hits[pa] > 0 {
+		g.executed++
+	}
+	if b := cv.branch(pa); b != nil {
+		for _, n := range b.outcomes {
+			g.outcomes++
+			if n > 0 {
+				g.taken++
+			}
+		}
+	}
+}
+
+func (g *coverageGroup) String() string {
+	branches := "-"
+	if g.outcomes > 0 {
+		branches = fmt.Sprintf("%d/%d", g.taken, g.outcomes)
+	}
+	return fmt.Sprintf("%-16s %6d %9d %8.1f%% %9s",
+		g.name, g.words, g.executed, 100*float64(g.executed)/float64(g.words), branches)
+}
+
+// group totals the words by the name the key function gives them, keeping
+// the groups in the order their first words appear.
+func (cv *Coverage) group(key func(pa uint16) string) []*coverageGroup {
+	var groups []*coverageGroup
+	byName := make(map[string]*coverageGroup)
+	for _, pa := range cv.words() {
+		name := key(pa)
+		g := byName[name]
+		if g == nil {
+			g = &coverageGroup{name: name}
+			byName[name] = g
+			groups = append(groups, g)
+		}
+		g.add(cv, pa)
+	}
+	return groups
+}
+
+// bankName names the memory bank holding a psudo-address.
+func bankName(pa uint16) string {
+	if pa < 04000 {
+		return fmt.Sprintf("E%o", pa>>8)
+	}
+	bank := pa >> 10
+	if bank >= 4 {
+		// banks 0 and 1 follow the fixed-fixed banks 2 and 3
+		bank -= 4
+	}
+	return fmt.Sprintf("F%02o", bank)
+}
+
+func (cv *Coverage) labelName(pa uint16) string {
+	if cv.Symbols != nil {
+		if name, _, ok := cv.Symbols.Nearest(pa); ok {
+			return name
+		}
+	}
+	return "(" + bankName(pa) + ")"
+}
+
+// WriteText writes a report of the coverage by bank and by label, followed by
+// the branching instructions which didn't see all of their outcomes.
+func (cv *Coverage) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	table := func(title string, groups []*coverageGroup) {
+		fmt.Fprintf(bw, "%-16s %6s %9s %9s %9s\n", title, "WORDS", "EXECUTED", "COVERAGE", "BRANCHES")
+		for _, g := range groups {
+			fmt.Fprintln(bw, g)
+		}
+	}
+	table("BANK", cv.group(bankName))
+	fmt.Fprintln(bw)
+	table("LABEL", cv.group(cv.labelName))
+
+	var partial []uint16
+	for pa, b := range cv.branches {
+		for _, n := range b.outcomes {
+			if n == 0 {
+				partial = append(partial, pa)
+				break
+			}
+		}
+	}
+	sort.Slice(partial, func(i, j int) bool { return partial[i] < partial[j] })
+	if len(partial) > 0 {
+		fmt.Fprintln(bw, "\nPARTIAL BRANCHES")
+	}
+	for _, pa := range partial {
+		b := cv.branches[pa]
+		var missed []string
+		for i, n := range b.outcomes {
+			if n == 0 {
+				missed = append(missed, branchOutcomes[b.instr][i])
+			}
+		}
+		where := cv.Symbols.Symbolize(pa)
+		if loc, ok := cv.line(pa); ok {
+			where += " (" + loc.String() + ")"
+		}
+		fmt.Fprintf(bw, "%s %s never %s\n", where, b.instr, strings.Join(missed, ", "))
+	}
+
+	return bw.Flush()
+}
+
+func (cv *Coverage) line(pa uint16) (symtab.Location, bool) {
+	if cv.Symbols == nil {
+		return symtab.Location{}, false
+	}
+	return cv.Symbols.Line(pa)
+}
+
+// lineCoverage is the coverage of the words generated by a source line.
+type lineCoverage struct {
+	hits     int64
+	branches []*branchCoverage
+}
+
+// files returns the coverage of every source line, by file.
+func (cv *Coverage) files() (names []string, lines map[string]map[int]*lineCoverage) {
+	lines = make(map[string]map[int]*lineCoverage)
+	for _, pa := range cv.words() {
+		loc, ok := cv.line(pa)
+		if !ok {
+			continue
+		}
+		file := lines[loc.File]
+		if file == nil {
+			file = make(map[int]*lineCoverage)
+			lines[loc.File] = file
+			names = append(names, loc.File)
+		}
+		l := file[loc.Line]
+		if l == nil {
+			l = new(lineCoverage)
+			file[loc.Line] = l
+		}
+		// a line generating several words counts as executed as often as any of them
+		if cv.hits[pa] > l.hits {
+			l.hits = cv.hits[pa]
+		}
+		if b := cv.branch(pa); b != nil {
+			l.branches = append(l.branches, b)
+		}
+	}
+	sort.Strings(names)
+	return names, lines
+}
+
+// instrAt decodes the instruction at a psudo-address in fixed memory, to
+// find the branches which never executed. Constants which happen to look
+// like branches are found too, but they're never executed either.
+func (cv *Coverage) instrAt(pa uint16) string {
+	if cv.mm == nil || pa < 04000 {
+		return ""
+	}
+	bank, offset := int(pa>>10), int(pa&01777)
+	if bank >= 4 {
+		bank -= 4
+	}
+	word, err := cv.mm.ReadFixed(bank, offset)
+	if err != nil {
+		return ""
+	}
+	decode := decodeInstruction
+	if offset > 0 {
+		if prev, err := cv.mm.ReadFixed(bank, offset-1); err == nil && prev == 000006 {
+			// the word follows an EXTEND
+			decode = decodeExtendedInstruction
+		}
+	}
+	instr, _, err := decode(word)
+	if err != nil {
+		return ""
+	}
+	return instr.name
+}
+
+func sortedLines(lines map[int]*lineCoverage) []int {
+	nums := make([]int, 0, len(lines))
+	for n := range lines {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// WriteLCOV writes the coverage of the source lines in the lcov tracefile
+// format, with a function for each label.
+func (cv *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	names, lines := cv.files()
+
+	// the functions are the labels with source lines
+	type function struct {
+		name string
+		line int
+		hits int64
+	}
+	funcs := make(map[string][]function)
+	if cv.Symbols != nil {
+		for _, name := range cv.Symbols.Names() {
+			pa, _ := cv.Symbols.Lookup(name)
+			if loc, ok := cv.line(pa); ok {
+				funcs[loc.File] = append(funcs[loc.File], function{name, loc.Line, cv.hits[pa]})
+			}
+		}
+	}
+
+	for _, file := range names {
+		fmt.Fprintln(bw, "TN:")
+		fmt.Fprintf(bw, "SF:%s\n", file)
+
+		hit := 0
+		for _, f := range funcs[file] {
+			fmt.Fprintf(bw, "FN:%d,%s\n", f.line, f.name)
+		}
+		for _, f := range funcs[file] {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", f.hits, f.name)
+			if f.hits > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\nFNH:%d\n", len(funcs[file]), hit)
+
+		found, hit := 0, 0
+		for _, n := range sortedLines(lines[file]) {
+			for i, b := range lines[file][n].branches {
+				for j, count := range b.outcomes {
+					taken := "-"
+					if lines[file][n].hits > 0 {
+						taken = fmt.Sprint(count)
+					}
+					fmt.Fprintf(bw, "BRDA:%d,%d,%d,%s\n", n, i, j, taken)
+					found++
+					if count > 0 {
+						hit++
+					}
+				}
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", found, hit)
+
+		hit = 0
+		for _, n := range sortedLines(lines[file]) {
+			fmt.Fprintf(bw, "DA:%d,%d\n", n, lines[file][n].hits)
+			if lines[file][n].hits > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", len(lines[file]), hit)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// WriteHTML writes a page showing each source file with its lines coloured
+// by whether they were executed, reading the files named in the symbol table.
+func (cv *Coverage) WriteHTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	names, lines := cv.files()
+
+	fmt.Fprint(bw, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>AGC coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { margin: 0; }
+.hit { background: #cfc; }
+.miss { background: #fcc; }
+.partial { background: #ffc; }
+.count { color: #888; display: inline-block; width: 6em; text-align: right; }
+</style></head><body>
+<h1>AGC coverage</h1>
+<pre>`)
+	if err := cv.WriteText(&htmlEscaper{bw}); err != nil {
+		return err
+	}
+	fmt.Fprintln(bw, "</pre>")
+
+	for _, file := range names {
+		fmt.Fprintf(bw, "<h2>%s</h2>\n", html.EscapeString(file))
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(bw, "<p>%s</p>\n", html.EscapeString(err.Error()))
+			continue
+		}
+		for i, text := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+			class, count := "", ""
+			if l := lines[file][i+1]; l != nil {
+				class, count = "miss", fmt.Sprint(l.hits)
+				if l.hits > 0 {
+					class = "hit"
+					for _, b := range l.branches {
+						for _, n := range b.outcomes {
+							if n == 0 {
+								class = "partial"
+							}
+						}
+					}
+				}
+			}
+			fmt.Fprintf(bw, "<pre class=%q><span class=\"count\">%s</span> %5d  %s</pre>\n",
+				class, count, i+1, html.EscapeString(text))
+		}
+	}
+
+	fmt.Fprintln(bw, "</body></html>")
+	return bw.Flush()
+}
+
+// htmlEscaper escapes everything written through it for HTML.
+type htmlEscaper struct {
+	w io.Writer
+}
+
+func (e *htmlEscaper) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(e.w, html.EscapeString(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,110 @@
+package cpu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countdownProgram counts the word at 0100 down to zero and then loops forever.
+var countdownProgram = []uint16{
+	010100, // 04000 LOOP CCS    0100
+	014005, // 04001      TCF    MORE
+	014002, // 04002      TCF    04002
+	014000, // 04003      TCF    LOOP
+	014000, // 04004      TCF    LOOP
+	054100, // 04005 MORE TS     0100
+	014000, // 04006      TCF    LOOP
+}
+
+func newCoverageTest(t *testing.T) *CPU {
+	c := newTestCPU(t, countdownProgram...)
+	require.NoError(t, c.mm.Write(0100, 2))
+	c.Coverage = NewCoverage()
+	c.Coverage.mm = c.mm.mm
+	c.Coverage.Symbols = symtab.New()
+	c.Coverage.Symbols.Define("LOOP", 04000)
+	c.Coverage.Symbols.Define("MORE", 04005)
+	for i := 0; i < len(countdownProgram); i++ {
+		c.Coverage.Symbols.SetLine(uint16(04000+i), symtab.Location{File: "count.agc", Line: i + 1})
+	}
+	return c
+}
+
+func TestCoverageBranches(t *testing.T) {
+	// arrange
+	c := newCoverageTest(t)
+
+	// act
+	for i := 0; i < 10; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, int64(3), c.Coverage.hits[04000], "CCS")
+	assert.Equal(t, int64(1), c.Coverage.hits[04002], "+0 branch")
+	assert.Zero(t, c.Coverage.hits[04003], "<0 branch")
+	require.Contains(t, c.Coverage.branches, uint16(04000))
+	assert.Equal(t, []int64{2, 1, 0, 0}, c.Coverage.branches[04000].outcomes)
+}
+
+func TestCoverageReplay(t *testing.T) {
+	// arrange
+	c := newCoverageTest(t)
+	c.RecordHistory(20)
+	c.Debugger = &rewindingDebugger{pa: 04002}
+
+	// act
+	for i := 0; i < 10+8; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, int64(3), c.Coverage.hits[04000], "CCS")
+	assert.Equal(t, []int64{2, 1, 0, 0}, c.Coverage.branches[04000].outcomes)
+}
+
+func TestCoverageWriteText(t *testing.T) {
+	// arrange
+	c := newCoverageTest(t)
+	for i := 0; i < 10; i++ {
+		c.step()
+	}
+	out := new(bytes.Buffer)
+
+	// act
+	err := c.Coverage.WriteText(out)
+
+	// assert
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "F02                   7         5     71.4%       2/4\n")
+	assert.Contains(t, out.String(), "LOOP                  5         3     60.0%       2/4\n")
+	assert.Contains(t, out.String(), "LOOP (count.agc:1) CCS never <0, -0\n")
+}
+
+func TestCoverageWriteLCOV(t *testing.T) {
+	// arrange
+	c := newCoverageTest(t)
+	for i := 0; i < 10; i++ {
+		c.step()
+	}
+	out := new(bytes.Buffer)
+
+	// act
+	err := c.Coverage.WriteLCOV(out)
+
+	// assert
+	require.NoError(t, err)
+	for _, want := range []string{
+		"SF:count.agc\n",
+		"FN:1,LOOP\n", "FNDA:3,LOOP\n", "FNDA:2,MORE\n",
+		"BRDA:1,0,0,2\n", "BRDA:1,0,1,1\n", "BRDA:1,0,3,0\n",
+		"DA:3,1\n", "DA:4,0\n", "LF:7\nLH:5\n",
+		"end_of_record\n",
+	} {
+		assert.Contains(t, out.String(), want)
+	}
+}
@@ -1,15 +1,19 @@
 package cpu
 
 import (
-	"fmt"
+	"io"
+	"sync/atomic"
 
 	"github.com/Elsewhen-Studios/go-agc/memory"
+	"github.com/pkg/errors"
 )
 
 const (
-	interval10ms  = 893
-	interval7_5ms = interval10ms * 3 / 4
-	interval5ms   = interval10ms / 2
+	// CyclesPer10ms is the number of memory cycles (MCTs) of 11.72µs in
+	// 10ms, the period of the timers. Devices use it to keep time with the CPU.
+	CyclesPer10ms = 853
+	interval7_5ms = CyclesPer10ms * 3 / 4
+	interval5ms   = CyclesPer10ms / 2
 )
 
 type interrupt int
@@ -20,16 +24,83 @@ const (
 	intT5RUPT
 	intT3RUPT
 	intT4RUPT
+	intKEYRUPT1
+	intKEYRUPT2
+	intUPRUPT
+	intDOWNRUPT
+	intRADARUPT
+	intHANDRUPT
+	interruptCount
 )
 
+var interruptNames = [interruptCount]string{
+	"BOOT", "T6RUPT", "T5RUPT", "T3RUPT", "T4RUPT", "KEYRUPT1",
+	"KEYRUPT2", "UPRUPT", "DOWNRUPT", "RADARUPT", "HANDRUPT",
+}
+
+func (i interrupt) String() string {
+	if i < 0 || i >= interruptCount {
+		return "RUPT?"
+	}
+	return interruptNames[i]
+}
+
 // CPU simulates the core logic of the AGC.
 type CPU struct {
 	mm redirectedMemory
 
-	reg        registers
-	intsOff    bool
-	pendingInt *interrupt
+	reg         registers
+	chans       channels
+	extended    bool
+	intsOff     bool
+	inRupt      bool
+	pendingInts uint16
+	frames      callStack
+
+	timers           []*timer
+	pendingSequences []*sequence
+	cycles           uint64
 
+	// Logging chooses what is logged while the CPU runs.
+	Logging LogOptions
+	// Trace, if set, receives a line of register values for every
+	// instruction executed, in a format which can be compared to yaAGC.
+	Trace io.Writer
+	// Profile, if set, counts the cycles spent in each call stack.
+	Profile *Profile
+	// Coverage, if set, records the code executed.
+	Coverage *Coverage
+	// VCD, if set, records waveforms of the channels, counters and interrupts.
+	VCD *VCD
+	// Timeline, if set, records the interrupts and subroutines over time.
+	Timeline *Timeline
+	// Heatmap, if set, counts the reads, writes and executions of every word.
+	Heatmap *Heatmap
+	// Crash, if set, reports the state of the machine and the last steps
+	// in its history if Run panics.
+	Crash *CrashReport
+	// Stats, if set, gathers statistics about interrupts and idling.
+	Stats *Stats
+	// CycleLimit, if not zero, stops Run once the CPU has
+	// executed this many memory cycles.
+	CycleLimit uint64
+
+	events  eventBus
+	devices []Device
+	log     *logger
+	history *history
+	rewound bool
+	halted  bool
+	// stopped is set by Stop from another goroutine
+	stopped int32
+	// steps counts the steps taken, going back as the debugger undoes them,
+	// and frontier is the furthest it has got. Steps up to the frontier are
+	// being replayed, and mustn't be counted twice by anything watching.
+	steps    uint64
+	frontier uint64
+	replay   bool
+	// jam is the cause of a GOJAM which will happen at the start of the next step
+	jam      string
 	Debugger Debugger
 }
 
@@ -42,92 +113,295 @@ func NewCPU(mem *memory.Main) *CPU {
 	var cpu CPU
 	cpu.mm.reg = &cpu.reg
 	cpu.mm.mm = mem
+	cpu.mm.chans = &cpu.chans
+	cpu.timers = []*timer{
+		newTimer("TIME1", CyclesPer10ms, 0, &usPINCTime1),
+		newTimer("TIME3", CyclesPer10ms, 0, &usPINCTime3),
+		newTimer("TIME4", CyclesPer10ms, -interval7_5ms, &usPINCTime4),
+		newTimer("TIME5", CyclesPer10ms, -interval5ms, &usPINCTime5),
+	}
 	cpu.Debugger = new(noDebugger)
 	return &cpu
 }
 
-// Run executes instructions from main memory.
+// Run executes instructions from main memory until the debugger
+// quits, the cycle limit is reached or Stop is called.
 func (c *CPU) Run() {
+	defer c.Crash.recover(c)
 	c.reg.Set(regZ, 04000)
-	var (
-		pendingSequences []*sequence
-		timers           = map[*sequence]*timer{
-			&usPINCTime1: newTimer("TIME1", interval10ms, 0),
-			&usPINCTime3: newTimer("TIME3", interval10ms, 0),
-			&usPINCTime4: newTimer("TIME4", interval10ms, -interval7_5ms),
-			&usPINCTime5: newTimer("TIME5", interval10ms, -interval5ms),
-		}
-	)
-	log := newLogger(1000)
-	go log.process()
-	defer log.stop()
-
-	for {
-		var timing int
-
-		// check for any pending unprogrammed sequences
-		if len(pendingSequences) > 0 {
-			seq := pendingSequences[len(pendingSequences)-1]
-			pendingSequences = pendingSequences[:len(pendingSequences)-1]
-
-			log.log(uSequenceEvent{seq: seq})
-			if subSeq := seq.execute(c, seq); subSeq != nil {
-				pendingSequences = append(pendingSequences, subSeq)
-			}
+	c.log = newLogger(c.Logging)
+	if c.Coverage != nil {
+		c.Coverage.mm = c.mm.mm
+	}
+	c.Heatmap.attach(c)
+	c.Crash.attach(c)
 
-			timing = seq.timing
-		} else {
-			z := c.reg[regZ]
-			val, err := c.mm.Read(int(z))
-			if err != nil {
-				panic(err)
-			}
+	for !c.halted && (c.CycleLimit == 0 || c.cycles < c.CycleLimit) && atomic.LoadInt32(&c.stopped) == 0 {
+		c.step()
+	}
+}
 
-			instr, address, err := decodeInstruction(val)
-			if err != nil {
-				panic(fmt.Sprintf("failed to decode instruction at %05o: %v", z, err))
-			}
-			c.Debugger.Debug(DebugEvent{
-				z:       z,
-				code:    val,
-				instr:   &instr,
-				address: address,
-			})
+// Stop makes Run return after the current step. It can be called from
+// any goroutine.
+func (c *CPU) Stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+}
 
-			// now increment the PC counter
-			c.reg[regZ]++
+// step executes either a single instruction or, if there are any
+// pending, an unprogrammed sequence.
+func (c *CPU) step() {
+	var timing int
 
-			if err := instr.execute(c, &instr, address); err != nil {
-				panic(err)
-			}
+	c.history.record(c)
+	c.steps++
+	c.replay = c.steps <= c.frontier
+	if !c.replay {
+		for _, d := range c.devices {
+			d.Step(deviceIO{c})
+		}
+		c.VCD.sample(c)
+		c.Timeline.sample(c)
+	}
+
+	if c.jam != "" {
+		c.gojam(c.jam)
+		return
+	}
 
-			timing = instr.timing
+	// check for any pending unprogrammed sequences
+	if len(c.pendingSequences) > 0 {
+		seq := c.pendingSequences[len(c.pendingSequences)-1]
+		c.pendingSequences = c.pendingSequences[:len(c.pendingSequences)-1]
+
+		if c.debug(DebugEvent{kind: evCounter, name: seq.counter.String()}) {
+			return
 		}
 
-		// increment our timers by the amount of cycles
-		for useq, tmr := range timers {
-			if tmr.Inc(timing) {
-				// timer rolled over, queue up
-				// the unprogrammed sequence
-				pendingSequences = append(pendingSequences, useq)
-				log.log(timerEvent{name: tmr.n})
+		c.history.sequence(seq)
+		c.log.sequence(c.cycles, seq)
+		if !c.replay {
+			c.Profile.sequence(seq, seq.timing)
+			c.Timeline.counter(c.cycles, seq)
+		}
+		if subSeq := seq.execute(c, seq); subSeq != nil {
+			c.pendingSequences = append(c.pendingSequences, subSeq)
+		}
+		if !c.replay {
+			c.Stats.sequence(seq.timing, c.intsOff)
+		}
+		if c.events.wants(EventCounter) {
+			c.events.publish(&CounterEvent{
+				Cycles:   c.cycles,
+				Counter:  seq.counter.String(),
+				Sequence: seq.name,
+				Value:    c.reg[seq.counter],
+			})
+		}
+
+		timing = seq.timing
+	} else {
+		z := c.reg[regZ]
+		val, err := c.mm.peek(int(z))
+		if err != nil {
+			c.fault(err)
+		}
+
+		decode := decodeInstruction
+		if c.extended {
+			decode = decodeExtendedInstruction
+		}
+		instr, address, err := decode(val)
+		if err != nil {
+			c.fault(errors.Wrapf(err, "failed to decode instruction at %05o", z))
+		}
+		if c.debug(DebugEvent{
+			kind:    evInstruction,
+			code:    val,
+			instr:   &instr,
+			address: address,
+		}) {
+			return
+		}
+		pa := c.psudoAddress(z)
+		c.history.instruction(pa, val)
+		c.log.instruction(c.cycles, z, pa, val, &instr, address)
+		c.trace()
+		if !c.replay {
+			c.Profile.instruction(pa, c.frames, instr.timing)
+			c.Heatmap.instruction(c, pa)
+		}
+
+		// now increment the PC counter
+		c.reg[regZ]++
+
+		// EXTEND only applies to the instruction following it
+		c.extended = false
+		inRupt := c.inRupt
+		rupt, _ := c.frames.interrupt()
+		usual := instr.timing
+		if err := instr.execute(c, &instr, address); err != nil {
+			c.fault(err)
+		}
+		if c.events.wants(EventInstruction) {
+			c.events.publish(&InstructionEvent{
+				Cycles:  c.cycles,
+				Z:       z,
+				Address: pa,
+				Code:    val,
+				Name:    instr.name,
+				Operand: address,
+			})
+		}
+		if !c.replay {
+			if instr.timing > usual {
+				c.Profile.longer(instr.timing - usual)
 			}
+			c.Coverage.instruction(pa, &instr, (c.reg[regZ]-z-1)&07777)
+			c.Stats.instruction(pa, c.reg[regZ] == z, instr.timing, c.intsOff)
 		}
+		if inRupt && !c.inRupt {
+			// only RESUME ends an interrupt
+			c.history.transition(resumed, c.cycles, rupt.String())
+			if !c.replay {
+				c.Stats.resume(rupt, c.cycles+uint64(instr.timing))
+			}
+			if c.events.wants(EventResume) {
+				c.events.publish(&ResumeEvent{Cycles: c.cycles, Name: rupt.String()})
+			}
+			if c.debug(DebugEvent{kind: evResume, name: rupt.String()}) {
+				return
+			}
+		}
+
+		timing = instr.timing
+	}
+	c.cycles += uint64(timing)
+	c.advance()
 
-		if c.pendingInt != nil {
-			z := c.reg[regZ]
-			c.reg.Set(regZRUPT, z)
-			val, err := c.mm.Read(int(z))
-			if err != nil {
-				panic(err)
+	// increment our timers by the amount of cycles
+	for _, tmr := range c.timers {
+		if tmr.Inc(timing) {
+			// timer rolled over, queue up
+			// the unprogrammed sequence
+			c.pendingSequences = append(c.pendingSequences, tmr.seq)
+			c.log.timer(c.cycles, tmr.n)
+			if !c.replay {
+				c.Timeline.timer(c.cycles, tmr.n)
+			}
+			if c.debug(DebugEvent{kind: evTimer, name: tmr.n}) {
+				return
 			}
-			c.reg.Set(regBRUPT, val)
-			c.reg.Set(regZ, 04000+uint16(*c.pendingInt)*4)
-			fmt.Printf("INT! %04o - ZRUPT:%05o BRUPT:%05o\n", *c.pendingInt, z, val)
-			c.intsOff = true
-			c.pendingInt = nil
 		}
 	}
+
+	// interrupts can't split an extended instruction from its EXTEND
+	if c.pendingInts != 0 && !c.intsOff && !c.inRupt && !c.extended {
+		c.enterInterrupt()
+	}
+}
+
+// advance moves the frontier up to a step once everything watching the CPU
+// has been told about it.
+func (c *CPU) advance() {
+	if c.steps > c.frontier {
+		c.frontier = c.steps
+	}
+}
+
+// debug tells the debugger about an event at the current address. It returns
+// true if the rest of the step has to be abandoned because the debugger halted
+// the CPU, moved it back in time or scheduled a GOJAM.
+func (c *CPU) debug(e DebugEvent) bool {
+	e.cpu = c
+	e.z = c.reg[regZ]
+	e.pa = c.psudoAddress(e.z)
+	c.Debugger.Debug(e)
+
+	if c.rewound {
+		// the debugger moved the CPU back in time, so
+		// start over with the restored state
+		c.rewound = false
+		return true
+	}
+	return c.halted || c.jam != ""
+}
+
+// enterInterrupt services the highest priority pending interrupt by saving
+// the address and instruction which would have executed next and jumping
+// to the interrupt's vector. Further interrupts are held off until RESUME.
+func (c *CPU) enterInterrupt() {
+	var i interrupt
+	for c.pendingInts&(1<<uint(i)) == 0 {
+		i++
+	}
+	c.pendingInts &^= 1 << uint(i)
+	if !c.replay {
+		c.Stats.enter(i, c.cycles)
+	}
+
+	z := c.reg[regZ]
+	c.reg.Set(regZRUPT, z)
+	val, err := c.mm.peek(int(z))
+	if err != nil {
+		c.fault(err)
+	}
+	c.reg.Set(regBRUPT, val)
+	c.reg.Set(regZ, 04000+uint16(i)*4)
+	c.log.interrupt(c.cycles, i, z, val)
+	if c.events.wants(EventInterrupt) {
+		c.events.publish(&InterruptEvent{Cycles: c.cycles, Name: i.String(), ZRUPT: z, BRUPT: val})
+	}
+	c.inRupt = true
+	c.history.transition(enteredInterrupt, c.cycles, i.String())
+
+	c.frames.push(frame{
+		kind:  interruptFrame,
+		entry: c.psudoAddress(c.reg[regZ]),
+		from:  c.psudoAddress(z),
+		ret:   z,
+		rupt:  i,
+	})
+	c.debug(DebugEvent{kind: evInterrupt, name: i.String()})
+}
+
+// fault publishes a FaultEvent for an error the emulator can't recover
+// from and then panics with it.
+func (c *CPU) fault(err error) {
+	if c.events.wants(EventFault) {
+		c.events.publish(&FaultEvent{Cycles: c.cycles, Z: c.reg[regZ], Err: err.Error()})
+	}
+	panic(err)
+}
+
+// gojamChannels are the output channels cleared by a GOJAM.
+var gojamChannels = []uint16{005, 006, 010, 011, 012, 013, 014, 034, 035}
+
+// gojam restarts the AGC the way the hardware does when it detects a fault:
+// pending interrupts and counters are dropped, the output channels are
+// cleared and execution starts over at 04000 (the BOOT vector).
+func (c *CPU) gojam(cause string) {
+	c.jam = ""
+	if !c.replay {
+		c.VCD.jam(c.cycles)
+		c.Stats.gojam()
+	}
+	if c.events.wants(EventGOJAM) {
+		c.events.publish(&GOJAMEvent{Cycles: c.cycles, Cause: cause})
+	}
+	c.pendingInts = 0
+	c.pendingSequences = c.pendingSequences[:0]
+	c.inRupt = false
+	c.intsOff = false
+	c.extended = false
+	c.frames = c.frames[:0]
+	for _, ch := range gojamChannels {
+		c.writeChannel(ch, 0)
+	}
+	c.reg.Set(regZ, 04000)
+	c.history.transition(jammed, c.cycles, cause)
+	c.advance()
+
+	c.debug(DebugEvent{kind: evGojam, name: cause})
 }
 
 // overflow returns +1 if a positive overflow has ocurred, -1 if a negative overflow
@@ -145,6 +419,54 @@ func (c *CPU) overflow() int {
 	return 0
 }
 
+// psudoAddress converts an address as seen by the CPU into the psudo-address
+// used by the assembler, resolving switched banks using the bank registers.
+func (c *CPU) psudoAddress(addr uint16) uint16 {
+	switch {
+	case addr < 01400:
+		// unswitched erasable
+		return addr
+	case addr < 02000:
+		// switched erasable
+		return c.reg[regEB]&03400 | addr&0377
+	case addr < 04000:
+		// switched fixed, the psudo-addresses of banks 0 and 1 follow
+		// those of the fixed-fixed banks (2 and 3) which keep their
+		// CPU addresses, so every bank other than 2 and 3 is offset
+		bank := c.reg[regFB] >> 10
+		if bank >= 030 && c.chans[chanSUPERBNK]&0100 != 0 {
+			// the super-bit swaps in banks 040 - 047
+			bank += 010
+		}
+		if bank == 2 || bank == 3 {
+			return bank<<10 | addr&01777
+		}
+		return (bank+4)<<10 | addr&01777
+	default:
+		// fixed-fixed
+		return addr
+	}
+}
+
+// cpuAddress converts a psudo-address into an address the CPU can use with
+// the current bank registers, returning false if the bank isn't selected.
+func (c *CPU) cpuAddress(pa uint16) (uint16, bool) {
+	switch {
+	case pa < 01400 || (pa >= 04000 && pa < 010000):
+		// unswitched addresses are the same for both
+		return pa, true
+	case pa < 04000:
+		addr := 01400 | pa&0377
+		return addr, c.psudoAddress(addr) == pa
+	default:
+		addr := 02000 | pa&01777
+		return addr, c.psudoAddress(addr) == pa
+	}
+}
+
 func (c *CPU) interrupt(i interrupt) {
-	c.pendingInt = &i
+	if !c.replay {
+		c.Stats.request(i, c.cycles)
+	}
+	c.pendingInts |= 1 << uint(i)
 }
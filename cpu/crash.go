@@ -0,0 +1,183 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+)
+
+// CrashReport writes a report of the state of the machine to Output if Run
+// panics, because of a bad instruction or a bug in the emulator, before
+// panicking again. The report includes the last steps the CPU took, with the
+// registers before each instruction and the interrupts entered and left, from
+// the CPU's history.
+type CrashReport struct {
+	// Symbols, if set, labels the addresses in the report.
+	Symbols *symtab.Table
+	// Output receives the report.
+	Output io.Writer
+	// Steps is the number of steps to include. If the CPU isn't recording
+	// that much history already it starts doing so, at the cost of a
+	// snapshot of the machine every step.
+	Steps int
+}
+
+// NewCrashReport creates a CrashReport including the last steps steps and
+// writing the report to w.
+func NewCrashReport(steps int, w io.Writer) *CrashReport {
+	return &CrashReport{Output: w, Steps: steps}
+}
+
+// attach makes sure c records enough history for the report.
+func (cr *CrashReport) attach(c *CPU) {
+	if cr == nil || cr.Steps <= 0 {
+		return
+	}
+	if c.history == nil || len(c.history.ring) < cr.Steps {
+		c.RecordHistory(cr.Steps)
+	}
+}
+
+// recover is deferred by Run. If the CPU panicked it writes the report and
+// then carries on panicking.
+func (cr *CrashReport) recover(c *CPU) {
+	if cr == nil {
+		return
+	}
+	r := recover()
+	if r == nil {
+		return
+	}
+	cr.write(c, r, debug.Stack())
+	panic(r)
+}
+
+func (cr *CrashReport) location(pa uint16) string {
+	s := fmt.Sprintf("%05o", pa)
+	if cr.Symbols == nil {
+		return s
+	}
+	if _, _, ok := cr.Symbols.Nearest(pa); ok {
+		s += " " + cr.Symbols.Symbolize(pa)
+	}
+	if loc, ok := cr.Symbols.Line(pa); ok {
+		s += " (" + loc.String() + ")"
+	}
+	return s
+}
+
+func (cr *CrashReport) write(c *CPU, fault interface{}, stack []byte) {
+	w := bufio.NewWriter(cr.Output)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "AGC fault after %d MCTs: %v\n", c.cycles, fault)
+	fmt.Fprintf(w, "at %s\n", cr.location(c.psudoAddress(c.reg[regZ])))
+
+	fmt.Fprintln(w, "\nRegisters:")
+	for r := register(0); int(r) < len(registerNames); r++ {
+		sep := "  "
+		if r%6 == 5 || int(r) == len(registerNames)-1 {
+			sep = "\n"
+		}
+		fmt.Fprintf(w, "  %-10s %06o%s", r.String()+"=", c.reg[r], sep)
+	}
+
+	fmt.Fprintln(w, "\nBanks:")
+	superbank := c.chans[chanSUPERBNK]&0100 != 0
+	fmt.Fprintf(w, "  erasable E%o, fixed %s, superbank bit %t\n",
+		c.reg[regEB]>>8&07, bankName(c.psudoAddress(02000)), superbank)
+
+	fmt.Fprintln(w, "\nInterrupts:")
+	var pending []string
+	for i := interrupt(0); i < interruptCount; i++ {
+		if c.pendingInts&(1<<uint(i)) != 0 {
+			pending = append(pending, i.String())
+		}
+	}
+	if len(pending) == 0 {
+		pending = []string{"none"}
+	}
+	fmt.Fprintf(w, "  pending %s\n", strings.Join(pending, ", "))
+	fmt.Fprintf(w, "  inhibited %t, in interrupt %t, extended %t\n", c.intsOff, c.inRupt, c.extended)
+
+	if len(c.frames) > 0 {
+		fmt.Fprintln(w, "\nCall stack:")
+		for i := len(c.frames) - 1; i >= 0; i-- {
+			f := c.frames[i]
+			switch f.kind {
+			case callFrame:
+				fmt.Fprintf(w, "  %s called from %s\n", cr.location(f.entry), cr.location(f.from))
+			case interruptFrame:
+				fmt.Fprintf(w, "  in %s, interrupted at %s\n", f.rupt, cr.location(f.from))
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "\nChannels (the rest are zero):")
+	for ch := uint16(3); ch < channelCount; ch++ {
+		if c.chans[ch] != 0 {
+			name := channelNames[ch]
+			if name != "" {
+				name = " " + name
+			}
+			fmt.Fprintf(w, "  ch%03o%s = %05o\n", ch, name, c.chans[ch])
+		}
+	}
+
+	cr.writeSteps(w, c)
+
+	fmt.Fprintf(w, "\nEmulator stack:\n%s", stack)
+}
+
+// writeSteps writes the last steps recorded in the CPU's history.
+func (cr *CrashReport) writeSteps(w io.Writer, c *CPU) {
+	h := c.history
+	if h == nil || cr.Steps <= 0 {
+		return
+	}
+	n := h.count
+	if n > cr.Steps {
+		n = cr.Steps
+	}
+	var steps []*snapshot
+	for i := n; i > 0; i-- {
+		s := &h.ring[(h.next+len(h.ring)-i)%len(h.ring)]
+		if s.executed || s.seq != nil || len(s.transitions) > 0 {
+			steps = append(steps, s)
+		}
+	}
+
+	fmt.Fprintf(w, "\nLast %d steps, oldest first:\n", len(steps))
+	for _, s := range steps {
+		switch {
+		case s.executed:
+			decode := decodeInstruction
+			if s.extended {
+				decode = decodeExtendedInstruction
+			}
+			name, operand := "?", uint16(0)
+			if instr, address, err := decode(s.code); err == nil {
+				name, operand = instr.name, address
+			}
+			fmt.Fprintf(w, "  %10d  %-32s %05o %-6s %04o  A=%06o L=%05o Q=%06o EB=%05o FB=%05o\n",
+				s.cycles, cr.location(s.pa), s.code, name, operand,
+				s.reg[regA], s.reg[regL], s.reg[regQ], s.reg[regEB], s.reg[regFB])
+		case s.seq != nil:
+			fmt.Fprintf(w, "  %10d  %s\n", s.cycles, s.seq.name)
+		}
+		for _, t := range s.transitions {
+			switch t.kind {
+			case enteredInterrupt:
+				fmt.Fprintf(w, "  %10d  --> %s\n", t.cycles, t.name)
+			case resumed:
+				fmt.Fprintf(w, "  %10d  <-- %s\n", t.cycles, t.name)
+			case jammed:
+				fmt.Fprintf(w, "  %10d  GOJAM (%s)\n", t.cycles, t.name)
+			}
+		}
+	}
+}
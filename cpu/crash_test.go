@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrashReport(t *testing.T) {
+	// arrange
+	c := newTestCPU(t,
+		030100, // 04000 START CA     0100
+		030100, // 04001       CA     0100
+		020000, // 04002       (not an instruction)
+	)
+	require.NoError(t, c.mm.Write(0100, 012345))
+	out := new(bytes.Buffer)
+	c.Crash = NewCrashReport(10, out)
+	c.Crash.Symbols = symtab.New()
+	c.Crash.Symbols.Define("START", 04000)
+	c.pendingInts = 1 << uint(intT4RUPT)
+	c.intsOff = true
+	c.chans[010] = 01234
+
+	// act
+	var fault interface{}
+	func() {
+		defer func() { fault = recover() }()
+		c.Run()
+	}()
+
+	// assert
+	err, ok := fault.(error)
+	require.True(t, ok, "Run should panic with the error")
+	assert.EqualError(t, err, "failed to decode instruction at 04002: bad instruction: 20000")
+	report := out.String()
+	for _, want := range []string{
+		"AGC fault after 4 MCTs: failed to decode instruction at 04002: bad instruction: 20000\n",
+		"at 04002 START+2\n",
+		"  A=         012345",
+		"  erasable E0, fixed F00, superbank bit false\n",
+		"  pending T4RUPT\n",
+		"  ch010 = 01234\n",
+		"Last 2 steps, oldest first:\n",
+		"           0  04000 START                      30100 CA     0100  A=000000",
+		"           2  04001 START+1                    30100 CA     0100  A=012345",
+		"Emulator stack:\n",
+	} {
+		assert.Contains(t, report, want)
+	}
+}
+
+func TestCrashReportSteps(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	out := new(bytes.Buffer)
+	c.Crash = NewCrashReport(3, out)
+	c.Crash.attach(c)
+	c.reg.Set(regTIME4, 077777)
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+
+	// act
+	c.Crash.write(c, "fault", nil)
+
+	// assert
+	report := out.String()
+	i := strings.Index(report, "Last 3 steps, oldest first:\n")
+	require.NotEqual(t, -1, i, report)
+	lines := strings.Split(report[i:], "\n")[1:6]
+	assert.Contains(t, lines[0], "14000 TCF")
+	assert.Contains(t, lines[1], "PINC TIME4")
+	assert.Contains(t, lines[2], "--> T4RUPT")
+	assert.Contains(t, lines[3], "50017 RESUME")
+	assert.Contains(t, lines[4], "<-- T4RUPT")
+}
+
+func TestCrashReportWithoutSteps(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	out := new(bytes.Buffer)
+	c.Crash = NewCrashReport(0, out)
+	c.Crash.attach(c)
+	c.step()
+
+	// act
+	c.Crash.write(c, "fault", nil)
+
+	// assert
+	assert.Nil(t, c.history, "the report shouldn't record history it won't use")
+	assert.NotContains(t, out.String(), "Last")
+}
@@ -0,0 +1,792 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/pkg/errors"
+)
+
+// DAPLauncher loads the program named in the arguments of a Debug Adapter
+// Protocol launch request, returning a CPU ready to run it along with the
+// program's symbols (which may be nil).
+type DAPLauncher func(args json.RawMessage) (*CPU, *symtab.Table, error)
+
+// ServeDAP speaks the Debug Adapter Protocol used by editors such as VS Code
+// on r and w, driving a DebugEngine for the program the client launches.
+// Breakpoints and stack frames are mapped to the source lines recorded in the
+// symbol table, and the registers, erasable banks and I/O channels are shown
+// as variable scopes. Besides the arguments the launcher understands, launch
+// accepts stopOnEntry and cwd (which relative source paths are resolved
+// against). It returns once the client disconnects.
+func ServeDAP(r io.Reader, w io.Writer, launch DAPLauncher) error {
+	s := &dapServer{
+		r:           bufio.NewReader(r),
+		w:           w,
+		launch:      launch,
+		replies:     make(chan dapReply, 1),
+		done:        make(chan struct{}),
+		breakpoints: make(map[string][]uint16),
+	}
+	return s.serve()
+}
+
+const dapThreadID = 1
+
+// variable references for the scopes, the erasable banks
+// are numbered from dapErasableBank upwards
+const (
+	dapRegisters = 1 + iota
+	dapErasable
+	dapChannels
+	dapErasableBank = 0100
+)
+
+var (
+	errNotLaunched = errors.New("no program has been launched")
+	errExited      = errors.New("the program has exited")
+)
+
+type dapServer struct {
+	r      *bufio.Reader
+	launch DAPLauncher
+
+	wmu sync.Mutex
+	w   io.Writer
+	seq int
+
+	cpu         *CPU
+	engine      *DebugEngine
+	cwd         string
+	stopOnEntry bool
+	replies     chan dapReply
+	// done is closed when the CPU stops running
+	done chan struct{}
+	// breakpoints holds the addresses of the breakpoints in each source file
+	breakpoints map[string][]uint16
+
+	// the client can't be told the CPU stopped until it has finished
+	// configuring breakpoints, or before the response to the request
+	// which moved the CPU, so until then the stop is held in pending
+	mu         sync.Mutex
+	configured bool
+	busy       bool
+	pending    *stopEvent
+	stops      int
+}
+
+type dapReply struct {
+	output string
+	err    error
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type dapBreakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID                          int        `json:"id"`
+	Name                        string     `json:"name"`
+	Source                      *dapSource `json:"source,omitempty"`
+	Line                        int        `json:"line"`
+	Column                      int        `json:"column"`
+	InstructionPointerReference string     `json:"instructionPointerReference"`
+}
+
+type dapScope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	IndexedVariables   int    `json:"indexedVariables,omitempty"`
+}
+
+func (s *dapServer) serve() error {
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			s.quit()
+			return nil
+		}
+		if err != nil {
+			s.quit()
+			return err
+		}
+
+		s.mu.Lock()
+		s.busy = true
+		s.mu.Unlock()
+
+		body, err := s.dispatch(req)
+		s.respond(req, body, err)
+		s.release()
+		if err != nil {
+			continue
+		}
+
+		// some requests are followed up by events once they've been answered
+		switch req.Command {
+		case "launch":
+			s.event("initialized", nil)
+		case "configurationDone":
+			s.configurationDone()
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// read reads the next request, which is framed by a Content-Length header.
+func (s *dapServer) read() (*dapRequest, error) {
+	length := -1
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if length >= 0 {
+				break
+			}
+			continue
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, errors.Wrap(err, "bad Content-Length header")
+			}
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil, err
+	}
+	var req dapRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, errors.Wrap(err, "bad DAP message")
+	}
+	return &req, nil
+}
+
+func (s *dapServer) respond(req *dapRequest, body interface{}, err error) {
+	resp := &dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Success:    err == nil,
+		Body:       body,
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	s.send(resp, &resp.Seq)
+}
+
+func (s *dapServer) event(name string, body interface{}) {
+	e := &dapEvent{Type: "event", Event: name, Body: body}
+	s.send(e, &e.Seq)
+}
+
+// send writes a message, setting its sequence number through seq.
+func (s *dapServer) send(msg interface{}, seq *int) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.seq++
+	*seq = s.seq
+	b, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	// errors show up as the connection closing on the reading side
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func decodeArgs(req *dapRequest, v interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(req.Arguments, v), "bad arguments to %s", req.Command)
+}
+
+func (s *dapServer) dispatch(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsStepBack":                 true,
+			"exceptionBreakpointFilters":       dapCatchFilters,
+		}, nil
+	case "launch":
+		return nil, s.launchProgram(req)
+	case "disconnect":
+		s.quit()
+		return nil, nil
+	}
+
+	if s.cpu == nil {
+		return nil, errNotLaunched
+	}
+
+	switch req.Command {
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+	case "setExceptionBreakpoints":
+		return nil, s.setCatchpoints(req)
+	case "configurationDone":
+		return nil, s.configure()
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "AGC"}},
+		}, nil
+	case "stackTrace":
+		return s.stackTrace(req)
+	case "scopes":
+		return map[string]interface{}{
+			"scopes": []dapScope{
+				{Name: "Registers", VariablesReference: dapRegisters},
+				{Name: "Erasable", VariablesReference: dapErasable},
+				{Name: "Channels", VariablesReference: dapChannels},
+			},
+		}, nil
+	case "variables":
+		return s.variables(req)
+	case "continue":
+		_, err := s.call("continue", nil)
+		return map[string]interface{}{"allThreadsContinued": true}, err
+	case "next":
+		_, err := s.call("next", nil)
+		return nil, err
+	case "stepIn":
+		_, err := s.call("step", nil)
+		return nil, err
+	case "stepOut":
+		_, err := s.call("finish", nil)
+		return nil, err
+	case "pause":
+		_, err := s.call("pause", nil)
+		return nil, err
+	case "stepBack":
+		_, err := s.call("reverse-step", nil)
+		return nil, err
+	case "reverseContinue":
+		_, err := s.call("reverse-continue", nil)
+		return nil, err
+	case "evaluate":
+		return s.evaluate(req)
+	default:
+		return nil, errors.Errorf("%s is not supported", req.Command)
+	}
+}
+
+// call carries out a debugger command (or inspects the machine
+// with fn if it is set) and waits for the engine to reply.
+func (s *dapServer) call(line string, fn func(e DebugEvent)) (string, error) {
+	select {
+	case <-s.done:
+		return "", errExited
+	default:
+	}
+
+	if fn != nil {
+		s.engine.inspect(fn, s)
+	} else {
+		s.engine.submit(line, s)
+	}
+	select {
+	case r := <-s.replies:
+		return r.output, r.err
+	case <-s.done:
+		return "", errExited
+	}
+}
+
+// quit stops the CPU if it's still running and waits for it to finish.
+func (s *dapServer) quit() {
+	if s.cpu == nil {
+		return
+	}
+	select {
+	case <-s.done:
+	default:
+		s.engine.submit("quit", nil)
+		<-s.done
+	}
+}
+
+func (s *dapServer) launchProgram(req *dapRequest) error {
+	if s.cpu != nil {
+		return errors.New("a program has already been launched")
+	}
+
+	var args struct {
+		StopOnEntry bool   `json:"stopOnEntry"`
+		Cwd         string `json:"cwd"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return err
+	}
+	c, table, err := s.launch(req.Arguments)
+	if err != nil {
+		return err
+	}
+
+	s.cpu = c
+	s.stopOnEntry = args.StopOnEntry
+	s.cwd = args.Cwd
+	s.engine = NewDebugEngine()
+	s.engine.Symbols = table
+	s.engine.fe = s
+	c.Debugger = s.engine
+
+	// the engine stops at the first instruction, which is
+	// held onto until the client is done configuring
+	go func() {
+		defer close(s.done)
+		c.Run()
+		s.event("terminated", nil)
+	}()
+	return nil
+}
+
+// configure removes the breakpoint the engine starts out with unless the
+// client asked to stop on entry or set a breakpoint there itself.
+func (s *dapServer) configure() error {
+	_, err := s.call("", func(e DebugEvent) {
+		if !s.stopOnEntry && !s.userBreakpoint(04000) {
+			delete(s.engine.bp, 04000)
+		}
+	})
+	return err
+}
+
+// configurationDone lets the client know about a stop which happened while it
+// was being configured, or resumes the CPU if it shouldn't have stopped.
+func (s *dapServer) configurationDone() {
+	s.mu.Lock()
+	s.configured = true
+	p := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	if p == nil {
+		// the CPU hasn't reached the first instruction yet
+		return
+	}
+	if s.stopOnEntry || s.userBreakpoint(p.pa) {
+		s.stoppedEvent(*p)
+		return
+	}
+	s.call("continue", nil)
+}
+
+func (s *dapServer) userBreakpoint(pa uint16) bool {
+	for _, pas := range s.breakpoints {
+		for _, a := range pas {
+			if a == pa {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// release sends a stop which was held while a request was handled.
+func (s *dapServer) release() {
+	s.mu.Lock()
+	s.busy = false
+	var p *stopEvent
+	if s.configured {
+		p = s.pending
+		s.pending = nil
+	}
+	s.mu.Unlock()
+
+	if p != nil {
+		s.stoppedEvent(*p)
+	}
+}
+
+func (s *dapServer) stopped(st stopEvent) {
+	s.mu.Lock()
+	if !s.configured || s.busy {
+		s.pending = &st
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.stoppedEvent(st)
+}
+
+func (s *dapServer) stoppedEvent(st stopEvent) {
+	s.mu.Lock()
+	s.stops++
+	first := s.stops == 1
+	s.mu.Unlock()
+
+	reason := st.reason
+	switch {
+	case first && s.stopOnEntry:
+		reason = "entry"
+	case reason == "catch":
+		// catchpoints are offered to the client as exception breakpoints
+		reason = "exception"
+	}
+	s.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"description":       st.text,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	})
+}
+
+func (s *dapServer) resumed() {
+	s.mu.Lock()
+	// requests which resume the CPU imply it has continued
+	tell := s.configured && !s.busy
+	s.mu.Unlock()
+
+	if tell {
+		s.event("continued", map[string]interface{}{
+			"threadId":            dapThreadID,
+			"allThreadsContinued": true,
+		})
+	}
+}
+
+func (s *dapServer) reply(output string, err error) {
+	s.replies <- dapReply{output: output, err: err}
+}
+
+// setBreakpoints replaces the breakpoints in a source file.
+func (s *dapServer) setBreakpoints(req *dapRequest) (interface{}, error) {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return nil, err
+	}
+	path := args.Source.Path
+	if path == "" {
+		path = args.Source.Name
+	}
+
+	var (
+		pas    []uint16
+		result = make([]dapBreakpoint, 0, len(args.Breakpoints))
+	)
+	for _, b := range args.Breakpoints {
+		bp := dapBreakpoint{Line: b.Line}
+		switch pa, ok := s.address(path, b.Line); {
+		case s.engine.Symbols == nil:
+			bp.Message = "no symbols have been loaded"
+		case !ok:
+			bp.Message = "no code was assembled from this line"
+		default:
+			bp.Verified = true
+			pas = append(pas, pa)
+		}
+		result = append(result, bp)
+	}
+
+	old := s.breakpoints[path]
+	_, err := s.call("", func(e DebugEvent) {
+		for _, pa := range old {
+			delete(s.engine.bp, pa)
+		}
+		for _, pa := range pas {
+			s.engine.bp[pa] = true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.breakpoints[path] = pas
+	return map[string]interface{}{"breakpoints": result}, nil
+}
+
+// dapCatchFilters offers the kinds of catchpoint
+// as exception breakpoints the client can enable.
+var dapCatchFilters = []map[string]string{
+	{"filter": "rupt", "label": "Interrupt entry"},
+	{"filter": "resume", "label": "Interrupt RESUME"},
+	{"filter": "timer", "label": "Timer rollover"},
+	{"filter": "counter", "label": "Counter increment"},
+	{"filter": "gojam", "label": "GOJAM restart"},
+}
+
+// setCatchpoints replaces the catchpoints with those enabled by the client.
+func (s *dapServer) setCatchpoints(req *dapRequest) error {
+	var args struct {
+		Filters []string `json:"filters"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return err
+	}
+
+	var catches []catchpoint
+	for _, f := range args.Filters {
+		cp, err := parseCatchpoint([]string{f})
+		if err != nil {
+			return err
+		}
+		catches = append(catches, cp)
+	}
+	_, err := s.call("", func(e DebugEvent) {
+		s.engine.catches = catches
+	})
+	return err
+}
+
+func (s *dapServer) address(path string, line int) (uint16, bool) {
+	if s.engine.Symbols == nil {
+		return 0, false
+	}
+	return s.engine.Symbols.Address(symtab.Location{File: path, Line: line})
+}
+
+func (s *dapServer) stackTrace(req *dapRequest) (interface{}, error) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return nil, err
+	}
+
+	var frames []dapStackFrame
+	_, err := s.call("", func(e DebugEvent) {
+		frames = s.frames(e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(frames)
+	if args.StartFrame > total {
+		args.StartFrame = total
+	}
+	frames = frames[args.StartFrame:]
+	if args.Levels > 0 && args.Levels < len(frames) {
+		frames = frames[:args.Levels]
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": total}, nil
+}
+
+// frames lists the stack frames from the innermost outwards,
+// each named after the routine (or interrupt) it is in.
+func (s *dapServer) frames(e DebugEvent) []dapStackFrame {
+	fs := e.cpu.frames
+	var frames []dapStackFrame
+	for depth := 0; depth <= len(fs); depth++ {
+		pa := e.pa
+		if depth > 0 {
+			pa = fs[len(fs)-depth].from
+		}
+
+		f := dapStackFrame{
+			ID:                          depth + 1,
+			Name:                        s.routine(fs, depth, pa),
+			InstructionPointerReference: fmt.Sprintf("%05o", pa),
+		}
+		if s.engine.Symbols != nil {
+			if loc, ok := s.engine.Symbols.Line(pa); ok {
+				f.Source = &dapSource{Name: filepath.Base(loc.File), Path: s.sourcePath(loc.File)}
+				f.Line = loc.Line
+				f.Column = 1
+			}
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func (s *dapServer) routine(fs callStack, depth int, pa uint16) string {
+	if depth < len(fs) {
+		f := fs[len(fs)-1-depth]
+		if f.kind == interruptFrame {
+			return f.rupt.String()
+		}
+		return s.engine.Symbols.Symbolize(f.entry)
+	}
+	if s.engine.Symbols != nil {
+		if name, _, ok := s.engine.Symbols.Nearest(pa); ok {
+			return name
+		}
+	}
+	return fmt.Sprintf("%05o", pa)
+}
+
+func (s *dapServer) sourcePath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	if s.cwd != "" {
+		return filepath.Join(s.cwd, file)
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+func (s *dapServer) variables(req *dapRequest) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+		Start              int `json:"start"`
+		Count              int `json:"count"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return nil, err
+	}
+
+	var (
+		vars   []dapVariable
+		varErr error
+	)
+	_, err := s.call("", func(e DebugEvent) {
+		vars, varErr = s.machineVariables(e.cpu, args.VariablesReference)
+	})
+	if err == nil {
+		err = varErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if args.Start > len(vars) {
+		args.Start = len(vars)
+	}
+	vars = vars[args.Start:]
+	if args.Count > 0 && args.Count < len(vars) {
+		vars = vars[:args.Count]
+	}
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+func (s *dapServer) machineVariables(c *CPU, ref int) ([]dapVariable, error) {
+	var vars []dapVariable
+	switch {
+	case ref == dapRegisters:
+		for r := regA; r <= regTIME6; r++ {
+			if r == regZERO {
+				continue
+			}
+			format := "%05o"
+			if r == regA || r == regQ {
+				// these hold 16 bits
+				format = "%06o"
+			}
+			vars = append(vars, dapVariable{Name: r.String(), Value: fmt.Sprintf(format, c.reg[r])})
+		}
+	case ref == dapErasable:
+		for b := 0; b < 8; b++ {
+			vars = append(vars, dapVariable{
+				Name:               fmt.Sprintf("E%d", b),
+				Value:              fmt.Sprintf("%05o-%05o", b<<8, b<<8|0377),
+				VariablesReference: dapErasableBank + b,
+				IndexedVariables:   0400,
+			})
+		}
+	case ref >= dapErasableBank && ref < dapErasableBank+8:
+		b := ref - dapErasableBank
+		for offset := 0; offset < 0400; offset++ {
+			val, err := c.mm.mm.ReadErasable(b, offset)
+			if err != nil {
+				return nil, err
+			}
+			pa := uint16(b<<8 | offset)
+			name := fmt.Sprintf("%05o", pa)
+			if s.engine.Symbols != nil {
+				if sym, off, ok := s.engine.Symbols.Nearest(pa); ok && off == 0 {
+					name += " " + sym
+				}
+			}
+			vars = append(vars, dapVariable{Name: name, Value: fmt.Sprintf("%05o", val)})
+		}
+	case ref == dapChannels:
+		for ch := uint16(1); ch < 0100; ch++ {
+			name := fmt.Sprintf("%02o", ch)
+			if n, ok := channelNames[ch]; ok {
+				name += " " + n
+			}
+			vars = append(vars, dapVariable{Name: name, Value: fmt.Sprintf("%06o", c.readChannel(ch))})
+		}
+	default:
+		return nil, errors.Errorf("unknown variables reference %d", ref)
+	}
+	return vars, nil
+}
+
+// evaluate runs debugger commands typed into the client's console, and
+// prints the memory location named by anything else such as a watch.
+func (s *dapServer) evaluate(req *dapRequest) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := decodeArgs(req, &args); err != nil {
+		return nil, err
+	}
+
+	line := args.Expression
+	if args.Context != "repl" {
+		line = "print " + line
+	}
+	out, err := s.call(line, nil)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"result":             strings.TrimSuffix(out, "\n"),
+		"variablesReference": 0,
+	}, nil
+}
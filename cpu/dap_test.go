@@ -0,0 +1,178 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dapClient struct {
+	t   *testing.T
+	w   io.Writer
+	r   *bufio.Reader
+	seq int
+}
+
+type dapMessage struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+func (dc *dapClient) send(command string, args interface{}) {
+	dc.seq++
+	b, err := json.Marshal(map[string]interface{}{
+		"seq": dc.seq, "type": "request", "command": command, "arguments": args,
+	})
+	require.NoError(dc.t, err)
+	_, err = fmt.Fprintf(dc.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	require.NoError(dc.t, err)
+}
+
+func (dc *dapClient) read() dapMessage {
+	length := 0
+	for {
+		line, err := dc.r.ReadString('\n')
+		require.NoError(dc.t, err)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		length, err = strconv.Atoi(strings.TrimPrefix(line, "Content-Length: "))
+		require.NoError(dc.t, err)
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(dc.r, body)
+	require.NoError(dc.t, err)
+
+	var msg dapMessage
+	require.NoError(dc.t, json.Unmarshal(body, &msg))
+	return msg
+}
+
+// expect reads messages until the response to command (or the event if
+// it's an event name) arrives, decoding its body into body.
+func (dc *dapClient) expect(kind, name string, body interface{}) dapMessage {
+	for {
+		msg := dc.read()
+		if msg.Type != kind || (msg.Command != name && msg.Event != name) {
+			continue
+		}
+		if kind == "response" {
+			require.True(dc.t, msg.Success, "%s failed: %s", name, msg.Message)
+		}
+		if body != nil {
+			require.NoError(dc.t, json.Unmarshal(msg.Body, body))
+		}
+		return msg
+	}
+}
+
+func TestDAPServer(t *testing.T) {
+	// arrange
+	table := symtab.New()
+	table.Define("LOOP", 04000)
+	table.Define("RESULT", 0100)
+	for i := 0; i < 5; i++ {
+		table.SetLine(uint16(04000+i), symtab.Location{File: "test.agc", Line: i + 1})
+	}
+	launcher := func(args json.RawMessage) (*CPU, *symtab.Table, error) {
+		c := newTestCPU(t, historyProgram...)
+		c.RecordHistory(100)
+		return c, table, nil
+	}
+
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	served := make(chan error)
+	go func() {
+		served <- ServeDAP(serverR, serverW, launcher)
+	}()
+	dc := &dapClient{t: t, w: clientW, r: bufio.NewReader(clientR)}
+
+	type stackTrace struct {
+		StackFrames []dapStackFrame `json:"stackFrames"`
+	}
+
+	// act & assert
+	var caps map[string]interface{}
+	dc.send("initialize", map[string]interface{}{"adapterID": "agc"})
+	dc.expect("response", "initialize", &caps)
+	assert.Equal(t, true, caps["supportsStepBack"])
+
+	dc.send("launch", map[string]interface{}{"program": "test.bin", "cwd": "/src"})
+	dc.expect("response", "launch", nil)
+	dc.expect("event", "initialized", nil)
+
+	var bps struct {
+		Breakpoints []dapBreakpoint `json:"breakpoints"`
+	}
+	dc.send("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": "/src/test.agc"},
+		"breakpoints": []map[string]int{{"line": 4}, {"line": 7}},
+	})
+	dc.expect("response", "setBreakpoints", &bps)
+	require.Len(t, bps.Breakpoints, 2)
+	assert.True(t, bps.Breakpoints[0].Verified, "line 4")
+	assert.False(t, bps.Breakpoints[1].Verified, "line 7")
+
+	var stop struct {
+		Reason string `json:"reason"`
+	}
+	dc.send("configurationDone", nil)
+	dc.expect("response", "configurationDone", nil)
+	dc.expect("event", "stopped", &stop)
+	assert.Equal(t, "breakpoint", stop.Reason)
+
+	var st stackTrace
+	dc.send("stackTrace", map[string]int{"threadId": 1})
+	dc.expect("response", "stackTrace", &st)
+	require.Len(t, st.StackFrames, 1)
+	assert.Equal(t, "LOOP", st.StackFrames[0].Name)
+	assert.Equal(t, 4, st.StackFrames[0].Line)
+	require.NotNil(t, st.StackFrames[0].Source)
+	assert.Equal(t, "/src/test.agc", st.StackFrames[0].Source.Path)
+
+	var vars struct {
+		Variables []dapVariable `json:"variables"`
+	}
+	dc.send("variables", map[string]int{"variablesReference": dapErasableBank, "start": 0100, "count": 1})
+	dc.expect("response", "variables", &vars)
+	assert.Equal(t, []dapVariable{{Name: "00100 RESULT", Value: "00005"}}, vars.Variables)
+
+	var eval struct {
+		Result string `json:"result"`
+	}
+	dc.send("evaluate", map[string]string{"expression": "RESULT", "context": "watch"})
+	dc.expect("response", "evaluate", &eval)
+	assert.Equal(t, "RESULT (00100) = 00005", eval.Result)
+
+	dc.send("stepBack", map[string]int{"threadId": 1})
+	dc.expect("response", "stepBack", nil)
+	dc.expect("event", "stopped", &stop)
+	assert.Equal(t, "step", stop.Reason)
+	dc.send("stackTrace", map[string]int{"threadId": 1})
+	dc.expect("response", "stackTrace", &st)
+	assert.Equal(t, 3, st.StackFrames[0].Line)
+
+	dc.send("disconnect", nil)
+	dc.expect("response", "disconnect", nil)
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not return after disconnecting")
+	}
+}
@@ -3,14 +3,76 @@ package cpu
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// eventKind identifies what the CPU is doing when it tells the debugger.
+type eventKind int
+
+const (
+	// evInstruction is about to execute an instruction.
+	evInstruction eventKind = iota
+	// evInterrupt has just jumped to an interrupt's vector.
+	evInterrupt
+	// evResume has just returned from an interrupt with RESUME.
+	evResume
+	// evTimer has a timer which rolled over and queued up its counter.
+	evTimer
+	// evCounter is about to execute a counter's unprogrammed sequence.
+	evCounter
+	// evGojam has just restarted the AGC.
+	evGojam
+	eventKindCount
 )
 
+var eventKindNames = [eventKindCount]string{
+	"instruction", "rupt", "resume", "timer", "counter", "gojam",
+}
+
+func (k eventKind) String() string {
+	if k < 0 || k >= eventKindCount {
+		return "event?"
+	}
+	return eventKindNames[k]
+}
+
+// DebugEvent describes the CPU at a point the debugger can stop it.
 type DebugEvent struct {
-	z       uint16
+	cpu  *CPU
+	kind eventKind
+	// z and pa are the address of the next instruction
+	z  uint16
+	pa uint16
+	// code, instr and address describe the instruction for evInstruction
 	code    uint16
 	instr   *instruction
 	address uint16
+	// name is the interrupt, timer or counter the event is about,
+	// or the cause of a GOJAM
+	name string
+}
+
+// summary describes an event other than an instruction.
+func (e DebugEvent) summary() string {
+	switch e.kind {
+	case evInterrupt:
+		return "entered " + e.name
+	case evResume:
+		return "resumed from " + e.name
+	case evTimer:
+		return "timer " + e.name + " rolled over"
+	case evCounter:
+		return "counter " + e.name + " incremented"
+	case evGojam:
+		return "GOJAM (" + e.name + ")"
+	default:
+		return e.kind.String()
+	}
 }
 
 type Debugger interface {
@@ -21,98 +83,162 @@ type noDebugger int
 
 func (d *noDebugger) Debug(e DebugEvent) {}
 
+// InteractiveDebugger is a console front end for the DebugEngine.
 type InteractiveDebugger struct {
-	dbgevtc  chan DebugEvent
-	dbgctlc  chan struct{}
-	outc     chan string
-	commandc chan interface{}
+	*DebugEngine
+
+	in  io.Reader
+	out io.Writer
+
+	// the callbacks from the CPU's goroutine update the state under mu,
+	// broadcasting on cond so that Source can wait for them
+	mu        sync.Mutex
+	cond      *sync.Cond
+	isStopped bool
+	replied   bool
+	lastErr   error
+	// halted is set once the CPU has stopped running for good
+	halted bool
 }
 
+// NewInteractiveDebugger creates a debugger which reads
+// commands from stdin and writes to stdout.
 func NewInteractiveDebugger() *InteractiveDebugger {
-	return &InteractiveDebugger{
-		dbgevtc:  make(chan DebugEvent),
-		dbgctlc:  make(chan struct{}),
-		outc:     make(chan string),
-		commandc: make(chan interface{}),
+	return newConsoleDebugger(NewDebugEngine(), os.Stdin, os.Stdout)
+}
+
+func newConsoleDebugger(engine *DebugEngine, in io.Reader, out io.Writer) *InteractiveDebugger {
+	d := &InteractiveDebugger{
+		DebugEngine: engine,
+		in:          in,
+		out:         out,
 	}
+	d.cond = sync.NewCond(&d.mu)
+	engine.fe = d
+	return d
 }
+
+// Run reads commands from the console until it is closed, which quits.
 func (d *InteractiveDebugger) Run() {
-	var (
-		bp    = map[uint16]bool{04000: true}
-		steps int
-		stdin = bufio.NewScanner(os.Stdin)
-	)
-
-debugLoop:
-	for {
-		e := <-d.dbgevtc
-
-		// check to see if we should break
-		var doBreak bool
-		if steps > 0 {
-			steps--
-			if steps == 0 {
-				doBreak = true
-			}
-		}
-		if bp[e.z] {
-			doBreak = true
+	stdin := bufio.NewScanner(d.in)
+	for stdin.Scan() {
+		d.submit(stdin.Text(), d)
+	}
+
+	if err := stdin.Err(); err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+	d.submit("quit", d)
+}
+
+// Pause stops the CPU before its next instruction if it is running, or
+// just prompts again if it's already stopped. It is safe to call from any
+// goroutine, such as one handling an interrupt signal.
+func (d *InteractiveDebugger) Pause() {
+	d.submit("pause", d)
+}
+
+// Source carries out the debugger commands in a script, echoing them to the
+// console. Each command waits for the CPU to be stopped, so a command which
+// runs the CPU holds up the rest of the script until it stops again (stepi
+// can be used to limit how far it goes). Blank lines and lines starting with
+// # are skipped. Source returns the number of commands which failed, and
+// stops early if the script quits. If the CPU stops running for good
+// first, the command waiting for it fails (unless it is a quit) and the
+// rest of the script is skipped.
+func (d *InteractiveDebugger) Source(r io.Reader) (failed int, err error) {
+	script := bufio.NewScanner(r)
+	for script.Scan() {
+		line := strings.TrimSpace(script.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+		cmd := strings.Fields(line)[0]
+		quit := cmd == "quit" || cmd == "q"
 
-		if doBreak {
-			// time to take a break, output the
-			// event and start a prompt
-			fmt.Printf("%04o: %05o (%04x) {%-6s %05o}\n", e.z, e.code, e.code, e.instr.name, e.address)
-
-		promptLoop:
-			for {
-				// get a command from the user
-				fmt.Printf("> ")
-				if !stdin.Scan() {
-					break debugLoop
-				}
-				input := stdin.Text()
-
-				// process input
-				var cmd string
-				fmt.Sscan(input, &cmd)
-				switch cmd {
-				case "step", "s":
-					steps = 1
-					break promptLoop
-				case "stepi", "si":
-					fmt.Sscanf(input, fmt.Sprintf("%s %%d", cmd), &steps)
-					break promptLoop
-				case "run", "r":
-					break promptLoop
-				case "breakpoint", "bp":
-					var addr uint16
-					fmt.Sscanf(input, fmt.Sprintf("%s %%o", cmd), &addr)
-					bp[addr] = !bp[addr]
-					break
-				case "":
-					break
-				default:
-					fmt.Println("unrecognized command", cmd)
-					break
-				}
+		d.mu.Lock()
+		for !d.isStopped && !d.halted {
+			d.cond.Wait()
+		}
+		if d.halted {
+			d.mu.Unlock()
+			if !quit {
+				fmt.Fprintf(d.out, "%s\n%s\n", line, errCPUHalted)
+				failed++
 			}
+			break
 		}
+		fmt.Fprintln(d.out, line)
+		d.replied = false
+		d.mu.Unlock()
 
-		// now let the CPU keep going
-		d.dbgctlc <- struct{}{}
-	}
+		d.submit(line, d)
 
-	if err := stdin.Err(); err != nil {
-		panic(err)
+		d.mu.Lock()
+		for !d.replied && !d.halted {
+			d.cond.Wait()
+		}
+		replied := d.replied
+		if !replied && !quit {
+			fmt.Fprintln(d.out, errCPUHalted)
+			failed++
+		} else if replied && d.lastErr != nil {
+			failed++
+		}
+		d.mu.Unlock()
+
+		if quit || !replied {
+			break
+		}
 	}
+	return failed, script.Err()
+}
+
+var errCPUHalted = errors.New("the CPU has stopped running")
+
+// Halted tells the debugger that Run has returned, so that Source fails
+// the command waiting for the CPU instead of waiting forever.
+func (d *InteractiveDebugger) Halted() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.halted = true
+	d.cond.Broadcast()
+}
+
+func (d *InteractiveDebugger) stopped(s stopEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.isStopped = true
+	fmt.Fprintln(d.out, s.text)
+	d.prompt()
+	d.cond.Broadcast()
 }
 
-func (d *InteractiveDebugger) Debug(e DebugEvent) {
-	d.dbgevtc <- e
-	<-d.dbgctlc
+func (d *InteractiveDebugger) resumed() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.isStopped = false
 }
 
-type breakpointCommand uint16
-type stepiCommand int
-type runCommand struct{}
+func (d *InteractiveDebugger) reply(output string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprint(d.out, output)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+	d.prompt()
+	d.replied = true
+	d.lastErr = err
+	d.cond.Broadcast()
+}
+
+func (d *InteractiveDebugger) prompt() {
+	if d.isStopped {
+		fmt.Fprint(d.out, "> ")
+	}
+}
@@ -0,0 +1,208 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDebugger keeps every event other than instructions.
+type recordingDebugger struct {
+	events []DebugEvent
+}
+
+func (d *recordingDebugger) Debug(e DebugEvent) {
+	if e.kind != evInstruction || e.instr.name == "RESUME" {
+		d.events = append(d.events, e)
+	}
+}
+
+// ruptProgram idles in a loop, with a T4RUPT handler which resumes straight away.
+var ruptProgram = []uint16{
+	014000, // 04000 TCF    04000
+	000000, 000000, 000000, 000000, 000000, 000000, 000000,
+	000000, 000000, 000000, 000000, 000000, 000000, 000000, 000000,
+	050017, // 04020 RESUME
+}
+
+func TestDebugEventsForInterrupts(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	d := new(recordingDebugger)
+	c.Debugger = d
+	// make the next increment of TIME4 overflow
+	c.reg.Set(regTIME4, 077777)
+
+	// act
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+
+	// assert
+	type summary struct {
+		kind eventKind
+		name string
+		z    uint16
+	}
+	var got []summary
+	for _, e := range d.events {
+		// the other timers are running too
+		if e.name == "TIME4" || e.name == "T4RUPT" || e.kind == evInstruction {
+			got = append(got, summary{e.kind, e.name, e.z})
+		}
+	}
+	assert.Equal(t, []summary{
+		{evTimer, "TIME4", 04000},
+		{evCounter, "TIME4", 04000},
+		{evInterrupt, "T4RUPT", 04020},
+		{evInstruction, "", 04020},
+		{evResume, "T4RUPT", 04000},
+	}, got)
+}
+
+func TestGOJAM(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	d := new(recordingDebugger)
+	c.Debugger = d
+	c.reg.Set(regZ, 04020)
+	c.inRupt = true
+	c.pendingInts = 1 << uint(intT3RUPT)
+	c.chans[012] = 0123
+	c.chans[030] = 0456
+	c.jam = "test"
+
+	// act
+	c.step()
+
+	// assert
+	assert.Equal(t, uint16(04000), c.reg[regZ], "register Z")
+	assert.False(t, c.inRupt, "inRupt")
+	assert.Zero(t, c.pendingInts, "pending interrupts")
+	assert.Zero(t, c.chans[012], "output channel 12")
+	assert.Equal(t, uint16(0456), c.chans[030], "input channel 30")
+	assert.Empty(t, c.jam)
+	require.Len(t, d.events, 1)
+	assert.Equal(t, evGojam, d.events[0].kind)
+	assert.Equal(t, "test", d.events[0].name)
+}
+
+func TestParseCatchpoint(t *testing.T) {
+	tests := []struct {
+		args []string
+		want catchpoint
+		err  bool
+	}{
+		{args: []string{"rupt"}, want: catchpoint{kind: evInterrupt}},
+		{args: []string{"rupt", "T4"}, want: catchpoint{kind: evInterrupt, name: "T4RUPT"}},
+		{args: []string{"resume", "keyrupt1"}, want: catchpoint{kind: evResume, name: "KEYRUPT1"}},
+		{args: []string{"counter", "TIME3"}, want: catchpoint{kind: evCounter, name: "TIME3"}},
+		{args: []string{"timer", "time5"}, want: catchpoint{kind: evTimer, name: "TIME5"}},
+		{args: []string{"gojam"}, want: catchpoint{kind: evGojam}},
+		{args: []string{"gojam", "T4"}, err: true},
+		{args: []string{"rupt", "T9"}, err: true},
+		{args: []string{"counter", "A"}, err: true},
+		{args: []string{"parity"}, err: true},
+	}
+	for _, tt := range tests {
+		// act
+		cp, err := parseCatchpoint(tt.args)
+
+		// assert
+		if tt.err {
+			assert.Error(t, err, "%v", tt.args)
+			continue
+		}
+		if assert.NoError(t, err, "%v", tt.args) {
+			assert.Equal(t, tt.want, cp, "%v", tt.args)
+		}
+	}
+}
+
+func TestInteractiveDebuggerSource(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	d := newConsoleDebugger(NewDebugEngine(), strings.NewReader(""), out)
+	c.Debugger = d
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+
+	script := `# stop after the channel has been written
+break 4004
+run
+assert 100 5
+assert A 4
+print ch10
+dump 4000 3
+quit
+echo not reached
+`
+
+	// act
+	failed, err := d.Source(strings.NewReader(script))
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failed)
+	<-done
+	assert.Contains(t, out.String(), "assertion failed: A = 00005, expected 00004")
+	assert.Contains(t, out.String(), "ch10 = 00005")
+	assert.Contains(t, out.String(), "04000: 34010 54100 00006")
+	assert.NotContains(t, out.String(), "not reached")
+}
+
+func TestInteractiveDebuggerSourceHalted(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.CycleLimit = 100
+	out := new(bytes.Buffer)
+	d := newConsoleDebugger(NewDebugEngine(), strings.NewReader(""), out)
+	c.Debugger = d
+	go func() {
+		c.Run()
+		d.Halted()
+	}()
+
+	script := `clear
+run
+regs
+echo not reached
+`
+
+	// act
+	failed, err := d.Source(strings.NewReader(script))
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failed)
+	assert.Contains(t, out.String(), "regs\nthe CPU has stopped running\n")
+	assert.NotContains(t, out.String(), "not reached")
+}
+
+func TestInteractiveDebuggerSourceHaltedQuit(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.CycleLimit = 100
+	d := newConsoleDebugger(NewDebugEngine(), strings.NewReader(""), new(bytes.Buffer))
+	c.Debugger = d
+	go func() {
+		c.Run()
+		d.Halted()
+	}()
+
+	// act
+	failed, err := d.Source(strings.NewReader("clear\nrun\nquit\n"))
+
+	// assert
+	assert.NoError(t, err)
+	assert.Zero(t, failed, "quitting once the CPU has stopped is fine")
+}
@@ -0,0 +1,97 @@
+package cpu
+
+import "fmt"
+
+// Device is a peripheral attached to the CPU's I/O channels, such as a DSKY.
+// Its methods are called from the goroutine running the CPU, so a device
+// which is also driven from elsewhere (like a user interface) has to do its
+// own locking.
+type Device interface {
+	// ChannelWritten tells the device the 15 bit value of a channel the
+	// program (or a GOJAM) has just written.
+	ChannelWritten(ch, val uint16)
+	// Step is called before every step the CPU takes, so the device can
+	// drive the CPU's input channels and request interrupts through io. It
+	// isn't called again for the steps the debugger replays after running
+	// the CPU backwards.
+	Step(io DeviceIO)
+}
+
+// DeviceIO is how a Device drives the CPU.
+type DeviceIO interface {
+	// Cycles is the time in memory cycles since the CPU started.
+	Cycles() uint64
+	// Channel reads the 15 bit value of a channel.
+	Channel(ch uint16) uint16
+	// SetChannel sets the 15 bit value of an input channel.
+	SetChannel(ch, val uint16)
+	// Counter reads the 15 bit value of the counter at the given address.
+	Counter(counter uint16) uint16
+	// Interrupt requests an interrupt by name, such as KEYRUPT1.
+	Interrupt(name string)
+	// Count asks for an unprogrammed sequence to change the counter at
+	// the given address, from CDUX (032) to ALTM (060). The sequences
+	// run in the order they are asked for, each taking a memory cycle.
+	// Shifting a 1 out of the top of INLINK (045) raises UPRUPT.
+	Count(counter uint16, kind CounterKind)
+}
+
+// Attach connects a device to the I/O channels. It must be called before
+// the CPU starts running.
+func (c *CPU) Attach(d Device) {
+	c.devices = append(c.devices, d)
+}
+
+// channelWritten tells the devices about a change to a channel.
+func (c *CPU) channelWritten(ch uint16) {
+	for _, d := range c.devices {
+		d.ChannelWritten(ch, c.chans[ch])
+	}
+}
+
+// deviceIO gives the devices access to the CPU.
+type deviceIO struct {
+	c *CPU
+}
+
+func (io deviceIO) Cycles() uint64 { return io.c.cycles }
+
+func (io deviceIO) Channel(ch uint16) uint16 { return io.c.chans[ch%channelCount] }
+
+func (io deviceIO) SetChannel(ch, val uint16) {
+	ch %= channelCount
+	if io.c.chans[ch] == val&077777 {
+		return
+	}
+	io.c.history.wroteChannel(ch, io.c.chans[ch])
+	io.c.chans[ch] = val & 077777
+}
+
+func (io deviceIO) Counter(counter uint16) uint16 {
+	r := register(counter)
+	if r < regTIME2 || r > regALTM {
+		panic(fmt.Sprintf("there's no counter at %04o", counter))
+	}
+	return io.c.reg[r] & 077777
+}
+
+func (io deviceIO) Interrupt(name string) {
+	for i := interrupt(0); i < interruptCount; i++ {
+		if i.String() == name {
+			io.c.interrupt(i)
+			return
+		}
+	}
+	panic(fmt.Sprintf("unknown interrupt %q", name))
+}
+
+func (io deviceIO) Count(counter uint16, kind CounterKind) {
+	r := register(counter)
+	if r < regCDUX || r > regALTM || kind < 0 || kind >= counterKindCount {
+		panic(fmt.Sprintf("can't %s counter %04o", kind, counter))
+	}
+	// pending sequences are taken from the end, so this one goes to the
+	// front to run after any already asked for
+	seq := &counterSequences[r-regCDUX][kind]
+	io.c.pendingSequences = append([]*sequence{seq}, io.c.pendingSequences...)
+}
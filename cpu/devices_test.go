@@ -0,0 +1,204 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDevice remembers the channel writes it sees and runs a
+// function on every step.
+type recordingDevice struct {
+	writes [][2]uint16
+	step   func(io DeviceIO)
+}
+
+func (d *recordingDevice) ChannelWritten(ch, val uint16) {
+	d.writes = append(d.writes, [2]uint16{ch, val})
+}
+
+func (d *recordingDevice) Step(io DeviceIO) {
+	if d.step != nil {
+		d.step(io)
+	}
+}
+
+func TestDeviceChannelWritten(t *testing.T) {
+	// arrange
+	c := newTestCPU(t,
+		000006, // 04000 EXTEND
+		001010, // 04001 WRITE 010
+	)
+	c.reg.Set(regA, 0112345)
+	d := new(recordingDevice)
+	c.Attach(d)
+
+	// act
+	c.step()
+	c.step()
+	c.gojam("test")
+
+	// assert
+	assert.Equal(t, [2]uint16{010, 052345}, d.writes[0], "overflow corrected")
+	assert.Len(t, d.writes, 1+len(gojamChannels))
+}
+
+func TestDeviceInputs(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	d := &recordingDevice{step: func(io DeviceIO) {
+		if io.Cycles() == 0 {
+			io.SetChannel(015, 021)
+			io.Interrupt("KEYRUPT1")
+		}
+	}}
+	c.Attach(d)
+	c.RecordHistory(10)
+
+	// act
+	c.step()
+
+	// assert
+	assert.Equal(t, uint16(021), c.chans[015])
+	assert.True(t, c.inRupt)
+	rupt, _ := c.frames.interrupt()
+	assert.Equal(t, intKEYRUPT1, rupt)
+	c.undo()
+	assert.Zero(t, c.chans[015], "the input is undone with the step")
+}
+
+func TestDeviceReplay(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(10)
+	c.Debugger = &rewindingDebugger{pa: 04004}
+	steps := 0
+	c.Attach(&recordingDevice{step: func(io DeviceIO) { steps++ }})
+
+	// act
+	for i := 0; i < 5+5; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, 6, steps, "the replayed steps aren't stepped again")
+}
+
+func TestDeviceUnknownInterrupt(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.Attach(&recordingDevice{step: func(io DeviceIO) { io.Interrupt("NOPE") }})
+
+	// act & assert
+	assert.Panics(t, c.step)
+}
+
+func TestDeviceCount(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.reg.Set(regINLINK, 1)
+	c.Attach(&recordingDevice{step: func(io DeviceIO) {
+		if io.Cycles() == 0 {
+			io.Count(032, PCDU)
+			io.Count(045, SHANC)
+			io.Count(045, SHINC)
+		}
+	}})
+
+	// act
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, uint16(1), c.reg[regCDUX])
+	assert.Equal(t, uint16(06), c.reg[regINLINK], "the shifts run in order")
+	assert.Equal(t, uint64(3), c.cycles, "each takes a memory cycle")
+}
+
+func TestDeviceCountBadCounter(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.Attach(&recordingDevice{step: func(io DeviceIO) { io.Count(031, PINC) }})
+
+	// act & assert
+	assert.Panics(t, c.step)
+}
+
+func TestDeviceCounter(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.reg.Set(regGYROCTR, 012)
+	var got uint16
+	c.Attach(&recordingDevice{step: func(io DeviceIO) { got = io.Counter(047) }})
+
+	// act
+	c.step()
+
+	// assert
+	assert.Equal(t, uint16(012), got)
+}
+
+func TestCounterKinds(t *testing.T) {
+	scenarios := []struct {
+		name       string
+		kind       CounterKind
+		start, end uint16
+	}{
+		{"PINC", PINC, 5, 6},
+		{"PINC - overflow", PINC, 037777, 0},
+		{"PINC - minus zero", PINC, 077777, 1},
+		{"PINC - minus one", PINC, 077776, 077777},
+		{"MINC", MINC, 5, 4},
+		{"MINC - plus zero", MINC, 0, 077776},
+		{"MINC - overflow", MINC, 040000, 077777},
+		{"PCDU - wraps", PCDU, 077777, 0},
+		{"MCDU - wraps", MCDU, 0, 077777},
+		{"DINC - positive", DINC, 3, 2},
+		{"DINC - negative", DINC, 077775, 077776},
+		{"DINC - zero", DINC, 077777, 077777},
+		{"SHINC", SHINC, 040001, 2},
+		{"SHANC", SHANC, 040001, 3},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			// act
+			end := scenario.kind.count(scenario.start)
+
+			// assert
+			assert.Equal(t, scenario.end, end)
+		})
+	}
+}
+
+func TestDeviceUplinkWord(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.intsOff = true
+	const word = 012345
+	bits := 1<<15 | word
+	c.Attach(&recordingDevice{step: func(io DeviceIO) {
+		if io.Cycles() == 0 {
+			for i := 15; i >= 0; i-- {
+				kind := SHINC
+				if bits>>uint(i)&1 != 0 {
+					kind = SHANC
+				}
+				io.Count(045, kind)
+			}
+		}
+	}})
+
+	// act
+	for i := 0; i < 15; i++ {
+		c.step()
+	}
+	early := c.pendingInts
+	c.step()
+
+	// assert
+	assert.Zero(t, early&(1<<uint(intUPRUPT)))
+	assert.NotZero(t, c.pendingInts&(1<<uint(intUPRUPT)))
+	assert.Equal(t, uint16(word), c.reg[regINLINK])
+}
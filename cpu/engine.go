@@ -0,0 +1,688 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/pkg/errors"
+)
+
+// DebugEngine decides when the CPU should stop and carries out debugger
+// commands. It doesn't do any I/O itself, instead it is driven by a front
+// end such as the InteractiveDebugger on the console or a remote client
+// connected through Serve. Commands are always carried out on the CPU's
+// goroutine at an instruction boundary so they can safely inspect and
+// modify the machine.
+type DebugEngine struct {
+	// Symbols allows addresses to be displayed and entered by name.
+	Symbols *symtab.Table
+
+	bp      map[uint16]bool
+	catches []catchpoint
+	steps   int
+	until   func(e DebugEvent) bool
+	pausing bool
+
+	cmdc     chan command
+	fe       debugFrontend
+	lastStop *stopEvent
+}
+
+type command struct {
+	line string
+	// fn, when set, is called instead of carrying out line
+	// so a front end can inspect the machine directly
+	fn func(e DebugEvent)
+	fe debugFrontend
+}
+
+// debugFrontend presents the engine to a user. Its methods are
+// called from the CPU's goroutine.
+type debugFrontend interface {
+	// stopped is told when the CPU stops and the engine starts
+	// waiting for commands.
+	stopped(s stopEvent)
+	// resumed is told when the CPU starts running again.
+	resumed()
+	// reply receives the output of a command sent by this front end.
+	reply(output string, err error)
+}
+
+// catchpoint stops the CPU on events other than instructions.
+type catchpoint struct {
+	kind eventKind
+	// name limits the catchpoint to a single interrupt, timer or counter
+	name string
+}
+
+func (cp catchpoint) String() string {
+	if cp.name == "" {
+		return cp.kind.String()
+	}
+	return cp.kind.String() + " " + cp.name
+}
+
+func (cp catchpoint) matches(e DebugEvent) bool {
+	return e.kind == cp.kind && (cp.name == "" || cp.name == e.name)
+}
+
+// stopEvent describes where and why the CPU stopped.
+type stopEvent struct {
+	reason string
+	z      uint16
+	pa     uint16
+	// event and name describe a stop caught on something other than an
+	// instruction, in which case the instruction fields are empty
+	event   string
+	name    string
+	code    uint16
+	instr   string
+	address uint16
+	// symbol and line are empty without symbols
+	symbol string
+	line   string
+	// text is the human readable form
+	text string
+}
+
+// NewDebugEngine creates a DebugEngine which will stop at the first instruction.
+func NewDebugEngine() *DebugEngine {
+	return &DebugEngine{
+		bp:   map[uint16]bool{04000: true},
+		cmdc: make(chan command, 16),
+	}
+}
+
+// submit queues up a command from a front end.
+func (d *DebugEngine) submit(line string, fe debugFrontend) {
+	d.cmdc <- command{line: line, fe: fe}
+}
+
+// inspect queues up a function to be called on the CPU's goroutine, with
+// the reply sent to fe once it returns.
+func (d *DebugEngine) inspect(fn func(e DebugEvent), fe debugFrontend) {
+	d.cmdc <- command{fn: fn, fe: fe}
+}
+
+func (d *DebugEngine) Debug(e DebugEvent) {
+	// carry out any commands sent while the CPU was running
+	for polling := true; polling && !e.cpu.halted; {
+		select {
+		case cmd := <-d.cmdc:
+			d.execute(e, cmd, false)
+		default:
+			polling = false
+		}
+	}
+	if e.cpu.halted {
+		return
+	}
+
+	// check to see if we should break
+	var reason string
+	if e.kind == evInstruction {
+		if d.steps > 0 {
+			d.steps--
+			if d.steps == 0 {
+				reason = "step"
+			}
+		}
+		if d.until != nil && d.until(e) {
+			reason = "step"
+		}
+		if d.pausing {
+			reason = "pause"
+		}
+		if d.bp[e.pa] {
+			reason = "breakpoint"
+		}
+	} else {
+		for _, cp := range d.catches {
+			if cp.matches(e) {
+				reason = "catch"
+			}
+		}
+	}
+	if reason == "" {
+		return
+	}
+
+	d.stop(e, reason)
+}
+
+// stop announces that the CPU has stopped and then
+// carries out commands until one of them resumes it.
+func (d *DebugEngine) stop(e DebugEvent, reason string) {
+	// stopping ends any stepping which was in progress
+	d.steps = 0
+	d.until = nil
+	d.pausing = false
+	s := d.stopEvent(e, reason)
+	d.lastStop = &s
+	if d.fe != nil {
+		d.fe.stopped(s)
+	}
+
+	for cmd := range d.cmdc {
+		if d.execute(e, cmd, true) {
+			return
+		}
+	}
+}
+
+func (d *DebugEngine) stopEvent(e DebugEvent, reason string) stopEvent {
+	s := stopEvent{
+		reason: reason,
+		z:      e.z,
+		pa:     e.pa,
+	}
+	if d.Symbols != nil {
+		if _, _, ok := d.Symbols.Nearest(e.pa); ok {
+			s.symbol = d.Symbols.Symbolize(e.pa)
+		}
+		if loc, ok := d.Symbols.Line(e.pa); ok {
+			s.line = loc.String()
+		}
+	}
+	if e.kind != evInstruction {
+		s.event = e.kind.String()
+		s.name = e.name
+		s.text = fmt.Sprintf("%04o: %s%s", e.z, e.summary(), d.describe(e))
+		return s
+	}
+	s.code = e.code
+	s.instr = e.instr.name
+	s.address = e.address
+	s.text = fmt.Sprintf("%04o: %05o (%04x) {%-6s %05o}%s", e.z, e.code, e.code, e.instr.name, e.address, d.describe(e))
+	return s
+}
+
+var errRunning = errors.New("the CPU is running")
+
+// execute carries out a command, returning true if the CPU should resume.
+func (d *DebugEngine) execute(e DebugEvent, cmd command, stopped bool) (resume bool) {
+	out := new(strings.Builder)
+	resume, err := d.dispatch(out, e, cmd, stopped)
+	resume = resume && stopped
+	if resume {
+		d.lastStop = nil
+		if d.fe != nil {
+			d.fe.resumed()
+		}
+	}
+	if cmd.fe != nil {
+		cmd.fe.reply(out.String(), err)
+	}
+	return resume
+}
+
+func (d *DebugEngine) dispatch(out io.Writer, e DebugEvent, cmd command, stopped bool) (bool, error) {
+	if cmd.fn != nil {
+		cmd.fn(e)
+		return false, nil
+	}
+
+	args := strings.Fields(cmd.line)
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	// commands which move the CPU can only be used while it is stopped
+	switch args[0] {
+	case "step", "s", "stepi", "si", "run", "r", "continue", "c", "next", "n", "finish", "fin",
+		"reverse-step", "rs", "reverse-continue", "rc", "last-write", "lw", "gojam":
+		if !stopped {
+			return false, errRunning
+		}
+	}
+
+	switch args[0] {
+	case "attach":
+		d.fe = cmd.fe
+		if d.lastStop != nil && d.fe != nil {
+			d.fe.stopped(*d.lastStop)
+		}
+		return false, nil
+	case "detach":
+		if d.fe == cmd.fe {
+			d.fe = nil
+		}
+		d.steps = 0
+		d.until = nil
+		return true, nil
+	case "quit", "q":
+		e.cpu.halted = true
+		return true, nil
+	case "pause":
+		// commands are carried out between instructions while the
+		// CPU is running, so it will stop before the next one
+		d.pausing = !stopped
+		return false, nil
+	case "step", "s":
+		d.steps = 1
+		return true, nil
+	case "stepi", "si":
+		if len(args) != 2 {
+			return false, usage(args[0], "<count>")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return false, errors.Errorf("bad step count %q", args[1])
+		}
+		d.steps = n
+		return true, nil
+	case "run", "r", "continue", "c":
+		return true, nil
+	case "next", "n":
+		// step over subroutine calls by running until
+		// the call stack is back to its current depth
+		if e.kind != evInstruction || e.instr.name != "TC" || e.address == uint16(regQ) {
+			d.steps = 1
+			return true, nil
+		}
+		depth := len(e.cpu.frames)
+		d.until = func(e DebugEvent) bool {
+			return len(e.cpu.frames) <= depth
+		}
+		return true, nil
+	case "finish", "fin":
+		depth := len(e.cpu.frames)
+		if depth == 0 {
+			return false, errors.New("not in a subroutine or interrupt")
+		}
+		d.until = func(e DebugEvent) bool {
+			return len(e.cpu.frames) < depth
+		}
+		return true, nil
+	case "reverse-step", "rs":
+		return d.reverse(out, e.cpu, nil)
+	case "reverse-continue", "rc":
+		c := e.cpu
+		return d.reverse(out, c, func(*snapshot) bool {
+			return len(c.pendingSequences) == 0 && d.bp[c.psudoAddress(c.reg[regZ])]
+		})
+	case "last-write", "lw":
+		if len(args) != 2 {
+			return false, usage(args[0], "<address|symbol|ch<channel>>")
+		}
+		stop, err := d.lastWrite(e.cpu, args[1])
+		if err != nil {
+			return false, err
+		}
+		return d.reverse(out, e.cpu, stop)
+	case "backtrace", "bt":
+		d.backtrace(out, e)
+		return false, nil
+	case "breakpoint", "bp", "break", "b", "clear":
+		return false, d.breakpoint(out, args)
+	case "catch", "uncatch":
+		return false, d.catch(out, args)
+	case "gojam":
+		// restart before the CPU goes any further and stop at the first instruction
+		e.cpu.jam = "debugger"
+		d.steps = 1
+		return true, nil
+	case "print", "p":
+		if len(args) != 2 {
+			return false, usage(args[0], "<location>")
+		}
+		return false, d.print(out, e.cpu, args[1])
+	case "assert":
+		if len(args) != 3 {
+			return false, usage(args[0], "<location> <octal value>")
+		}
+		return false, d.assert(e.cpu, args[1], args[2])
+	case "dump", "x":
+		if len(args) != 2 && len(args) != 3 {
+			return false, usage(args[0], "<address|symbol> [count]")
+		}
+		return false, d.dump(out, e.cpu, args[1:])
+	case "echo":
+		fmt.Fprintln(out, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(cmd.line), "echo")))
+		return false, nil
+	case "registers", "regs":
+		d.registers(out, e.cpu)
+		return false, nil
+	default:
+		return false, errors.Errorf("unrecognized command %s", args[0])
+	}
+}
+
+func usage(cmd, args string) error {
+	return errors.Errorf("usage: %s %s", cmd, args)
+}
+
+// breakpoint handles the commands which manage breakpoints: "break" sets one,
+// "clear" removes one (or all of them) and "breakpoint" toggles one.
+func (d *DebugEngine) breakpoint(out io.Writer, args []string) error {
+	if args[0] == "clear" && len(args) == 1 {
+		d.bp = make(map[uint16]bool)
+		return nil
+	}
+	if len(args) != 2 {
+		return usage(args[0], "<address|symbol|file:line>")
+	}
+
+	addr, err := d.Symbols.Resolve(args[1])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "break", "b":
+		d.bp[addr] = true
+	case "clear":
+		delete(d.bp, addr)
+	default:
+		if d.bp[addr] {
+			delete(d.bp, addr)
+		} else {
+			d.bp[addr] = true
+		}
+	}
+
+	if d.bp[addr] {
+		fmt.Fprintf(out, "breakpoint set at %s\n", d.location(addr))
+	} else {
+		fmt.Fprintf(out, "breakpoint cleared at %s\n", d.location(addr))
+	}
+	return nil
+}
+
+// catch handles the commands which manage catchpoints: "catch" adds one
+// (or lists them) and "uncatch" removes matching ones (or all of them).
+func (d *DebugEngine) catch(out io.Writer, args []string) error {
+	if len(args) == 1 {
+		if args[0] == "uncatch" {
+			d.catches = nil
+			return nil
+		}
+		for i, cp := range d.catches {
+			fmt.Fprintf(out, "%d: %s\n", i+1, cp)
+		}
+		return nil
+	}
+
+	cp, err := parseCatchpoint(args[1:])
+	if err != nil {
+		return errors.Wrap(err, usage(args[0], "rupt|resume|timer|counter [<name>] | gojam").Error())
+	}
+	if args[0] == "catch" {
+		d.catches = append(d.catches, cp)
+		fmt.Fprintf(out, "catching %s\n", cp)
+		return nil
+	}
+
+	kept := d.catches[:0]
+	for _, c := range d.catches {
+		if c != cp {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == len(d.catches) {
+		return errors.Errorf("not catching %s", cp)
+	}
+	d.catches = kept
+	return nil
+}
+
+func parseCatchpoint(args []string) (catchpoint, error) {
+	var cp catchpoint
+	switch args[0] {
+	case "rupt", "interrupt":
+		cp.kind = evInterrupt
+	case "resume":
+		cp.kind = evResume
+	case "timer":
+		cp.kind = evTimer
+	case "counter":
+		cp.kind = evCounter
+	case "gojam":
+		cp.kind = evGojam
+	default:
+		return cp, errors.Errorf("unknown event %q", args[0])
+	}
+	if len(args) > 2 || (len(args) == 2 && cp.kind == evGojam) {
+		return cp, errors.New("too many arguments")
+	}
+	if len(args) == 2 {
+		name, ok := catchName(cp.kind, strings.ToUpper(args[1]))
+		if !ok {
+			return cp, errors.Errorf("unknown %s %s", args[0], args[1])
+		}
+		cp.name = name
+	}
+	return cp, nil
+}
+
+// catchName checks the name of an interrupt, timer or counter, allowing
+// interrupts to be named without their RUPT suffix (T4 for T4RUPT).
+func catchName(kind eventKind, name string) (string, bool) {
+	if kind == evInterrupt || kind == evResume {
+		for i := interrupt(0); i < interruptCount; i++ {
+			if n := i.String(); n == name || n == name+"RUPT" {
+				return n, true
+			}
+		}
+		return "", false
+	}
+	last := regTIME6
+	if kind == evCounter {
+		last = regALTM
+	}
+	for r := regTIME2; r <= last; r++ {
+		if r.String() == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// describe returns the symbolic location of the event
+// (and of its operand) if there are symbols loaded.
+func (d *DebugEngine) describe(e DebugEvent) string {
+	if d.Symbols == nil {
+		return ""
+	}
+
+	s := "  " + d.Symbols.Symbolize(e.pa)
+	if e.kind == evInstruction && e.instr.addressMask != maskNoAddress {
+		s += " -> " + d.Symbols.Symbolize(e.cpu.psudoAddress(e.address))
+	}
+	if loc, ok := d.Symbols.Line(e.pa); ok {
+		s += " (" + loc.String() + ")"
+	}
+	return s
+}
+
+// reverse runs the CPU backwards, returning true if it has been moved.
+func (d *DebugEngine) reverse(out io.Writer, c *CPU, stop func(*snapshot) bool) (bool, error) {
+	if c.history == nil {
+		return false, errors.New("execution history is not being recorded")
+	}
+	if !c.reverse(stop) {
+		fmt.Fprintln(out, "reached the start of the recorded history")
+	}
+	if c.rewound {
+		// stop as soon as the CPU gets back to the restored instruction
+		d.steps = 1
+	}
+	return c.rewound, nil
+}
+
+// lastWrite builds a stop condition for reverse which finds the last write to
+// the given memory location, register (detected by a change in value) or channel.
+func (d *DebugEngine) lastWrite(c *CPU, spec string) (func(*snapshot) bool, error) {
+	if strings.HasPrefix(spec, "ch") {
+		ch, err := strconv.ParseUint(spec[2:], 8, 16)
+		if err != nil || ch >= channelCount {
+			return nil, errors.Errorf("bad channel %q", spec)
+		}
+		return func(s *snapshot) bool {
+			for _, w := range s.writes {
+				if w.channel && w.addr == uint16(ch) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	pa, err := d.Symbols.Resolve(spec)
+	if err != nil {
+		return nil, err
+	}
+	if pa < uint16(len(c.reg)) {
+		last := c.reg[pa]
+		return func(s *snapshot) bool {
+			changed := c.reg[pa] != last
+			last = c.reg[pa]
+			return changed
+		}, nil
+	}
+	return func(s *snapshot) bool {
+		for _, w := range s.writes {
+			if !w.channel && w.pa == pa {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func (d *DebugEngine) backtrace(out io.Writer, e DebugEvent) {
+	fmt.Fprintf(out, "#0  %s\n", d.location(e.pa))
+	frames := e.cpu.frames
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		n := len(frames) - i
+		switch f.kind {
+		case callFrame:
+			fmt.Fprintf(out, "#%d  %s called %s\n", n, d.location(f.from), d.Symbols.Symbolize(f.entry))
+		case interruptFrame:
+			fmt.Fprintf(out, "#%d  in %s, interrupted at %s\n", n, f.rupt, d.location(f.from))
+		}
+	}
+}
+
+// location formats a psudo-address with its symbol and source line.
+func (d *DebugEngine) location(pa uint16) string {
+	s := fmt.Sprintf("%05o", pa)
+	if d.Symbols == nil {
+		return s
+	}
+	if _, _, ok := d.Symbols.Nearest(pa); ok {
+		s += " " + d.Symbols.Symbolize(pa)
+	}
+	if loc, ok := d.Symbols.Line(pa); ok {
+		s += " (" + loc.String() + ")"
+	}
+	return s
+}
+
+// read gets the value at a location, which is a register name, an I/O
+// channel (ch followed by its number in octal) or anything Resolve accepts.
+// It also returns a description of the location.
+func (d *DebugEngine) read(c *CPU, spec string) (string, uint16, error) {
+	for r := register(0); int(r) < len(registerNames); r++ {
+		if strings.EqualFold(spec, r.String()) {
+			return r.String(), c.reg[r], nil
+		}
+	}
+	if strings.HasPrefix(spec, "ch") {
+		ch, err := strconv.ParseUint(spec[2:], 8, 16)
+		if err != nil || ch >= channelCount {
+			return "", 0, errors.Errorf("bad channel %q", spec)
+		}
+		return fmt.Sprintf("ch%02o", ch), c.readChannel(uint16(ch)), nil
+	}
+
+	pa, err := d.Symbols.Resolve(spec)
+	if err != nil {
+		return "", 0, err
+	}
+	val, err := d.readMemory(c, pa)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s (%05o)", d.Symbols.Symbolize(pa), pa), val, nil
+}
+
+// readMemory gets the word at a psudo-address. Erasable memory can be read
+// whichever bank is selected, but fixed memory has to be in a selected bank.
+func (d *DebugEngine) readMemory(c *CPU, pa uint16) (uint16, error) {
+	if pa >= uint16(len(c.reg)) && pa < 04000 {
+		return c.mm.mm.ReadErasable(int(pa>>8), int(pa&0377))
+	}
+	addr, ok := c.cpuAddress(pa)
+	if !ok {
+		return 0, errors.Errorf("%05o is not in a selected bank", pa)
+	}
+	return c.mm.peek(int(addr))
+}
+
+func (d *DebugEngine) print(out io.Writer, c *CPU, spec string) error {
+	loc, val, err := d.read(c, spec)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s = %05o\n", loc, val)
+	return nil
+}
+
+// assert fails if the value at a location isn't the expected one.
+func (d *DebugEngine) assert(c *CPU, spec, expected string) error {
+	want, err := strconv.ParseUint(expected, 8, 16)
+	if err != nil {
+		return errors.Errorf("bad value %q", expected)
+	}
+	loc, val, err := d.read(c, spec)
+	if err != nil {
+		return err
+	}
+	if val != uint16(want) {
+		return errors.Errorf("assertion failed: %s = %05o, expected %05o", loc, val, want)
+	}
+	return nil
+}
+
+// dump prints consecutive words of memory, eight to a line.
+func (d *DebugEngine) dump(out io.Writer, c *CPU, args []string) error {
+	pa, err := d.Symbols.Resolve(args[0])
+	if err != nil {
+		return err
+	}
+	count := 8
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil || count <= 0 {
+			return errors.Errorf("bad count %q", args[1])
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if i%8 == 0 {
+			if i > 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%05o:", pa)
+		}
+		val, err := d.readMemory(c, pa)
+		if err != nil {
+			fmt.Fprintln(out)
+			return err
+		}
+		fmt.Fprintf(out, " %05o", val)
+		pa++
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+func (d *DebugEngine) registers(out io.Writer, c *CPU) {
+	fmt.Fprintf(out, "A=%06o L=%05o Q=%06o Z=%04o\n", c.reg[regA], c.reg[regL], c.reg[regQ], c.reg[regZ])
+	fmt.Fprintf(out, "EB=%05o FB=%05o BB=%05o\n", c.reg[regEB], c.reg[regFB], c.reg[regBB])
+	fmt.Fprintf(out, "ZRUPT=%05o BRUPT=%05o intsOff=%t inRupt=%t extended=%t\n",
+		c.reg[regZRUPT], c.reg[regBRUPT], c.intsOff, c.inRupt, c.extended)
+}
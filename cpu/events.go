@@ -0,0 +1,251 @@
+package cpu
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind identifies a kind of Event.
+type EventKind int
+
+// The kinds of event the CPU publishes.
+const (
+	EventInstruction EventKind = iota
+	EventInterrupt
+	EventResume
+	EventChannel
+	EventCounter
+	EventGOJAM
+	EventFault
+	busEventKinds
+)
+
+var busEventKindNames = [...]string{"instruction", "interrupt", "resume", "channel", "counter", "gojam", "fault"}
+
+func (k EventKind) String() string {
+	if k < 0 || k >= busEventKinds {
+		return "unknown"
+	}
+	return busEventKindNames[k]
+}
+
+// Event is something which happened in the CPU. It is one of the *Event
+// types below, all of which give the time it happened in memory cycles.
+type Event interface {
+	Kind() EventKind
+	Time() uint64
+}
+
+// InstructionEvent is published after an instruction has executed.
+type InstructionEvent struct {
+	Cycles uint64
+	// Z is the CPU address of the instruction and Address its psudo-address.
+	Z, Address uint16
+	// Code is the instruction word, Name its mnemonic and Operand its address field.
+	Code    uint16
+	Name    string
+	Operand uint16
+}
+
+// InterruptEvent is published when the CPU enters an interrupt.
+type InterruptEvent struct {
+	Cycles uint64
+	// Name is the interrupt, such as T4RUPT.
+	Name string
+	// ZRUPT and BRUPT are the address and instruction which were interrupted.
+	ZRUPT, BRUPT uint16
+}
+
+// ResumeEvent is published when an interrupt service routine RESUMEs.
+type ResumeEvent struct {
+	Cycles uint64
+	Name   string
+}
+
+// ChannelEvent is published when the program writes to an I/O channel.
+type ChannelEvent struct {
+	Cycles  uint64
+	Channel uint16
+	// Value is the value stored, after overflow correction.
+	Value uint16
+}
+
+// CounterEvent is published when an unprogrammed sequence, such as
+// PINC, has changed a counter.
+type CounterEvent struct {
+	Cycles uint64
+	// Counter is the register changed and Sequence the unprogrammed sequence.
+	Counter, Sequence string
+	Value             uint16
+}
+
+// GOJAMEvent is published when the CPU restarts.
+type GOJAMEvent struct {
+	Cycles uint64
+	Cause  string
+}
+
+// FaultEvent is published when the emulator can't go on, such as when
+// it can't decode an instruction, just before it panics.
+type FaultEvent struct {
+	Cycles uint64
+	Z      uint16
+	Err    string
+}
+
+// Kind implements Event.
+func (e *InstructionEvent) Kind() EventKind { return EventInstruction }
+
+// Kind implements Event.
+func (e *InterruptEvent) Kind() EventKind { return EventInterrupt }
+
+// Kind implements Event.
+func (e *ResumeEvent) Kind() EventKind { return EventResume }
+
+// Kind implements Event.
+func (e *ChannelEvent) Kind() EventKind { return EventChannel }
+
+// Kind implements Event.
+func (e *CounterEvent) Kind() EventKind { return EventCounter }
+
+// Kind implements Event.
+func (e *GOJAMEvent) Kind() EventKind { return EventGOJAM }
+
+// Kind implements Event.
+func (e *FaultEvent) Kind() EventKind { return EventFault }
+
+// Time implements Event.
+func (e *InstructionEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *InterruptEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *ResumeEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *ChannelEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *CounterEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *GOJAMEvent) Time() uint64 { return e.Cycles }
+
+// Time implements Event.
+func (e *FaultEvent) Time() uint64 { return e.Cycles }
+
+// Subscription receives the events of the kinds it subscribed to on C.
+// Events are never waited for: if C's buffer is full the event is dropped
+// and counted instead, so a slow subscriber can't hold up the CPU.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	kinds   uint32
+	dropped uint64
+	bus     *eventBus
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Dropped returns the number of events which didn't fit in C's buffer.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+func (s *Subscription) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.c <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// eventBus delivers events to the subscriptions. The list of subscriptions
+// is replaced rather than changed, so publishing doesn't need a lock.
+type eventBus struct {
+	mu   sync.Mutex
+	subs atomic.Value // []*Subscription
+	// kinds has a bit set for each kind of event anyone subscribes to
+	kinds uint32
+}
+
+// Subscribe starts delivering the events of the given kinds (or of every
+// kind if none are given) to a new Subscription with room for buffer events.
+// It can be called from any goroutine, including while the CPU is running.
+func (c *CPU) Subscribe(buffer int, kinds ...EventKind) *Subscription {
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, c: ch, bus: &c.events}
+	if len(kinds) == 0 {
+		s.kinds = 1<<uint(busEventKinds) - 1
+	}
+	for _, k := range kinds {
+		s.kinds |= 1 << uint(k)
+	}
+	c.events.add(s)
+	return s
+}
+
+func (b *eventBus) add(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, _ := b.subs.Load().([]*Subscription)
+	b.update(append(subs[:len(subs):len(subs)], s))
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs, _ := b.subs.Load().([]*Subscription)
+	var kept []*Subscription
+	for _, sub := range subs {
+		if sub != s {
+			kept = append(kept, sub)
+		}
+	}
+	b.update(kept)
+}
+
+func (b *eventBus) update(subs []*Subscription) {
+	var kinds uint32
+	for _, s := range subs {
+		kinds |= s.kinds
+	}
+	b.subs.Store(subs)
+	atomic.StoreUint32(&b.kinds, kinds)
+}
+
+// wants reports whether anyone is subscribed to a kind of event, so
+// events nobody wants needn't be made.
+func (b *eventBus) wants(k EventKind) bool {
+	return atomic.LoadUint32(&b.kinds)&(1<<uint(k)) != 0
+}
+
+func (b *eventBus) publish(e Event) {
+	subs, _ := b.subs.Load().([]*Subscription)
+	bit := uint32(1) << uint(e.Kind())
+	for _, s := range subs {
+		if s.kinds&bit != 0 {
+			s.send(e)
+		}
+	}
+}
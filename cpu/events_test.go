@@ -0,0 +1,109 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// received takes the events waiting on a subscription.
+func received(s *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-s.C:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribeInterrupts(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.reg.Set(regTIME4, 077777)
+	s := c.Subscribe(16, EventInterrupt, EventResume, EventCounter)
+
+	// act
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+
+	// assert
+	events := received(s)
+	var counters []Event
+	for len(events) > 0 && events[0].Kind() == EventCounter {
+		counters, events = append(counters, events[0]), events[1:]
+	}
+	require.NotEmpty(t, counters)
+	last := counters[len(counters)-1].(*CounterEvent)
+	assert.Equal(t, "TIME4", last.Counter)
+	assert.Equal(t, "PINC TIME4", last.Sequence)
+	assert.Equal(t, uint16(0), last.Value)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, &InterruptEvent{Cycles: last.Cycles + 1, Name: "T4RUPT", ZRUPT: 04000, BRUPT: 014000}, events[0])
+	assert.Equal(t, &ResumeEvent{Cycles: last.Cycles + 1, Name: "T4RUPT"}, events[1])
+	assert.Zero(t, s.Dropped())
+}
+
+func TestSubscribeChannels(t *testing.T) {
+	// arrange
+	c := newTestCPU(t,
+		000006, // 04000 EXTEND
+		001010, // 04001 WRITE 010
+	)
+	c.reg.Set(regA, 012345)
+	all := c.Subscribe(16)
+	channels := c.Subscribe(16, EventChannel)
+
+	// act
+	c.step()
+	c.step()
+
+	// assert
+	want := &ChannelEvent{Cycles: 1, Channel: 010, Value: 012345}
+	assert.Equal(t, []Event{want}, received(channels))
+	events := received(all)
+	require.Len(t, events, 3)
+	assert.Equal(t, "EXTEND", events[0].(*InstructionEvent).Name)
+	assert.Equal(t, want, events[1])
+	assert.Equal(t, &InstructionEvent{Cycles: 1, Z: 04001, Address: 04001, Code: 001010, Name: "WRITE", Operand: 010}, events[2])
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	s := c.Subscribe(1, EventInstruction)
+
+	// act
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Len(t, received(s), 1)
+	assert.Equal(t, uint64(2), s.Dropped())
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	s := c.Subscribe(1)
+	other := c.Subscribe(1, EventInstruction)
+
+	// act
+	s.Close()
+	c.step()
+
+	// assert
+	_, open := <-s.C
+	assert.False(t, open)
+	assert.Len(t, received(other), 1)
+	other.Close()
+	assert.False(t, c.events.wants(EventInstruction))
+}
@@ -0,0 +1,325 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/Elsewhen-Studios/go-agc/memory"
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+)
+
+// Heatmap counts the reads, writes and executions of every word of every
+// erasable and fixed bank, and how often the program switches banks. It can be
+// written out as CSV or JSON, or drawn as a bank by offset heatmap.
+//
+// Instruction fetches and the debugger looking at memory aren't counted as reads.
+// The counts must not be read while the CPU they are attached to is running.
+type Heatmap struct {
+	// Symbols, if set, labels the words in the reports.
+	Symbols *symtab.Table
+
+	// EBankSwitches and FBankSwitches count the instructions which ran with
+	// a different erasable or fixed bank selected than the one before.
+	EBankSwitches, FBankSwitches uint64
+
+	// the counts are indexed by psudo-address
+	reads, writes, executes []uint64
+
+	started bool
+	eb, fb  uint16
+}
+
+// NewHeatmap creates an empty Heatmap.
+func NewHeatmap() *Heatmap {
+	return &Heatmap{
+		reads:    make([]uint64, 1<<16),
+		writes:   make([]uint64, 1<<16),
+		executes: make([]uint64, 1<<16),
+	}
+}
+
+// attach starts counting the accesses c makes to memory.
+func (h *Heatmap) attach(c *CPU) {
+	if h == nil {
+		return
+	}
+	c.mm.onAccess = func(address int, write bool) {
+		if c.replay {
+			return
+		}
+		pa := c.psudoAddress(uint16(address))
+		if write {
+			h.writes[pa]++
+		} else {
+			h.reads[pa]++
+		}
+	}
+}
+
+// instruction counts an instruction executing at pa.
+func (h *Heatmap) instruction(c *CPU, pa uint16) {
+	if h == nil {
+		return
+	}
+	h.executes[pa]++
+
+	eb := c.reg[regEB] & 03400
+	fb := c.reg[regFB] & 076000
+	if c.chans[chanSUPERBNK]&0100 != 0 {
+		fb |= 1
+	}
+	if h.started && eb != h.eb {
+		h.EBankSwitches++
+	}
+	if h.started && fb != h.fb {
+		h.FBankSwitches++
+	}
+	h.started, h.eb, h.fb = true, eb, fb
+}
+
+// heatmapBank is the range of psudo-addresses of one bank.
+type heatmapBank struct {
+	name  string
+	start uint16
+	size  int
+}
+
+func heatmapBanks() []heatmapBank {
+	var banks []heatmapBank
+	for b := uint16(0); b < memory.ErasableBanks; b++ {
+		banks = append(banks, heatmapBank{fmt.Sprintf("E%o", b), b << 8, 0400})
+	}
+	for b := uint16(0); b < memory.FixedBanks; b++ {
+		start := (b + 4) << 10
+		if b == 2 || b == 3 {
+			start = b << 10
+		}
+		banks = append(banks, heatmapBank{fmt.Sprintf("F%02o", b), start, 02000})
+	}
+	return banks
+}
+
+func (h *Heatmap) symbol(pa uint16) string {
+	if h.Symbols == nil {
+		return ""
+	}
+	if _, _, ok := h.Symbols.Nearest(pa); !ok {
+		return ""
+	}
+	return h.Symbols.Symbolize(pa)
+}
+
+// WriteCSV writes a row for every word with its bank, offset, psudo-address,
+// symbol and counts.
+func (h *Heatmap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"bank", "offset", "address", "symbol", "reads", "writes", "executes"})
+	for _, b := range heatmapBanks() {
+		for off := 0; off < b.size; off++ {
+			pa := b.start + uint16(off)
+			cw.Write([]string{
+				b.name,
+				fmt.Sprintf("%04o", off),
+				fmt.Sprintf("%05o", pa),
+				h.symbol(pa),
+				strconv.FormatUint(h.reads[pa], 10),
+				strconv.FormatUint(h.writes[pa], 10),
+				strconv.FormatUint(h.executes[pa], 10),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type heatmapJSONBank struct {
+	Bank     string   `json:"bank"`
+	Address  string   `json:"address"`
+	Reads    []uint64 `json:"reads"`
+	Writes   []uint64 `json:"writes"`
+	Executes []uint64 `json:"executes"`
+}
+
+type heatmapJSON struct {
+	EBankSwitches uint64            `json:"ebankSwitches"`
+	FBankSwitches uint64            `json:"fbankSwitches"`
+	Banks         []heatmapJSONBank `json:"banks"`
+	// Labels maps the psudo-addresses of the symbols (in octal) to their names.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func (h *Heatmap) json() heatmapJSON {
+	j := heatmapJSON{EBankSwitches: h.EBankSwitches, FBankSwitches: h.FBankSwitches}
+	for _, b := range heatmapBanks() {
+		end := int(b.start) + b.size
+		j.Banks = append(j.Banks, heatmapJSONBank{
+			Bank:     b.name,
+			Address:  fmt.Sprintf("%05o", b.start),
+			Reads:    h.reads[b.start:end],
+			Writes:   h.writes[b.start:end],
+			Executes: h.executes[b.start:end],
+		})
+	}
+	if h.Symbols != nil {
+		j.Labels = make(map[string]string)
+		for _, name := range h.Symbols.Names() {
+			if pa, ok := h.Symbols.Lookup(name); ok {
+				j.Labels[fmt.Sprintf("%05o", pa)] = name
+			}
+		}
+	}
+	return j
+}
+
+// WriteJSON writes the counts as an array for each bank indexed by offset,
+// along with the bank switches and the labels from the symbol table.
+func (h *Heatmap) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(h.json())
+}
+
+// heatmapRowHeight is the height in pixels of each bank in the image,
+// which is 1024 pixels wide, so erasable words are 4 pixels wide.
+const heatmapRowHeight = 8
+
+// heatmapScale maps a count onto a colour channel logarithmically, so anything
+// touched at all is visible.
+func heatmapScale(n, max uint64) uint8 {
+	if n == 0 {
+		return 0
+	}
+	return uint8(64 + 191*math.Log1p(float64(n))/math.Log1p(float64(max)))
+}
+
+// heatmapMax holds the highest counts, which are drawn the brightest.
+type heatmapMax struct {
+	reads, writes, executes uint64
+}
+
+func (h *Heatmap) max() heatmapMax {
+	var m heatmapMax
+	for pa := range h.reads {
+		if h.reads[pa] > m.reads {
+			m.reads = h.reads[pa]
+		}
+		if h.writes[pa] > m.writes {
+			m.writes = h.writes[pa]
+		}
+		if h.executes[pa] > m.executes {
+			m.executes = h.executes[pa]
+		}
+	}
+	return m
+}
+
+// drawBank draws one bank as a row, with writes in red, executions
+// in green and reads in blue.
+func (h *Heatmap) drawBank(img *image.RGBA, y int, b heatmapBank, m heatmapMax) {
+	width := 02000 / b.size
+	for off := 0; off < b.size; off++ {
+		pa := b.start + uint16(off)
+		col := color.RGBA{
+			R: heatmapScale(h.writes[pa], m.writes),
+			G: heatmapScale(h.executes[pa], m.executes),
+			B: heatmapScale(h.reads[pa], m.reads),
+			A: 255,
+		}
+		for dy := 0; dy < heatmapRowHeight; dy++ {
+			for dx := 0; dx < width; dx++ {
+				img.SetRGBA(off*width+dx, y+dy, col)
+			}
+		}
+	}
+}
+
+// WritePNG draws the heatmap as an image with a row for each bank (the
+// erasable banks first) and a column for each offset. Writes are red,
+// executions green and reads blue, each brighter the more there were.
+func (h *Heatmap) WritePNG(w io.Writer) error {
+	banks := heatmapBanks()
+	img := image.NewRGBA(image.Rect(0, 0, 02000, len(banks)*(heatmapRowHeight+1)))
+	m := h.max()
+	for i, b := range banks {
+		h.drawBank(img, i*(heatmapRowHeight+1), b, m)
+	}
+	return png.Encode(w, img)
+}
+
+// WriteHTML writes a page drawing each bank as in WritePNG, with a tooltip
+// giving the address, symbol and counts of the word under the mouse.
+func (h *Heatmap) WriteHTML(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>AGC memory heatmap</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 0; }
+th { font-family: monospace; font-weight: normal; padding-right: 0.5em; }
+img { display: block; image-rendering: pixelated; }
+#tip { position: fixed; background: #ffe; border: 1px solid #888; padding: 2px 4px; font-family: monospace; display: none; }
+</style></head><body>
+<h1>AGC memory heatmap</h1>
+<p>Writes are <span style="color:#c00">red</span>, executions <span style="color:#0a0">green</span>
+and reads <span style="color:#00c">blue</span>, each brighter the more there were.
+EBANK switches: %d, FBANK switches: %d.</p>
+<table>
+`, h.EBankSwitches, h.FBankSwitches)
+
+	m := h.max()
+	for i, b := range heatmapBanks() {
+		img := image.NewRGBA(image.Rect(0, 0, 02000, heatmapRowHeight))
+		h.drawBank(img, 0, b, m)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "<tr><th>%s</th><td><img data-bank=\"%d\" src=\"data:image/png;base64,%s\"></td></tr>\n",
+			html.EscapeString(b.name), i, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+
+	data, err := json.Marshal(h.json())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `</table>
+<div id="tip"></div>
+<script>
+const data = %s;
+const labels = Object.keys(data.labels || {}).map(a => [parseInt(a, 8), data.labels[a]]).sort((a, b) => a[0] - b[0]);
+function label(pa) {
+  let found = "";
+  for (const [a, name] of labels) {
+    if (a > pa) break;
+    found = a === pa ? name : name + "+" + (pa - a).toString(8);
+  }
+  return found;
+}
+const tip = document.getElementById("tip");
+for (const img of document.querySelectorAll("img[data-bank]")) {
+  const bank = data.banks[img.dataset.bank];
+  img.addEventListener("mousemove", e => {
+    const off = Math.floor(e.offsetX * bank.reads.length / img.width);
+    const pa = parseInt(bank.address, 8) + off;
+    tip.textContent = bank.bank + "," + off.toString(8).padStart(4, "0") + " (" + pa.toString(8).padStart(5, "0") + ") " + label(pa) +
+      "  reads " + bank.reads[off] + "  writes " + bank.writes[off] + "  executes " + bank.executes[off];
+    tip.style.left = (e.clientX + 12) + "px";
+    tip.style.top = (e.clientY + 12) + "px";
+    tip.style.display = "block";
+  });
+  img.addEventListener("mouseleave", () => tip.style.display = "none");
+}
+</script>
+</body></html>
+`, data)
+	return bw.Flush()
+}
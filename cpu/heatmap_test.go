@@ -0,0 +1,156 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// heatmapProgram copies a word from erasable bank 5 to 0100 through the switched
+// erasable window and then loops forever.
+var heatmapProgram = []uint16{
+	034005, // 04000 CA  04005
+	054003, // 04001 TS  EB
+	031401, // 04002 CA  01401  (E5,0001)
+	054100, // 04003 TS  0100
+	014004, // 04004 TCF 04004
+	002400, // 04005 the EBANK setting for E5
+}
+
+func newHeatmapTest(t *testing.T) *CPU {
+	c := newTestCPU(t, heatmapProgram...)
+	c.Heatmap = NewHeatmap()
+	c.Heatmap.attach(c)
+	c.Heatmap.Symbols = symtab.New()
+	c.Heatmap.Symbols.Define("START", 04000)
+	return c
+}
+
+func TestHeatmapCounts(t *testing.T) {
+	// arrange
+	c := newHeatmapTest(t)
+
+	// act
+	for i := 0; i < 7; i++ {
+		c.step()
+	}
+
+	// assert
+	h := c.Heatmap
+	assert.Equal(t, uint64(1), h.reads[04005], "constant")
+	assert.Equal(t, uint64(1), h.writes[03], "EB")
+	assert.Equal(t, uint64(1), h.reads[02401], "E5,0001")
+	assert.Equal(t, uint64(1), h.writes[02401], "CA writes back what it reads")
+	assert.Equal(t, uint64(1), h.writes[0100])
+	assert.Equal(t, uint64(3), h.executes[04004])
+	assert.Zero(t, h.reads[04000], "instruction fetches aren't reads")
+	assert.Equal(t, uint64(1), h.EBankSwitches)
+	assert.Zero(t, h.FBankSwitches)
+}
+
+func TestHeatmapReplay(t *testing.T) {
+	// arrange
+	c := newHeatmapTest(t)
+	c.RecordHistory(10)
+	c.Debugger = &rewindingDebugger{pa: 04004}
+
+	// act
+	for i := 0; i < 4+7; i++ {
+		c.step()
+	}
+
+	// assert
+	h := c.Heatmap
+	assert.Equal(t, uint64(1), h.reads[04005], "constant")
+	assert.Equal(t, uint64(1), h.writes[0100])
+	assert.Equal(t, uint64(1), h.executes[04000])
+}
+
+func TestHeatmapBanks(t *testing.T) {
+	// act
+	banks := heatmapBanks()
+
+	// assert
+	require.Len(t, banks, 8+050)
+	assert.Equal(t, heatmapBank{"E0", 0, 0400}, banks[0])
+	assert.Equal(t, heatmapBank{"F02", 04000, 02000}, banks[10])
+	assert.Equal(t, heatmapBank{"F47", 053 << 10, 02000}, banks[len(banks)-1],
+		"the banks end with the last superbank")
+}
+
+func TestHeatmapWriteCSV(t *testing.T) {
+	// arrange
+	c := newHeatmapTest(t)
+	for i := 0; i < 7; i++ {
+		c.step()
+	}
+	out := new(bytes.Buffer)
+
+	// act
+	err := c.Heatmap.WriteCSV(out)
+
+	// assert
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Equal(t, "bank,offset,address,symbol,reads,writes,executes", lines[0])
+	assert.Len(t, lines, 1+8*0400+050*02000)
+	assert.Contains(t, lines, "E5,0001,02401,,1,1,0")
+	assert.Contains(t, lines, "F02,0004,04004,START+4,0,0,3")
+}
+
+func TestHeatmapWriteJSON(t *testing.T) {
+	// arrange
+	c := newHeatmapTest(t)
+	for i := 0; i < 7; i++ {
+		c.step()
+	}
+	out := new(bytes.Buffer)
+
+	// act
+	err := c.Heatmap.WriteJSON(out)
+
+	// assert
+	require.NoError(t, err)
+	var got heatmapJSON
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	require.Len(t, got.Banks, 8+050)
+	assert.Equal(t, "F02", got.Banks[10].Bank)
+	assert.Equal(t, "04000", got.Banks[10].Address)
+	assert.Equal(t, uint64(3), got.Banks[10].Executes[4])
+	assert.Equal(t, map[string]string{"04000": "START"}, got.Labels)
+	assert.Equal(t, uint64(1), got.EBankSwitches)
+}
+
+func TestHeatmapWritePNG(t *testing.T) {
+	// arrange
+	c := newHeatmapTest(t)
+	for i := 0; i < 7; i++ {
+		c.step()
+	}
+	out := new(bytes.Buffer)
+
+	// act
+	err := c.Heatmap.WritePNG(out)
+
+	// assert
+	require.NoError(t, err)
+	img, err := png.Decode(out)
+	require.NoError(t, err)
+	assert.Equal(t, 02000, img.Bounds().Dx())
+	// F02 is the 11th row, and the TCF at 04004 was only executed
+	r, g, b, _ := img.At(4, 10*(heatmapRowHeight+1)).RGBA()
+	assert.Zero(t, r)
+	assert.Equal(t, uint32(0xffff), g)
+	assert.Zero(t, b)
+	// E5 words are 4 pixels wide, and 02401 was read and written back by CA
+	r, g, b, _ = img.At(4, 5*(heatmapRowHeight+1)).RGBA()
+	assert.NotZero(t, r)
+	assert.Zero(t, g)
+	assert.NotZero(t, b)
+}
@@ -0,0 +1,221 @@
+package cpu
+
+type write struct {
+	channel bool
+	// addr is the CPU address or channel number written to.
+	addr uint16
+	// pa is the psudo-address written to (memory writes only).
+	pa  uint16
+	old uint16
+}
+
+type transitionKind int
+
+const (
+	enteredInterrupt transitionKind = iota
+	resumed
+	jammed
+)
+
+// transition is the CPU entering or leaving an interrupt, or a GOJAM.
+type transition struct {
+	kind   transitionKind
+	cycles uint64
+	// name is the interrupt or the cause of the GOJAM
+	name string
+}
+
+// snapshot holds the state of the machine at the start of a step along with
+// every write to memory and the I/O channels made during the step, which is
+// enough to undo the step. It also keeps what the step did.
+type snapshot struct {
+	reg              registers
+	extended         bool
+	intsOff          bool
+	inRupt           bool
+	pendingInts      uint16
+	frames           callStack
+	timers           []int
+	pendingSequences []*sequence
+	cycles           uint64
+	steps            uint64
+	jam              string
+	writes           []write
+
+	// the step either executed the instruction code, fetched from pa, or
+	// ran seq, unless the debugger stopped it first
+	executed    bool
+	pa, code    uint16
+	seq         *sequence
+	transitions []transition
+}
+
+// history is a bounded ring of snapshots, one for each step
+// (an instruction or an unprogrammed sequence) the CPU takes.
+type history struct {
+	ring  []snapshot
+	next  int
+	count int
+}
+
+func newHistory(size int) *history {
+	return &history{ring: make([]snapshot, size)}
+}
+
+// record takes a snapshot of the CPU at the start of a step. The
+// snapshots are reused as the ring wraps to avoid allocations.
+func (h *history) record(c *CPU) {
+	if h == nil {
+		return
+	}
+
+	s := &h.ring[h.next]
+	h.next = (h.next + 1) % len(h.ring)
+	if h.count < len(h.ring) {
+		h.count++
+	}
+
+	s.reg = c.reg
+	s.extended = c.extended
+	s.intsOff = c.intsOff
+	s.inRupt = c.inRupt
+	s.pendingInts = c.pendingInts
+	s.frames = append(s.frames[:0], c.frames...)
+	s.timers = s.timers[:0]
+	for _, t := range c.timers {
+		s.timers = append(s.timers, t.v)
+	}
+	s.pendingSequences = append(s.pendingSequences[:0], c.pendingSequences...)
+	s.cycles = c.cycles
+	s.steps = c.steps
+	s.jam = c.jam
+	s.writes = s.writes[:0]
+	s.executed, s.seq = false, nil
+	s.transitions = s.transitions[:0]
+}
+
+func (h *history) current() *snapshot {
+	if h == nil || h.count == 0 {
+		return nil
+	}
+	return &h.ring[(h.next+len(h.ring)-1)%len(h.ring)]
+}
+
+func (h *history) wroteMemory(addr, pa, old uint16) {
+	if s := h.current(); s != nil {
+		s.writes = append(s.writes, write{addr: addr, pa: pa, old: old})
+	}
+}
+
+func (h *history) wroteChannel(ch, old uint16) {
+	if s := h.current(); s != nil {
+		s.writes = append(s.writes, write{channel: true, addr: ch, old: old})
+	}
+}
+
+func (h *history) instruction(pa, code uint16) {
+	if s := h.current(); s != nil {
+		s.executed, s.pa, s.code = true, pa, code
+	}
+}
+
+func (h *history) sequence(seq *sequence) {
+	if s := h.current(); s != nil {
+		s.seq = seq
+	}
+}
+
+func (h *history) transition(kind transitionKind, cycles uint64, name string) {
+	if s := h.current(); s != nil {
+		s.transitions = append(s.transitions, transition{kind, cycles, name})
+	}
+}
+
+func (h *history) pop() *snapshot {
+	s := h.current()
+	if s != nil {
+		h.next = (h.next + len(h.ring) - 1) % len(h.ring)
+		h.count--
+	}
+	return s
+}
+
+// RecordHistory keeps a record of the last n steps the CPU takes so that
+// a debugger can run the program backwards. Zero disables the history.
+func (c *CPU) RecordHistory(n int) {
+	if n <= 0 {
+		c.history = nil
+		c.mm.onWrite = nil
+		return
+	}
+
+	c.history = newHistory(n)
+	c.mm.onWrite = func(addr int, old uint16) {
+		c.history.wroteMemory(uint16(addr), c.psudoAddress(uint16(addr)), old)
+	}
+}
+
+// undo reverts the most recent step recorded in the history,
+// returning its snapshot or nil if the history is exhausted.
+func (c *CPU) undo() *snapshot {
+	s := c.history.pop()
+	if s == nil {
+		return nil
+	}
+
+	// undo the writes in the reverse order they were made
+	for i := len(s.writes) - 1; i >= 0; i-- {
+		w := s.writes[i]
+		if w.channel {
+			c.chans[w.addr] = w.old
+			c.channelWritten(w.addr)
+			continue
+		}
+		// the bank written to may not be selected any more, but
+		// every erasable bank can be reached through the window at 01400
+		c.mm.mm.SelectBanks(int(w.pa>>8), 0, false)
+		if err := c.mm.mm.Write(01400|int(w.pa&0377), w.old); err != nil {
+			panic(err)
+		}
+	}
+
+	c.reg = s.reg
+	c.extended = s.extended
+	c.intsOff = s.intsOff
+	c.inRupt = s.inRupt
+	c.pendingInts = s.pendingInts
+	c.frames = append(c.frames[:0], s.frames...)
+	for i, v := range s.timers {
+		c.timers[i].v = v
+	}
+	c.pendingSequences = append(c.pendingSequences[:0], s.pendingSequences...)
+	c.cycles = s.cycles
+	c.steps = s.steps
+	c.jam = s.jam
+	c.rewound = true
+	return s
+}
+
+// reverse runs the CPU backwards to the start of an earlier instruction. It
+// undoes the step in progress and then keeps undoing steps until stop returns
+// true for one of them, stopping at the next instruction boundary. A nil stop
+// moves back a single instruction. It returns false if the history runs out.
+func (c *CPU) reverse(stop func(undone *snapshot) bool) bool {
+	if c.undo() == nil {
+		return false
+	}
+
+	hit := stop == nil
+	for {
+		s := c.undo()
+		if s == nil {
+			return false
+		}
+		if stop != nil && stop(s) {
+			hit = true
+		}
+		if hit && len(c.pendingSequences) == 0 {
+			return true
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCPU creates a CPU with the given program loaded at 04000 (the
+// start of fixed-fixed memory) and Z pointing at the first instruction.
+func newTestCPU(t *testing.T, program ...uint16) *CPU {
+	image := make([]uint16, 2*02000+len(program))
+	copy(image[2*02000:], program)
+	buf := new(bytes.Buffer)
+	require.NoError(t, binary.Write(buf, binary.BigEndian, image))
+
+	mm := new(memory.Main)
+	_, err := io.Copy(&memory.Loader{MM: mm}, buf)
+	require.NoError(t, err)
+
+	c := NewCPU(mm)
+	c.reg.Set(regZ, 04000)
+	return c
+}
+
+var historyProgram = []uint16{
+	034010, // 04000 CA    04010
+	054100, // 04001 TS    0100
+	000006, // 04002 EXTEND
+	001010, // 04003 WRITE 010
+	014000, // 04004 TCF   04000
+	000000,
+	000000,
+	000000,
+	000005, // 04010 OCT   5
+}
+
+// rewindingDebugger runs the CPU back as far as its history goes the first
+// time it reaches an instruction.
+type rewindingDebugger struct {
+	pa   uint16
+	done bool
+}
+
+func (d *rewindingDebugger) Debug(e DebugEvent) {
+	if e.kind == evInstruction && e.pa == d.pa && !d.done {
+		d.done = true
+		for e.cpu.reverse(nil) {
+		}
+	}
+}
+
+func TestHistoryUndo(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(10)
+	for i := 0; i < 4; i++ {
+		c.step()
+	}
+	require.Equal(t, uint16(04004), c.reg[regZ], "arrange failed")
+	require.Equal(t, uint16(5), c.chans[010], "arrange failed")
+
+	// act
+	s1 := c.undo()
+	s2 := c.undo()
+
+	// assert
+	assert.NotNil(t, s1)
+	assert.NotNil(t, s2)
+	assert.Equal(t, uint16(04002), c.reg[regZ], "register Z")
+	assert.False(t, c.extended, "extended")
+	assert.Equal(t, uint16(0), c.chans[010], "channel 10")
+	assert.Equal(t, uint64(4), c.cycles, "cycles")
+}
+
+func TestHistoryUndoSwitchedBank(t *testing.T) {
+	// arrange
+	c := newTestCPU(t,
+		034002, // 04000 CA    04002
+		055412, // 04001 TS    01412
+		000005, // 04002 OCT   5
+	)
+	c.RecordHistory(10)
+	c.reg.Set(regEB, 05<<8)
+	c.step()
+	c.step()
+	// move on to another bank
+	c.reg.Set(regEB, 0)
+	_, err := c.mm.Read(01412)
+	require.NoError(t, err)
+
+	// act
+	c.undo()
+
+	// assert
+	c.reg.Set(regEB, 05<<8)
+	val, err := c.mm.Read(01412)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), val, "E5 @ 01412")
+}
+
+func TestHistoryRecordsInstructions(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(10)
+
+	// act
+	c.step()
+	c.step()
+
+	// assert
+	s := c.history.current()
+	require.NotNil(t, s)
+	assert.True(t, s.executed, "executed")
+	assert.Equal(t, uint16(04001), s.pa, "psudo-address")
+	assert.Equal(t, uint16(054100), s.code, "instruction code")
+	assert.Nil(t, s.seq, "sequence")
+}
+
+func TestHistoryRecordsGOJAM(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(10)
+	c.step()
+	c.jam = "parity fail"
+
+	// act
+	c.step()
+
+	// assert
+	s := c.history.current()
+	require.NotNil(t, s)
+	assert.False(t, s.executed, "executed")
+	assert.Equal(t, []transition{{jammed, c.cycles, "parity fail"}}, s.transitions)
+}
+
+func TestHistoryBounded(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(3)
+	for i := 0; i < 10; i++ {
+		c.step()
+	}
+
+	// act
+	var undone int
+	for c.undo() != nil {
+		undone++
+	}
+
+	// assert
+	assert.Equal(t, 3, undone)
+}
+
+func TestReverseToLastWrite(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.RecordHistory(100)
+	for i := 0; i < 5; i++ {
+		c.step()
+	}
+	// the CPU is always mid-step when a debugger reverses it
+	c.history.record(c)
+
+	// act
+	ok := c.reverse(func(s *snapshot) bool {
+		for _, w := range s.writes {
+			if !w.channel && w.pa == 0100 {
+				return true
+			}
+		}
+		return false
+	})
+
+	// assert
+	assert.True(t, ok, "result")
+	assert.True(t, c.rewound, "rewound")
+	assert.Equal(t, uint16(04001), c.reg[regZ], "register Z")
+	val, err := c.mm.Read(0100)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), val, "memory @ 0100")
+}
+
+func TestReverseWithoutHistory(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	c.step()
+
+	// act
+	ok := c.reverse(nil)
+
+	// assert
+	assert.False(t, ok)
+	assert.Equal(t, uint16(04001), c.reg[regZ], "register Z")
+}
@@ -8,32 +8,76 @@ type instruction struct {
 	name        string
 	code        uint16
 	addressMask uint16
-	timing      int
-	execute     func(*CPU, *instruction, uint16) error
+	// timing is the number of MCTs the instruction takes, which execute
+	// can add to when it takes a longer path
+	timing int
+	// execute is nil for instructions which are decoded but not implemented
+	execute func(*CPU, *instruction, uint16) error
 }
 
 func decodeInstruction(machineCode uint16) (instruction, uint16, error) {
+	return decodeFrom(instructionSet, machineCode)
+}
+
+// decodeExtendedInstruction decodes an instruction which follows an EXTEND.
+func decodeExtendedInstruction(machineCode uint16) (instruction, uint16, error) {
+	return decodeFrom(extendedInstructionSet, machineCode)
+}
+
+func decodeFrom(set []instruction, machineCode uint16) (instruction, uint16, error) {
 	var bestMatch *instruction
-	for i, inst := range instructionSet {
+	for i, inst := range set {
 		if inst.code == machineCode&^inst.addressMask && (bestMatch == nil || inst.addressMask < bestMatch.addressMask) {
-			bestMatch = &instructionSet[i]
+			bestMatch = &set[i]
 		}
 	}
 
 	if bestMatch == nil {
 		return instruction{}, 0, errors.Errorf("bad instruction: %05o", machineCode)
 	}
+	if bestMatch.execute == nil {
+		return instruction{}, 0, errors.Errorf("unimplemented instruction %s: %05o", bestMatch.name, machineCode)
+	}
 
 	return *bestMatch, machineCode & bestMatch.addressMask, nil
 }
 
 const (
 	maskNoAddress    = 00000
+	mask9BitChannel  = 00777
 	mask10BitAddress = 01777
 	mask12BitAddress = 07777
 )
 
 var instructionSet = []instruction{
+	instruction{
+		name:        "TC",
+		code:        000000,
+		addressMask: mask12BitAddress,
+		timing:      1,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			// Z has already been incremented so it holds the return address
+			ret := c.reg[regZ]
+			if addr == uint16(regQ) {
+				// TC Q (RETURN) transfers control to the address held
+				// in Q, which the hardware accomplishes by executing the
+				// contents of Q as the next instruction (a TC itself)
+				c.reg.Set(regZ, c.reg[regQ])
+				c.frames.returnTo(c.reg[regZ])
+				return nil
+			}
+
+			c.reg.Set(regQ, ret)
+			c.reg.Set(regZ, addr)
+			c.frames.push(frame{
+				kind:  callFrame,
+				entry: c.psudoAddress(addr),
+				from:  c.psudoAddress(ret - 1),
+				ret:   ret,
+			})
+			return nil
+		},
+	},
 	instruction{
 		name:        "RELINT",
 		code:        000003,
@@ -54,6 +98,28 @@ var instructionSet = []instruction{
 			return nil
 		},
 	},
+	instruction{
+		name:        "RESUME",
+		code:        050017,
+		addressMask: maskNoAddress,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regZ, c.reg[regZRUPT])
+			c.inRupt = false
+			c.frames.resume()
+			return nil
+		},
+	},
+	instruction{
+		name:        "EXTEND",
+		code:        000006,
+		addressMask: maskNoAddress,
+		timing:      1,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.extended = true
+			return nil
+		},
+	},
 	instruction{
 		name:        "TCF",
 		code:        010000,
@@ -64,6 +130,46 @@ var instructionSet = []instruction{
 			return nil
 		},
 	},
+	instruction{
+		// CCS shares its opcode with TCF, but only addresses erasable memory
+		name:        "CCS",
+		code:        010000,
+		addressMask: mask10BitAddress,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			val, err := c.mm.Read(int(addr))
+			if err != nil {
+				return err
+			}
+			if addr != uint16(regA) {
+				// sign extend words from memory to 16 bits like A
+				val |= val & 040000 << 1
+			}
+			// CCS edits the value it reads, like CA
+			if err := c.mm.Write(int(addr), val); err != nil {
+				return err
+			}
+			// take the sign from the 16th bit, in case A overflowed
+			val = val&037777 | val&0100000>>1
+
+			// A gets the diminished absolute value and one of the
+			// next four instructions is chosen by the value's sign
+			switch {
+			case val == 0:
+				c.reg.Set(regA, 0)
+				c.reg[regZ]++
+			case val == 077777:
+				c.reg.Set(regA, 0)
+				c.reg[regZ] += 3
+			case val&040000 == 0:
+				c.reg.Set(regA, val-1)
+			default:
+				c.reg.Set(regA, ^val&077777-1)
+				c.reg[regZ] += 2
+			}
+			return nil
+		},
+	},
 	instruction{
 		name:        "CA",
 		code:        030000,
@@ -165,16 +271,137 @@ var instructionSet = []instruction{
 	},
 }
 
+var extendedInstructionSet = []instruction{
+	instruction{
+		name:        "READ",
+		code:        000000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.readChannel(addr))
+			return nil
+		},
+	},
+	instruction{
+		name:        "WRITE",
+		code:        001000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.writeChannel(addr, c.reg[regA])
+			return nil
+		},
+	},
+	instruction{
+		name:        "RAND",
+		code:        002000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.reg[regA]&c.readChannel(addr))
+			return nil
+		},
+	},
+	instruction{
+		name:        "WAND",
+		code:        003000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.reg[regA]&c.readChannel(addr))
+			c.writeChannel(addr, c.reg[regA])
+			return nil
+		},
+	},
+	instruction{
+		name:        "ROR",
+		code:        004000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.reg[regA]|c.readChannel(addr))
+			return nil
+		},
+	},
+	instruction{
+		name:        "WOR",
+		code:        005000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.reg[regA]|c.readChannel(addr))
+			c.writeChannel(addr, c.reg[regA])
+			return nil
+		},
+	},
+	instruction{
+		// BZF only addresses fixed memory, the rest of its opcode being DV
+		name:        "BZF",
+		code:        010000,
+		addressMask: mask12BitAddress,
+		timing:      1,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			if c.reg[regA] == 0 || c.reg[regA] == 0177777 {
+				c.reg.Set(regZ, addr)
+			} else {
+				// a branch which isn't taken takes another MCT
+				i.timing++
+			}
+			return nil
+		},
+	},
+	instruction{
+		name:        "DV",
+		code:        010000,
+		addressMask: mask10BitAddress,
+		timing:      6,
+	},
+	instruction{
+		// BZMF only addresses fixed memory, the rest of its opcode being SU
+		name:        "BZMF",
+		code:        060000,
+		addressMask: mask12BitAddress,
+		timing:      1,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			if c.reg[regA] == 0 || c.reg[regA]&0100000 != 0 {
+				c.reg.Set(regZ, addr)
+			} else {
+				// a branch which isn't taken takes another MCT
+				i.timing++
+			}
+			return nil
+		},
+	},
+	instruction{
+		name:        "SU",
+		code:        060000,
+		addressMask: mask10BitAddress,
+		timing:      2,
+	},
+	instruction{
+		name:        "RXOR",
+		code:        006000,
+		addressMask: mask9BitChannel,
+		timing:      2,
+		execute: func(c *CPU, i *instruction, addr uint16) error {
+			c.reg.Set(regA, c.reg[regA]^c.readChannel(addr))
+			return nil
+		},
+	},
+}
+
 type sequence struct {
 	name    string
+	counter register
 	timing  int
 	execute func(*CPU, *sequence) *sequence
 }
 
 var (
 	usPINCTime1 = sequence{
-		name:   "PINC TIME1",
-		timing: 1,
+		name:    "PINC TIME1",
+		counter: regTIME1,
+		timing:  1,
 		execute: func(c *CPU, seq *sequence) *sequence {
 			if c.reg.Increment(regTIME1) {
 				return &usPINCTime2
@@ -183,16 +410,18 @@ var (
 		},
 	}
 	usPINCTime2 = sequence{
-		name:   "PINC TIME2",
-		timing: 1,
+		name:    "PINC TIME2",
+		counter: regTIME2,
+		timing:  1,
 		execute: func(c *CPU, seq *sequence) *sequence {
 			c.reg.Increment(regTIME2)
 			return nil
 		},
 	}
 	usPINCTime3 = sequence{
-		name:   "PINC TIME3",
-		timing: 1,
+		name:    "PINC TIME3",
+		counter: regTIME3,
+		timing:  1,
 		execute: func(c *CPU, seq *sequence) *sequence {
 			if c.reg.Increment(regTIME3) {
 				c.interrupt(intT3RUPT)
@@ -201,8 +430,9 @@ var (
 		},
 	}
 	usPINCTime4 = sequence{
-		name:   "PINC TIME4",
-		timing: 1,
+		name:    "PINC TIME4",
+		counter: regTIME4,
+		timing:  1,
 		execute: func(c *CPU, seq *sequence) *sequence {
 			if c.reg.Increment(regTIME4) {
 				c.interrupt(intT4RUPT)
@@ -211,8 +441,9 @@ var (
 		},
 	}
 	usPINCTime5 = sequence{
-		name:   "PINC TIME5",
-		timing: 1,
+		name:    "PINC TIME5",
+		counter: regTIME5,
+		timing:  1,
 		execute: func(c *CPU, seq *sequence) *sequence {
 			if c.reg.Increment(regTIME5) {
 				c.interrupt(intT5RUPT)
@@ -221,3 +452,104 @@ var (
 		},
 	}
 )
+
+// CounterKind is a kind of unprogrammed sequence a device can use to change
+// one of the counters from CDUX to ALTM.
+type CounterKind int
+
+const (
+	// PINC adds one to a counter, in ones' complement.
+	PINC CounterKind = iota
+	// PCDU adds one to a CDU counter, which counts in two's complement.
+	PCDU
+	// MINC subtracts one from a counter, in ones' complement.
+	MINC
+	// MCDU subtracts one from a CDU counter.
+	MCDU
+	// DINC moves a counter one step towards zero, as the AGC does when
+	// it drives a gyro or the thrust and altitude meters.
+	DINC
+	// SHINC shifts a counter left, as the serial uplink does with a 0 bit.
+	SHINC
+	// SHANC shifts a counter left and adds one, for a 1 bit.
+	SHANC
+	counterKindCount
+)
+
+var counterKindNames = [counterKindCount]string{
+	"PINC", "PCDU", "MINC", "MCDU", "DINC", "SHINC", "SHANC",
+}
+
+func (k CounterKind) String() string {
+	if k < 0 || k >= counterKindCount {
+		return "COUNT?"
+	}
+	return counterKindNames[k]
+}
+
+// count works out the new value of a 15 bit counter.
+func (k CounterKind) count(val uint16) uint16 {
+	const minusZero, maxPositive, maxNegative = 077777, 037777, 040000
+	switch k {
+	case PINC:
+		switch val {
+		case maxPositive:
+			return 0
+		case minusZero:
+			return 1
+		}
+		return val + 1
+	case MINC:
+		switch val {
+		case maxNegative:
+			return minusZero
+		case 0:
+			return minusZero - 1
+		}
+		return val - 1
+	case PCDU:
+		return (val + 1) & 077777
+	case MCDU:
+		return (val - 1) & 077777
+	case DINC:
+		switch {
+		case val == 0 || val == minusZero:
+			return val
+		case val < maxNegative:
+			return val - 1
+		}
+		return val + 1
+	case SHINC:
+		return val << 1 & 077777
+	case SHANC:
+		return (val<<1 | 1) & 077777
+	}
+	return val
+}
+
+// counterSequences holds the sequence of each kind for each of the counters
+// from CDUX to ALTM. The timers before them are counted by the CPU itself.
+var counterSequences [regALTM - regCDUX + 1][counterKindCount]sequence
+
+func init() {
+	for r := regCDUX; r <= regALTM; r++ {
+		for k := CounterKind(0); k < counterKindCount; k++ {
+			k := k
+			counterSequences[r-regCDUX][k] = sequence{
+				name:    k.String() + " " + r.String(),
+				counter: r,
+				timing:  1,
+				execute: func(c *CPU, seq *sequence) *sequence {
+					old := c.reg[seq.counter]
+					c.reg.Set(seq.counter, k.count(old))
+					// uplinked words are sent after a 1 bit, so a word
+					// is complete when that bit is shifted out of the top
+					if seq.counter == regINLINK && (k == SHINC || k == SHANC) && old&040000 != 0 {
+						c.interrupt(intUPRUPT)
+					}
+					return nil
+				},
+			}
+		}
+	}
+}
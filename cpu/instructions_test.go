@@ -18,6 +18,76 @@ func TestDecodeInstruction(t *testing.T) {
 	assert.Equal(t, uint16(07777), address, "address")
 }
 
+func TestDecodeExtendedInstruction(t *testing.T) {
+	// act
+	instr, address, err := decodeExtendedInstruction(001000 + 0777)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, "WRITE", instr.name, "instr.name")
+	assert.Equal(t, uint16(0777), address, "address")
+}
+
+func TestDecodeExtendedUnimplemented(t *testing.T) {
+	scenarios := []struct {
+		code uint16
+		err  string
+	}{
+		{010100, "unimplemented instruction DV: 10100"},
+		{011777, "unimplemented instruction DV: 11777"},
+		{060100, "unimplemented instruction SU: 60100"},
+		{061777, "unimplemented instruction SU: 61777"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(fmt.Sprintf("%05o", scenario.code), func(t *testing.T) {
+			// act
+			_, _, err := decodeExtendedInstruction(scenario.code)
+
+			// assert
+			assert.EqualError(t, err, scenario.err)
+		})
+	}
+}
+
+func TestDecodeExtendedBranches(t *testing.T) {
+	scenarios := []struct {
+		code uint16
+		name string
+	}{
+		{012000, "BZF"},
+		{017777, "BZF"},
+		{062000, "BZMF"},
+		{067777, "BZMF"},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(fmt.Sprintf("%05o", scenario.code), func(t *testing.T) {
+			// act
+			instr, address, err := decodeExtendedInstruction(scenario.code)
+
+			// assert
+			assert.NoError(t, err)
+			assert.Equal(t, scenario.name, instr.name, "instr.name")
+			assert.Equal(t, scenario.code&07777, address, "address")
+		})
+	}
+}
+
+func TestChannelRoundTrip(t *testing.T) {
+	// arrange
+	cpu := NewCPU(nil)
+
+	// act
+	cpu.writeChannel(010, 0140123)
+
+	// assert
+	// the 16 bit value is overflow corrected when stored and
+	// then sign extended when read back into register A
+	assert.Equal(t, uint16(040123), cpu.chans[010], "channel 10")
+	assert.Equal(t, uint16(0140123), cpu.readChannel(010), "read channel 10")
+}
+
 func TestInstructionRELINT(t *testing.T) {
 	runInstructionTest(t, "RELINT", "", func(t *testing.T, cpu *CPU, i *instruction) {
 		// arrange
@@ -46,6 +116,59 @@ func TestInstructionINHINT(t *testing.T) {
 	})
 }
 
+func TestInstructionTC(t *testing.T) {
+	runInstructionTest(t, "TC", "call", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regZ, 04124) // Z has already moved past the TC
+
+		// act
+		err := i.execute(cpu, i, 04500)
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(04500), cpu.reg[regZ], "register Z")
+		assert.Equal(t, uint16(04124), cpu.reg[regQ], "register Q")
+		if assert.Len(t, cpu.frames, 1, "frames") {
+			assert.Equal(t, uint16(04123), cpu.frames[0].from, "frame from")
+			assert.Equal(t, uint16(04500), cpu.frames[0].entry, "frame entry")
+		}
+	})
+
+	runInstructionTest(t, "TC", "return", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regZ, 04124)
+		require.NoError(t, i.execute(cpu, i, 04500))
+		cpu.reg.Set(regZ, 04502)
+
+		// act
+		err := i.execute(cpu, i, uint16(regQ))
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(04124), cpu.reg[regZ], "register Z")
+		assert.Len(t, cpu.frames, 0, "frames")
+	})
+}
+
+func TestInstructionRESUME(t *testing.T) {
+	runInstructionTest(t, "RESUME", "", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regZ, 04123)
+		cpu.interrupt(intT3RUPT)
+		cpu.enterInterrupt()
+		require.Equal(t, uint16(04014), cpu.reg[regZ], "arrange failed")
+
+		// act
+		err := i.execute(cpu, i, 0)
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(04123), cpu.reg[regZ], "register Z")
+		assert.False(t, cpu.inRupt, "inRupt")
+		assert.Len(t, cpu.frames, 0, "frames")
+	})
+}
+
 func TestInstructionTCF(t *testing.T) {
 	runInstructionTest(t, "TCF", "", func(t *testing.T, cpu *CPU, i *instruction) {
 		// arrange
@@ -299,6 +422,145 @@ func TestInstructionTS(t *testing.T) {
 	})
 }
 
+func TestInstructionCCS(t *testing.T) {
+	tests := []struct {
+		scenario string
+		val      uint16
+		a        uint16
+		skip     uint16
+	}{
+		{"positive", 0123, 0122, 0},
+		{"plus zero", 0, 0, 1},
+		{"negative", 0177654, 0122, 2},
+		{"minus zero", 0177777, 0, 3},
+	}
+	for _, tt := range tests {
+		tt := tt
+		runInstructionTest(t, "CCS", tt.scenario, func(t *testing.T, cpu *CPU, i *instruction) {
+			// arrange
+			require.NoError(t, cpu.mm.Write(0100, tt.val))
+			cpu.reg.Set(regZ, 100)
+
+			// act
+			err := i.execute(cpu, i, 0100)
+
+			// assert
+			assert.NoError(t, err)
+			assert.Equal(t, tt.a, cpu.reg[regA], "register A")
+			assert.Equal(t, 100+tt.skip, cpu.reg[regZ], "register Z")
+		})
+	}
+
+	runInstructionTest(t, "CCS", "overflowed A", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regA, 0100123)
+		cpu.reg.Set(regZ, 100)
+
+		// act
+		err := i.execute(cpu, i, uint16(regA))
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(037653), cpu.reg[regA])
+		assert.Equal(t, uint16(102), cpu.reg[regZ])
+	})
+}
+
+func TestInstructionBZF(t *testing.T) {
+	for _, a := range []uint16{0, 0177777} {
+		runInstructionTest(t, "BZF", fmt.Sprintf("A=%06o", a), func(t *testing.T, cpu *CPU, i *instruction) {
+			// arrange
+			cpu.reg.Set(regA, a)
+			cpu.reg.Set(regZ, 100)
+
+			// act
+			err := i.execute(cpu, i, 04500)
+
+			// assert
+			assert.NoError(t, err)
+			assert.Equal(t, uint16(04500), cpu.reg[regZ])
+			assert.Equal(t, 1, i.timing, "timing")
+		})
+	}
+
+	runInstructionTest(t, "BZF", "not zero", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regA, 0177776)
+		cpu.reg.Set(regZ, 100)
+
+		// act
+		err := i.execute(cpu, i, 04500)
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(100), cpu.reg[regZ])
+		assert.Equal(t, 2, i.timing, "a branch not taken takes another MCT")
+	})
+}
+
+func TestBZFCycles(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		a      uint16
+		z      uint16
+		cycles uint64
+	}{
+		{"taken", 0, 04010, 2},
+		{"not taken", 1, 04002, 3},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			// arrange
+			c := newTestCPU(t,
+				000006, // 04000 EXTEND
+				014010, // 04001 BZF 04010
+			)
+			c.reg.Set(regA, scenario.a)
+
+			// act
+			c.step()
+			c.step()
+
+			// assert
+			assert.Equal(t, scenario.z, c.reg[regZ], "register Z")
+			assert.Equal(t, scenario.cycles, c.cycles, "cycles")
+		})
+	}
+}
+
+func TestInstructionBZMF(t *testing.T) {
+	for _, a := range []uint16{0, 0177777, 0177776} {
+		runInstructionTest(t, "BZMF", fmt.Sprintf("A=%06o", a), func(t *testing.T, cpu *CPU, i *instruction) {
+			// arrange
+			cpu.reg.Set(regA, a)
+			cpu.reg.Set(regZ, 100)
+
+			// act
+			err := i.execute(cpu, i, 04500)
+
+			// assert
+			assert.NoError(t, err)
+			assert.Equal(t, uint16(04500), cpu.reg[regZ])
+			assert.Equal(t, 1, i.timing, "timing")
+		})
+	}
+
+	runInstructionTest(t, "BZMF", "positive", func(t *testing.T, cpu *CPU, i *instruction) {
+		// arrange
+		cpu.reg.Set(regA, 1)
+		cpu.reg.Set(regZ, 100)
+
+		// act
+		err := i.execute(cpu, i, 04500)
+
+		// assert
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(100), cpu.reg[regZ])
+		assert.Equal(t, 2, i.timing, "a branch not taken takes another MCT")
+	})
+}
+
 func runInstructionTest(t *testing.T, name, scenario string, f func(*testing.T, *CPU, *instruction)) {
 	subTestName := "instruction " + name
 	if len(scenario) > 0 {
@@ -313,9 +575,11 @@ func runInstructionTest(t *testing.T, name, scenario string, f func(*testing.T,
 }
 
 func getInstruction(name string) instruction {
-	for _, i := range instructionSet {
-		if i.name == name {
-			return i
+	for _, set := range [][]instruction{instructionSet, extendedInstructionSet} {
+		for _, i := range set {
+			if i.name == name {
+				return i
+			}
 		}
 	}
 	panic(fmt.Sprintf("instruction %s not found!", name))
@@ -1,6 +1,43 @@
 package cpu
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// LogOptions configures what the CPU logs while it runs. Nothing is
+// logged unless at least one kind of event is chosen and there is
+// somewhere to write it, in which case logging costs nothing.
+type LogOptions struct {
+	// Instructions logs every instruction before it executes.
+	Instructions bool
+	// Timers logs the timers rolling over.
+	Timers bool
+	// Sequences logs the unprogrammed sequences (such as counter increments).
+	Sequences bool
+	// Interrupts logs the CPU entering interrupts.
+	Interrupts bool
+
+	// Ranges limits the instructions logged to those at psudo-addresses in
+	// these ranges. The other kinds of event are logged wherever they happen.
+	Ranges []AddressRange
+
+	// Output receives the log, with one line for each event. The CPU writes
+	// to it as it runs, so a slow writer should be buffered.
+	Output io.Writer
+	// JSON writes each event as a JSON object instead of text.
+	JSON bool
+}
+
+// AddressRange is an inclusive range of psudo-addresses.
+type AddressRange struct {
+	Start, End uint16
+}
+
+func (r AddressRange) contains(pa uint16) bool {
+	return pa >= r.Start && pa <= r.End
+}
 
 type logEventType int
 
@@ -8,43 +45,103 @@ const (
 	logInstruction logEventType = iota
 	logTimer
 	logUSequence
+	logInterrupt
 )
 
+var logEventTypeNames = [...]string{"instruction", "timer", "sequence", "interrupt"}
+
 type logEvent interface {
 	fmt.Stringer
 	Type() logEventType
+	// fields returns the event's fields for JSON
+	fields() map[string]interface{}
 }
 
+// logger writes the events chosen by a LogOptions. A nil
+// logger logs nothing, so its methods can always be called.
 type logger struct {
-	logc         chan logEvent
-	enabledTypes map[logEventType]bool
+	opts    LogOptions
+	enabled [len(logEventTypeNames)]bool
 }
 
-func newLogger(buffer int) *logger {
-	return &logger{
-		logc:         make(chan logEvent, buffer),
-		enabledTypes: make(map[logEventType]bool),
+// newLogger creates a logger for the options, returning nil if nothing is to be logged.
+func newLogger(opts LogOptions) *logger {
+	l := &logger{opts: opts}
+	l.enabled[logInstruction] = opts.Instructions
+	l.enabled[logTimer] = opts.Timers
+	l.enabled[logUSequence] = opts.Sequences
+	l.enabled[logInterrupt] = opts.Interrupts
+
+	for _, on := range l.enabled {
+		if on && opts.Output != nil {
+			return l
+		}
 	}
+	return nil
 }
 
-func (l *logger) process() {
-	for e := range l.logc {
-		if l.enabledTypes[e.Type()] {
-			fmt.Println(e.String())
+func (l *logger) instruction(cycles uint64, z, pa, code uint16, instr *instruction, address uint16) {
+	if l == nil || !l.enabled[logInstruction] {
+		return
+	}
+	if len(l.opts.Ranges) > 0 {
+		in := false
+		for _, r := range l.opts.Ranges {
+			in = in || r.contains(pa)
+		}
+		if !in {
+			return
 		}
 	}
+	l.log(cycles, instructionEvent{z: z, pa: pa, code: code, instr: instr, address: address})
 }
 
-func (l *logger) stop() {
-	close(l.logc)
+func (l *logger) timer(cycles uint64, name string) {
+	if l == nil || !l.enabled[logTimer] {
+		return
+	}
+	l.log(cycles, timerEvent{name: name})
+}
+
+func (l *logger) sequence(cycles uint64, seq *sequence) {
+	if l == nil || !l.enabled[logUSequence] {
+		return
+	}
+	l.log(cycles, uSequenceEvent{seq: seq})
 }
 
-func (l *logger) log(e logEvent) {
-	l.logc <- e
+func (l *logger) interrupt(cycles uint64, i interrupt, zrupt, brupt uint16) {
+	if l == nil || !l.enabled[logInterrupt] {
+		return
+	}
+	l.log(cycles, interruptEvent{rupt: i, zrupt: zrupt, brupt: brupt})
+}
+
+func (l *logger) log(cycles uint64, e logEvent) {
+	if !l.opts.JSON {
+		fmt.Fprintln(l.opts.Output, e.String())
+		return
+	}
+
+	// every JSON event has its type and the cycle count it
+	// happened at along with the fields of the event itself
+	fields := map[string]interface{}{
+		"type":   logEventTypeNames[e.Type()],
+		"cycles": cycles,
+	}
+	for k, v := range e.fields() {
+		fields[k] = v
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+	l.opts.Output.Write(append(b, '\n'))
 }
 
 type instructionEvent struct {
 	z       uint16
+	pa      uint16
 	code    uint16
 	instr   *instruction
 	address uint16
@@ -56,6 +153,16 @@ func (e instructionEvent) String() string {
 	return fmt.Sprintf("%04o: %05o (%04x) {%-6s %05o}", e.z, e.code, e.code, e.instr.name, e.address)
 }
 
+func (e instructionEvent) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"z":       e.z,
+		"pa":      e.pa,
+		"code":    e.code,
+		"instr":   e.instr.name,
+		"operand": e.address,
+	}
+}
+
 type uSequenceEvent struct {
 	seq *sequence
 }
@@ -66,6 +173,10 @@ func (e uSequenceEvent) String() string {
 	return fmt.Sprintf("----: %s", e.seq.name)
 }
 
+func (e uSequenceEvent) fields() map[string]interface{} {
+	return map[string]interface{}{"name": e.seq.name}
+}
+
 type timerEvent struct {
 	name string
 }
@@ -75,3 +186,26 @@ func (e timerEvent) Type() logEventType { return logTimer }
 func (e timerEvent) String() string {
 	return fmt.Sprintf("Timer %s fired!", e.name)
 }
+
+func (e timerEvent) fields() map[string]interface{} {
+	return map[string]interface{}{"name": e.name}
+}
+
+type interruptEvent struct {
+	rupt         interrupt
+	zrupt, brupt uint16
+}
+
+func (e interruptEvent) Type() logEventType { return logInterrupt }
+
+func (e interruptEvent) String() string {
+	return fmt.Sprintf("INT! %04o - ZRUPT:%05o BRUPT:%05o", int(e.rupt), e.zrupt, e.brupt)
+}
+
+func (e interruptEvent) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"name":  e.rupt.String(),
+		"zrupt": e.zrupt,
+		"brupt": e.brupt,
+	}
+}
@@ -0,0 +1,75 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLoggerDisabled(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	assert.Nil(t, newLogger(LogOptions{Output: out}), "no events")
+	assert.Nil(t, newLogger(LogOptions{Instructions: true}), "no output")
+	assert.NotNil(t, newLogger(LogOptions{Timers: true, Output: out}))
+}
+
+func TestLogInstructionsText(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	c.log = newLogger(LogOptions{Instructions: true, Output: out})
+
+	// act
+	c.step()
+	c.step()
+
+	// assert
+	assert.Equal(t, "4000: 34010 (3808) {CA     04010}\n4001: 54100 (5840) {TS     00100}\n", out.String())
+}
+
+func TestLogInstructionsJSON(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	c.log = newLogger(LogOptions{Instructions: true, Output: out, JSON: true})
+
+	// act
+	c.step()
+
+	// assert
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, "instruction", got["type"])
+	assert.Equal(t, "CA", got["instr"])
+	assert.Equal(t, float64(04000), got["z"])
+	assert.Equal(t, float64(04000), got["pa"])
+	assert.Equal(t, float64(04010), got["operand"])
+	assert.Contains(t, got, "cycles")
+}
+
+func TestLogInstructionsInRange(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	c.log = newLogger(LogOptions{
+		Instructions: true,
+		Ranges:       []AddressRange{{Start: 04001, End: 04001}, {Start: 04004, End: 04010}},
+		Output:       out,
+	})
+
+	// act
+	for i := 0; i < 5; i++ {
+		c.step()
+	}
+
+	// assert
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "4001:"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "4004:"), lines[1])
+}
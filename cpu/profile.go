@@ -0,0 +1,271 @@
+package cpu
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+)
+
+// Profile counts the instructions executed and the memory cycles (MCTs) they
+// took for every call stack the CPU runs, so that it can be written in the
+// pprof format and examined with go tool pprof. Interrupt service routines
+// are given their own root, named after the interrupt, rather than appearing
+// under whatever code they interrupted, and the counter increments which
+// steal cycles from the program are roots named after their sequence.
+//
+// A Profile must not be written while the CPU it is attached to is running.
+type Profile struct {
+	// Symbols, if set, names the functions in the profile after the nearest
+	// label and gives their source lines. Otherwise every psudo-address is
+	// its own function, named in octal.
+	Symbols *symtab.Table
+
+	locs    map[profileLoc]uint32
+	ids     []profileLoc
+	samples map[string]*profileSample
+
+	// scratch space for building the key of each sample
+	stack []profileLoc
+	key   []byte
+}
+
+// profileLoc is either an instruction at a psudo-address or, for the roots
+// of interrupts and counters, the name of what the cycles were spent on.
+type profileLoc struct {
+	pa   uint16
+	name string
+}
+
+type profileSample struct {
+	locs         []uint32
+	instructions int64
+	cycles       int64
+}
+
+// NewProfile creates an empty Profile.
+func NewProfile() *Profile {
+	return &Profile{
+		locs:    make(map[profileLoc]uint32),
+		samples: make(map[string]*profileSample),
+	}
+}
+
+// instruction records an instruction at pa which took the given number of
+// cycles, with the call stack leading to it in frames.
+func (p *Profile) instruction(pa uint16, frames callStack, cycles int) {
+	if p == nil {
+		return
+	}
+	p.stack = append(p.stack[:0], profileLoc{pa: pa})
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		if f.kind == interruptFrame {
+			p.stack = append(p.stack, profileLoc{name: f.rupt.String()})
+			break
+		}
+		p.stack = append(p.stack, profileLoc{pa: f.from})
+	}
+	p.add(p.stack, 1, cycles)
+}
+
+// longer adds cycles to the instruction recorded last, which took a longer
+// path than usual.
+func (p *Profile) longer(cycles int) {
+	if p == nil {
+		return
+	}
+	p.add(p.stack, 0, cycles)
+}
+
+// sequence records an unprogrammed sequence which took the given number of cycles.
+func (p *Profile) sequence(seq *sequence, cycles int) {
+	if p == nil {
+		return
+	}
+	p.stack = append(p.stack[:0], profileLoc{name: seq.name})
+	p.add(p.stack, 0, cycles)
+}
+
+func (p *Profile) add(stack []profileLoc, instructions, cycles int) {
+	p.key = p.key[:0]
+	for _, l := range stack {
+		id, ok := p.locs[l]
+		if !ok {
+			p.ids = append(p.ids, l)
+			id = uint32(len(p.ids))
+			p.locs[l] = id
+		}
+		p.key = append(p.key, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	}
+
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &profileSample{locs: make([]uint32, len(stack))}
+		for i := range s.locs {
+			s.locs[i] = binary.BigEndian.Uint32(p.key[i*4:])
+		}
+		p.samples[string(p.key)] = s
+	}
+	s.instructions += int64(instructions)
+	s.cycles += int64(cycles)
+}
+
+// Write writes the profile to w as a gzipped pprof protocol buffer.
+func (p *Profile) Write(w io.Writer) error {
+	pb := &profileBuilder{strings: map[string]int64{"": 0}, stringList: []string{""}}
+
+	// sample_type
+	for _, vt := range [][2]string{{"instructions", "count"}, {"cycles", "count"}} {
+		var m protoMessage
+		m.int(1, pb.str(vt[0]))
+		m.int(2, pb.str(vt[1]))
+		pb.out.message(1, m)
+	}
+
+	// sample, in a fixed order so the same run always writes the same profile
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := p.samples[k]
+		var m, locs, values protoMessage
+		for _, id := range s.locs {
+			locs.varint(uint64(id))
+		}
+		values.varint(uint64(s.instructions))
+		values.varint(uint64(s.cycles))
+		m.bytes(1, locs)
+		m.bytes(2, values)
+		pb.out.message(2, m)
+	}
+
+	// location
+	for i, l := range p.ids {
+		var m, line protoMessage
+		m.int(1, int64(i+1))
+		line.int(1, p.function(pb, l))
+		if l.name == "" {
+			m.int(3, int64(l.pa))
+			if loc, ok := p.line(l.pa); ok {
+				line.int(2, int64(loc.Line))
+			}
+		}
+		m.message(4, line)
+		pb.out.message(4, m)
+	}
+
+	// function
+	for _, f := range pb.functions {
+		pb.out.message(5, f)
+	}
+
+	// string_table
+	for _, s := range pb.stringList {
+		pb.out.bytes(6, []byte(s))
+	}
+
+	// period_type and period, one cycle per cycle
+	var period protoMessage
+	period.int(1, pb.str("cycles"))
+	period.int(2, pb.str("count"))
+	pb.out.message(11, period)
+	pb.out.int(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(pb.out); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (p *Profile) line(pa uint16) (symtab.Location, bool) {
+	if p.Symbols == nil {
+		return symtab.Location{}, false
+	}
+	return p.Symbols.Line(pa)
+}
+
+// function returns the id of the function containing the location, adding it if it's new.
+func (p *Profile) function(pb *profileBuilder, l profileLoc) int64 {
+	name, start := l.name, l.pa
+	if name == "" {
+		name = fmt.Sprintf("%05o", l.pa)
+		if p.Symbols != nil {
+			if n, off, ok := p.Symbols.Nearest(l.pa); ok {
+				name, start = n, l.pa-off
+			}
+		}
+	}
+	if id, ok := pb.funcIDs[name]; ok {
+		return id
+	}
+
+	id := int64(len(pb.functions) + 1)
+	var m protoMessage
+	m.int(1, id)
+	m.int(2, pb.str(name))
+	m.int(3, pb.str(name))
+	if l.name == "" {
+		if loc, ok := p.line(start); ok {
+			m.int(4, pb.str(loc.File))
+			m.int(5, int64(loc.Line))
+		}
+	}
+	pb.functions = append(pb.functions, m)
+	if pb.funcIDs == nil {
+		pb.funcIDs = make(map[string]int64)
+	}
+	pb.funcIDs[name] = id
+	return id
+}
+
+type profileBuilder struct {
+	out        protoMessage
+	strings    map[string]int64
+	stringList []string
+	functions  []protoMessage
+	funcIDs    map[string]int64
+}
+
+// str returns the index of s in the string table, adding it if it's new.
+func (pb *profileBuilder) str(s string) int64 {
+	if i, ok := pb.strings[s]; ok {
+		return i
+	}
+	i := int64(len(pb.stringList))
+	pb.stringList = append(pb.stringList, s)
+	pb.strings[s] = i
+	return i
+}
+
+// protoMessage encodes the few parts of the protocol buffer wire format a profile needs.
+type protoMessage []byte
+
+func (m *protoMessage) varint(v uint64) {
+	for v >= 0x80 {
+		*m = append(*m, byte(v)|0x80)
+		v >>= 7
+	}
+	*m = append(*m, byte(v))
+}
+
+func (m *protoMessage) int(field int, v int64) {
+	m.varint(uint64(field) << 3)
+	m.varint(uint64(v))
+}
+
+func (m *protoMessage) bytes(field int, b []byte) {
+	m.varint(uint64(field)<<3 | 2)
+	m.varint(uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+func (m *protoMessage) message(field int, msg protoMessage) {
+	m.bytes(field, msg)
+}
@@ -0,0 +1,123 @@
+package cpu
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callProgram calls a subroutine in a loop.
+var callProgram = []uint16{
+	004003, // 04000 MAIN TC     SUB
+	014000, // 04001      TCF    MAIN
+	000000,
+	000002, // 04003 SUB  RETURN
+}
+
+// stacks returns the psudo-addresses (or names) of each sample's stack, leaf first.
+func stacks(p *Profile) map[string]int64 {
+	got := make(map[string]int64)
+	for _, s := range p.samples {
+		key := ""
+		for _, id := range s.locs {
+			l := p.ids[id-1]
+			if l.name == "" {
+				key += fmt.Sprintf(" %05o", l.pa)
+			} else {
+				key += " " + l.name
+			}
+		}
+		got[key[1:]] = s.cycles
+	}
+	return got
+}
+
+func TestProfileCallStacks(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, callProgram...)
+	c.Profile = NewProfile()
+
+	// act
+	for i := 0; i < 6; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, map[string]int64{
+		"04000":       2,
+		"04003 04000": 2,
+		"04001":       2,
+	}, stacks(c.Profile))
+}
+
+func TestProfileReplay(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, callProgram...)
+	c.Profile = NewProfile()
+	c.RecordHistory(10)
+	c.Debugger = &rewindingDebugger{pa: 04001}
+
+	// act
+	for i := 0; i < 3+6; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, map[string]int64{
+		"04000":       2,
+		"04003 04000": 2,
+		"04001":       2,
+	}, stacks(c.Profile))
+}
+
+func TestProfileInterruptRoots(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.Profile = NewProfile()
+	c.reg.Set(regTIME4, 077777)
+
+	// act
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+
+	// assert
+	got := stacks(c.Profile)
+	assert.Contains(t, got, "04020 T4RUPT")
+	assert.Contains(t, got, "PINC TIME4")
+}
+
+func TestProfileWrite(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, callProgram...)
+	c.Profile = NewProfile()
+	c.Profile.Symbols = symtab.New()
+	c.Profile.Symbols.Define("MAIN", 04000)
+	c.Profile.Symbols.Define("SUB", 04003)
+	c.Profile.Symbols.SetLine(04003, symtab.Location{File: "main.agc", Line: 12})
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+	buf := new(bytes.Buffer)
+
+	// act
+	err := c.Profile.Write(buf)
+
+	// assert
+	require.NoError(t, err)
+	r, err := gzip.NewReader(buf)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	for _, s := range []string{"MAIN", "SUB", "main.agc", "cycles", "instructions"} {
+		assert.Contains(t, string(b), s)
+	}
+}
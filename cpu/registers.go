@@ -1,6 +1,7 @@
 package cpu
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/Elsewhen-Studios/go-agc/memory"
@@ -35,8 +36,47 @@ const (
 	regTIME4
 	regTIME5
 	regTIME6
+	regCDUX
+	regCDUY
+	regCDUZ
+	regOPTY
+	regOPTX
+	regPIPAX
+	regPIPAY
+	regPIPAZ
+	regRHCP
+	regRHCY
+	regRHCR
+	regINLINK
+	regRNRAD
+	regGYROCTR
+	regCDUXCMD
+	regCDUYCMD
+	regCDUZCMD
+	regOPTYCMD
+	regOPTXCMD
+	regTHRUST
+	regLEMONM
+	regOUTLINK
+	regALTM
 )
 
+var registerNames = [...]string{
+	"A", "L", "Q", "EB", "FB", "Z", "BB", "ZERO", "ARUPT", "LRUPT", "QRUPT",
+	"SAMPTIME1", "SAMPTIME2", "ZRUPT", "BBRUPT", "BRUPT", "CYR", "SR", "CYL",
+	"EDOP", "TIME2", "TIME1", "TIME3", "TIME4", "TIME5", "TIME6", "CDUX",
+	"CDUY", "CDUZ", "OPTY", "OPTX", "PIPAX", "PIPAY", "PIPAZ", "RHCP", "RHCY",
+	"RHCR", "INLINK", "RNRAD", "GYROCTR", "CDUXCMD", "CDUYCMD", "CDUZCMD",
+	"OPTYCMD", "OPTXCMD", "THRUST", "LEMONM", "OUTLINK", "ALTM",
+}
+
+func (r register) String() string {
+	if r >= 0 && int(r) < len(registerNames) {
+		return registerNames[r]
+	}
+	return fmt.Sprintf("%04o", int(r))
+}
+
 type registers [061]uint16
 
 func (reg *registers) Set(r register, val uint16) {
@@ -96,6 +136,15 @@ func (reg *registers) Increment(r register) (overflow bool) {
 type redirectedMemory struct {
 	reg *registers
 	mm  *memory.Main
+	// chans, when set, holds the super-bit in channel 7
+	chans *channels
+
+	// onWrite, when set, is told the previous
+	// value of every memory location written
+	onWrite func(address int, old uint16)
+	// onAccess, when set, is told of every read and
+	// write of memory by the program
+	onAccess func(address int, write bool)
 }
 
 func newRedirectedMemory(r *registers, mm *memory.Main) *redirectedMemory {
@@ -106,16 +155,49 @@ func newRedirectedMemory(r *registers, mm *memory.Main) *redirectedMemory {
 }
 
 func (rm *redirectedMemory) Read(address int) (uint16, error) {
+	if rm.onAccess != nil {
+		rm.onAccess(address, false)
+	}
+	return rm.peek(address)
+}
+
+// peek reads memory without it counting as an access by the program,
+// for fetching instructions and for the debugger.
+func (rm *redirectedMemory) peek(address int) (uint16, error) {
 	if address < len(rm.reg) {
 		return rm.reg[address], nil
 	}
+	rm.selectBanks()
 	return rm.mm.Read(address)
 }
 
 func (rm *redirectedMemory) Write(address int, val uint16) error {
+	if rm.onAccess != nil {
+		rm.onAccess(address, true)
+	}
 	if address < len(rm.reg) {
 		rm.reg.Set(register(address), val)
 		return nil
 	}
-	return rm.mm.Write(address, val)
+	rm.selectBanks()
+	if rm.onWrite == nil {
+		return rm.mm.Write(address, val)
+	}
+
+	old, err := rm.mm.Read(address)
+	if err != nil {
+		return err
+	}
+	if err := rm.mm.Write(address, val); err != nil {
+		return err
+	}
+	rm.onWrite(address, old)
+	return nil
+}
+
+// selectBanks switches main memory to the banks chosen by the EB and FB
+// registers and the super-bit, which the program can change at any time.
+func (rm *redirectedMemory) selectBanks() {
+	superBit := rm.chans != nil && rm.chans[chanSUPERBNK]&0100 != 0
+	rm.mm.SelectBanks(int(rm.reg[regEB]>>8&07), int(rm.reg[regFB]>>10), superBit)
 }
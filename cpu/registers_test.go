@@ -1,6 +1,9 @@
 package cpu
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
 	"math"
 	"testing"
 
@@ -177,3 +180,48 @@ func TestRedirectedMemory_WriteMemory(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, uint16(0xABC), val)
 }
+
+func TestRedirectedMemory_SwitchedBanks(t *testing.T) {
+	var (
+		reg registers
+		mm  memory.Main
+	)
+	rm := newRedirectedMemory(&reg, &mm)
+
+	reg.Set(regEB, 05<<8)
+	err := rm.Write(01412, 0123)
+	assert.NoError(t, err)
+
+	reg.Set(regEB, 0)
+	val, err := rm.Read(01412)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), val, "E0")
+
+	reg.Set(regEB, 05<<8)
+	val, err = rm.Read(01412)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0123), val, "E5")
+}
+
+func TestRedirectedMemory_SuperBit(t *testing.T) {
+	var (
+		reg   registers
+		chans channels
+		mm    memory.Main
+	)
+	rm := newRedirectedMemory(&reg, &mm)
+	rm.chans = &chans
+	var image [044 * 02000]uint16
+	image[043*02000+012] = 0123
+	buf := new(bytes.Buffer)
+	assert.NoError(t, binary.Write(buf, binary.BigEndian, image[:]))
+	_, err := io.Copy(&memory.Loader{MM: &mm}, buf)
+	assert.NoError(t, err)
+
+	reg.Set(regFB, 033<<10)
+	chans[chanSUPERBNK] = 0100
+	val, err := rm.Read(02012)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0123), val, "bank 043")
+}
@@ -0,0 +1,115 @@
+package cpu
+
+// The remote debugger protocol is line based. A client sends the same
+// commands the console debugger accepts, one per line, plus:
+//
+//	attach    make this client the one told about the CPU stopping (done
+//	          automatically when a client connects, so the first thing a
+//	          client receives is the reply to it, preceded by *stopped if
+//	          the CPU is already stopped)
+//	detach    stop being told about the CPU and let it run freely
+//
+// Commands which don't move the CPU can be sent while it is running, which
+// includes pause to stop it before its next instruction.
+//
+// Every line the server sends starts with a character identifying it:
+//
+//	~<text>                output from a command, one line at a time
+//	^ok                    the command completed
+//	^error <message>       the command failed
+//	*stopped <fields>      the CPU stopped, see below
+//	*running               the CPU has resumed
+//
+// Command replies are always terminated by ^ok or ^error, while lines starting
+// with '*' are asynchronous and may arrive at any time between replies. The
+// fields of *stopped are space separated key=value pairs: reason (breakpoint,
+// step, pause or catch), z and pa (the CPU and psudo-address of the next instruction
+// in octal), then either instr (its mnemonic) and operand (in octal) or, when
+// a catchpoint stopped the CPU on something other than an instruction, event
+// (rupt, resume, timer, counter or gojam) and name (of the interrupt, timer or
+// counter, or the cause of the GOJAM), and finally, when symbols are loaded,
+// symbol and line.
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Serve accepts remote debugger clients from l, handling one client at a
+// time. It returns when l fails to accept a connection.
+func (d *DebugEngine) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		d.serveConn(conn)
+	}
+}
+
+func (d *DebugEngine) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	fe := &remoteFrontend{w: bufio.NewWriter(conn)}
+	d.submit("attach", fe)
+
+	r := bufio.NewScanner(conn)
+	for r.Scan() {
+		d.submit(r.Text(), fe)
+	}
+	d.submit("detach", fe)
+}
+
+type remoteFrontend struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (fe *remoteFrontend) send(lines ...string) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	for _, l := range lines {
+		fe.w.WriteString(l)
+		fe.w.WriteByte('\n')
+	}
+	// errors show up as the connection closing on the reading side
+	fe.w.Flush()
+}
+
+func (fe *remoteFrontend) stopped(s stopEvent) {
+	msg := fmt.Sprintf("*stopped reason=%s z=%04o pa=%05o", s.reason, s.z, s.pa)
+	if s.event != "" {
+		msg += fmt.Sprintf(" event=%s name=%s", s.event, s.name)
+	} else {
+		msg += fmt.Sprintf(" instr=%s operand=%05o", s.instr, s.address)
+	}
+	if s.symbol != "" {
+		msg += " symbol=" + s.symbol
+	}
+	if s.line != "" {
+		msg += " line=" + s.line
+	}
+	fe.send(msg)
+}
+
+func (fe *remoteFrontend) resumed() {
+	fe.send("*running")
+}
+
+func (fe *remoteFrontend) reply(output string, err error) {
+	var lines []string
+	if output != "" {
+		for _, l := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			lines = append(lines, "~"+l)
+		}
+	}
+	if err != nil {
+		lines = append(lines, "^error "+err.Error())
+	} else {
+		lines = append(lines, "^ok")
+	}
+	fe.send(lines...)
+}
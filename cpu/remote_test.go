@@ -0,0 +1,120 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type remoteClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Scanner
+}
+
+func (rc *remoteClient) send(cmd string) {
+	_, err := fmt.Fprintln(rc.conn, cmd)
+	require.NoError(rc.t, err)
+}
+
+func (rc *remoteClient) next() string {
+	rc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if !rc.r.Scan() {
+		rc.t.Fatalf("connection ended: %v", rc.r.Err())
+	}
+	return rc.r.Text()
+}
+
+// expect reads lines until one starting with prefix is found, returning
+// it along with any command output (~ lines) read along the way.
+func (rc *remoteClient) expect(prefix string) (string, []string) {
+	var output []string
+	rc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for rc.r.Scan() {
+		l := rc.r.Text()
+		if strings.HasPrefix(l, prefix) {
+			return l, output
+		}
+		if strings.HasPrefix(l, "~") {
+			output = append(output, l[1:])
+		}
+	}
+	rc.t.Fatalf("connection ended waiting for %q: %v", prefix, rc.r.Err())
+	return "", nil
+}
+
+func TestRemoteDebugger(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	engine := NewDebugEngine()
+	c.Debugger = engine
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go engine.Serve(l)
+
+	done := make(chan struct{})
+	go func() {
+		c.Run()
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	rc := &remoteClient{t: t, conn: conn, r: bufio.NewScanner(conn)}
+
+	// act & assert
+	// the reply to attaching comes before the CPU stops
+	// if it hadn't reached the first instruction yet
+	var stop string
+	for _, l := range []string{rc.next(), rc.next()} {
+		if strings.HasPrefix(l, "*stopped") {
+			stop = l
+		} else {
+			assert.Equal(t, "^ok", l)
+		}
+	}
+	assert.Contains(t, stop, "reason=breakpoint")
+	assert.Contains(t, stop, "z=4000")
+
+	rc.send("break 4003")
+	rc.expect("^ok")
+	rc.send("run")
+	rc.expect("*running")
+	rc.expect("^ok")
+	stop, _ = rc.expect("*stopped")
+	assert.Contains(t, stop, "z=4003")
+	assert.Contains(t, stop, "instr=WRITE")
+
+	rc.send("print 100")
+	_, output := rc.expect("^ok")
+	assert.Equal(t, []string{"00100 (00100) = 00005"}, output)
+
+	rc.send("clear")
+	rc.expect("^ok")
+	rc.send("run")
+	rc.expect("^ok")
+	rc.send("pause")
+	rc.expect("^ok")
+	stop, _ = rc.expect("*stopped")
+	assert.Contains(t, stop, "reason=pause")
+
+	rc.send("bogus")
+	line, _ := rc.expect("^error")
+	assert.Contains(t, line, "unrecognized command")
+
+	rc.send("quit")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CPU did not stop running")
+	}
+}
@@ -0,0 +1,211 @@
+package cpu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// histogramBuckets is enough to cover anything up to 2^22 MCTs, about 49s.
+const histogramBuckets = 24
+
+// Histogram is a distribution of times in MCTs.
+type Histogram struct {
+	Count, Total, Min, Max uint64
+	// Buckets counts the times by their power of two, so Buckets[0] holds
+	// times of zero and Buckets[i] times from 2^(i-1) up to 2^i (with the
+	// last bucket holding everything longer).
+	Buckets [histogramBuckets]uint64
+}
+
+func (h *Histogram) add(v uint64) {
+	if h.Count == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Count++
+	h.Total += v
+
+	b := 0
+	for n := v; n > 0 && b < histogramBuckets-1; n >>= 1 {
+		b++
+	}
+	h.Buckets[b]++
+}
+
+// Mean returns the average time.
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Total) / float64(h.Count)
+}
+
+// InterruptStats holds the statistics for one interrupt.
+type InterruptStats struct {
+	// Latency is the time from the interrupt being requested to its service
+	// routine being entered.
+	Latency Histogram
+	// Duration is the time from the service routine being entered to the
+	// end of its RESUME.
+	Duration Histogram
+}
+
+// Stats gathers statistics about how the CPU spends its time: the latency
+// and duration of each interrupt, and the share of time spent with interrupts
+// inhibited, idling, working and incrementing counters.
+//
+// The statistics must not be read while the CPU they are attached to is running.
+type Stats struct {
+	// Idle lists the psudo-addresses of the program's idle loop. Instructions
+	// which jump to themselves are always counted as idling.
+	Idle []AddressRange
+
+	// Cycles is the time the statistics cover, which is split between
+	// IdleCycles, WorkCycles and CounterCycles.
+	Cycles        uint64
+	IdleCycles    uint64
+	WorkCycles    uint64
+	CounterCycles uint64
+	// InhibitedCycles is the time spent with interrupts inhibited by INHINT.
+	InhibitedCycles uint64
+
+	// Interrupts holds the statistics for each interrupt by name.
+	Interrupts map[string]*InterruptStats
+
+	requested [interruptCount]uint64
+	pending   uint16
+	entered   uint64
+}
+
+// NewStats creates an empty Stats.
+func NewStats() *Stats {
+	s := &Stats{Interrupts: make(map[string]*InterruptStats)}
+	for i := interrupt(0); i < interruptCount; i++ {
+		s.Interrupts[i.String()] = new(InterruptStats)
+	}
+	return s
+}
+
+// request records an interrupt being requested, unless it already was.
+func (s *Stats) request(i interrupt, cycles uint64) {
+	if s == nil || s.pending&(1<<uint(i)) != 0 {
+		return
+	}
+	s.pending |= 1 << uint(i)
+	s.requested[i] = cycles
+}
+
+func (s *Stats) enter(i interrupt, cycles uint64) {
+	if s == nil {
+		return
+	}
+	if s.pending&(1<<uint(i)) != 0 {
+		s.Interrupts[i.String()].Latency.add(cycles - s.requested[i])
+		s.pending &^= 1 << uint(i)
+	}
+	s.entered = cycles
+}
+
+func (s *Stats) resume(i interrupt, cycles uint64) {
+	if s == nil {
+		return
+	}
+	s.Interrupts[i.String()].Duration.add(cycles - s.entered)
+}
+
+// gojam forgets the interrupts a GOJAM cancelled.
+func (s *Stats) gojam() {
+	if s == nil {
+		return
+	}
+	s.pending = 0
+}
+
+// instruction accounts for the time taken by an instruction at pa, which
+// looped if it jumped back to itself.
+func (s *Stats) instruction(pa uint16, looped bool, cycles int, inhibited bool) {
+	if s == nil {
+		return
+	}
+	idle := looped
+	for _, r := range s.Idle {
+		idle = idle || r.contains(pa)
+	}
+	if idle {
+		s.IdleCycles += uint64(cycles)
+	} else {
+		s.WorkCycles += uint64(cycles)
+	}
+	s.account(cycles, inhibited)
+}
+
+// sequence accounts for the time taken by an unprogrammed sequence.
+func (s *Stats) sequence(cycles int, inhibited bool) {
+	if s == nil {
+		return
+	}
+	s.CounterCycles += uint64(cycles)
+	s.account(cycles, inhibited)
+}
+
+func (s *Stats) account(cycles int, inhibited bool) {
+	s.Cycles += uint64(cycles)
+	if inhibited {
+		s.InhibitedCycles += uint64(cycles)
+	}
+}
+
+// WriteText writes a summary of the statistics, with a histogram for every
+// interrupt which happened.
+func (s *Stats) WriteText(w io.Writer) error {
+	percent := func(n uint64) float64 {
+		if s.Cycles == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(s.Cycles)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "time:       %d MCTs (%.3fs)\n", s.Cycles, float64(s.Cycles)*mctMicroseconds/1e6)
+	fmt.Fprintf(&b, "idle:       %5.1f%%\n", percent(s.IdleCycles))
+	fmt.Fprintf(&b, "work:       %5.1f%%\n", percent(s.WorkCycles))
+	fmt.Fprintf(&b, "counters:   %5.1f%%\n", percent(s.CounterCycles))
+	fmt.Fprintf(&b, "inhibited:  %5.1f%%\n", percent(s.InhibitedCycles))
+
+	for i := interrupt(0); i < interruptCount; i++ {
+		is := s.Interrupts[i.String()]
+		if is.Latency.Count == 0 && is.Duration.Count == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s\n", i)
+		writeHistogram(&b, "latency", &is.Latency)
+		writeHistogram(&b, "duration", &is.Duration)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHistogram(b *strings.Builder, name string, h *Histogram) {
+	fmt.Fprintf(b, "  %-8s  count %d  min %d  mean %.1f  max %d MCTs\n", name, h.Count, h.Min, h.Mean(), h.Max)
+	for i, n := range h.Buckets {
+		if n == 0 {
+			continue
+		}
+		var from, to uint64
+		if i > 0 {
+			from, to = 1<<uint(i-1), 1<<uint(i)
+		}
+		bar := strings.Repeat("#", int((40*n+h.Count-1)/h.Count))
+		if i == histogramBuckets-1 {
+			fmt.Fprintf(b, "    %7d+       %8d %s\n", from, n, bar)
+		} else if i == 0 {
+			fmt.Fprintf(b, "    %7d        %8d %s\n", 0, n, bar)
+		} else {
+			fmt.Fprintf(b, "    %7d-%-7d%8d %s\n", from, to-1, n, bar)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package cpu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsInterrupts(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	c.Stats = NewStats()
+	c.reg.Set(regTIME4, 077777)
+
+	// act
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+
+	// assert
+	s := c.Stats
+	t4 := s.Interrupts["T4RUPT"]
+	assert.Equal(t, uint64(1), t4.Latency.Count)
+	// the request comes from the PINC of TIME4, which takes 1 MCT
+	assert.Equal(t, uint64(1), t4.Latency.Max)
+	assert.Equal(t, uint64(1), t4.Duration.Count)
+	// RESUME takes 2 MCTs
+	assert.Equal(t, uint64(2), t4.Duration.Total)
+	assert.Zero(t, s.Interrupts["T3RUPT"].Duration.Count)
+
+	assert.Equal(t, uint64(2), s.WorkCycles, "only RESUME is work")
+	assert.NotZero(t, s.IdleCycles)
+	assert.NotZero(t, s.CounterCycles)
+	assert.Equal(t, s.Cycles, s.IdleCycles+s.WorkCycles+s.CounterCycles)
+	assert.Zero(t, s.InhibitedCycles)
+}
+
+func TestStatsReplay(t *testing.T) {
+	stats := func(d Debugger) *Stats {
+		c := newTestCPU(t, ruptProgram...)
+		c.RecordHistory(10)
+		c.Debugger = d
+		c.Stats = NewStats()
+		c.reg.Set(regTIME4, 077777)
+		for c.cycles < 2000 {
+			c.step()
+		}
+		return c.Stats
+	}
+
+	// arrange
+	want := stats(new(noDebugger))
+
+	// act
+	got := stats(&rewindingDebugger{pa: 04020})
+
+	// assert
+	assert.Equal(t, want, got)
+}
+
+func TestStatsIdleRanges(t *testing.T) {
+	// arrange
+	c := newTestCPU(t,
+		030100, // 04000 CA  0100
+		014000, // 04001 TCF 04000
+	)
+	c.Stats = NewStats()
+	c.Stats.Idle = []AddressRange{{Start: 04000, End: 04001}}
+
+	// act
+	for i := 0; i < 4; i++ {
+		c.step()
+	}
+
+	// assert
+	assert.Equal(t, uint64(6), c.Stats.IdleCycles)
+	assert.Zero(t, c.Stats.WorkCycles)
+}
+
+func TestHistogram(t *testing.T) {
+	// arrange
+	var h Histogram
+
+	// act
+	for _, v := range []uint64{0, 1, 3, 4, 7, 1 << 30} {
+		h.add(v)
+	}
+
+	// assert
+	assert.Equal(t, uint64(6), h.Count)
+	assert.Equal(t, uint64(0), h.Min)
+	assert.Equal(t, uint64(1<<30), h.Max)
+	assert.Equal(t, uint64(1), h.Buckets[0])
+	assert.Equal(t, uint64(1), h.Buckets[1])
+	assert.Equal(t, uint64(1), h.Buckets[2])
+	assert.Equal(t, uint64(2), h.Buckets[3])
+	assert.Equal(t, uint64(1), h.Buckets[histogramBuckets-1])
+}
+
+func TestStatsWriteText(t *testing.T) {
+	// arrange
+	s := NewStats()
+	s.Cycles, s.IdleCycles, s.WorkCycles = 4, 3, 1
+	s.Interrupts["T5RUPT"].Duration.add(5)
+	s.Interrupts["T5RUPT"].Latency.add(0)
+	out := new(bytes.Buffer)
+
+	// act
+	err := s.WriteText(out)
+
+	// assert
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "idle:        75.0%\n")
+	assert.Contains(t, out.String(), "  duration  count 1  min 5  mean 5.0  max 5 MCTs\n")
+	assert.Contains(t, out.String(), "          4-7             1 ########################################\n")
+	assert.NotContains(t, out.String(), "T4RUPT")
+}
@@ -0,0 +1,159 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+)
+
+// mctMicroseconds is the length of a memory cycle.
+const mctMicroseconds = 11.71875
+
+// Timeline writes a trace of what the CPU is doing over time in the JSON
+// trace event format, which can be loaded into chrome://tracing or Perfetto.
+// Each interrupt service routine is a span from its entry to its RESUME, and
+// timers rolling over and bursts of counter increments are instant events.
+// Time is the CPU's virtual clock of memory cycles.
+//
+// Like a VCD, the CPU is sampled before each step and the steps the debugger
+// replays after running it backwards are left out.
+type Timeline struct {
+	// Symbols, if set, adds a span for every call of a subroutine, named
+	// after its label. Otherwise only interrupts have spans.
+	Symbols *symtab.Table
+
+	w       *bufio.Writer
+	started bool
+	now     uint64
+	// open are the frames with spans which haven't ended yet
+	open []frame
+
+	// burst counts the counter increments since the last instruction
+	burst      map[string]int
+	burstStart uint64
+}
+
+type traceEvent struct {
+	Name  string                 `json:"name"`
+	Phase string                 `json:"ph"`
+	Time  float64                `json:"ts"`
+	PID   int                    `json:"pid"`
+	TID   int                    `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// NewTimeline creates a Timeline writing to w.
+func NewTimeline(w io.Writer) *Timeline {
+	return &Timeline{w: bufio.NewWriter(w)}
+}
+
+func (t *Timeline) event(e traceEvent) {
+	if !t.started {
+		t.started = true
+		t.w.WriteString("{\"traceEvents\":[\n")
+		t.write(traceEvent{Name: "thread_name", Phase: "M", Args: map[string]interface{}{"name": "AGC"}})
+	}
+	t.w.WriteString(",\n")
+	t.write(e)
+}
+
+func (t *Timeline) write(e traceEvent) {
+	e.PID, e.TID = 1, 1
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	t.w.Write(b)
+}
+
+func (t *Timeline) at(cycles uint64) float64 {
+	return float64(cycles) * mctMicroseconds
+}
+
+// sample ends and begins the spans for any frames which have been
+// popped or pushed since the last sample.
+func (t *Timeline) sample(c *CPU) {
+	if t == nil {
+		return
+	}
+	t.now = c.cycles
+	if len(c.pendingSequences) == 0 {
+		t.endBurst()
+	}
+
+	var frames []frame
+	for _, f := range c.frames {
+		if f.kind == interruptFrame || t.Symbols != nil {
+			frames = append(frames, f)
+		}
+	}
+	same := 0
+	for same < len(t.open) && same < len(frames) && t.open[same] == frames[same] {
+		same++
+	}
+	for i := len(t.open) - 1; i >= same; i-- {
+		t.event(traceEvent{Name: t.spanName(t.open[i]), Phase: "E", Time: t.at(c.cycles)})
+	}
+	for _, f := range frames[same:] {
+		t.event(traceEvent{Name: t.spanName(f), Phase: "B", Time: t.at(c.cycles)})
+	}
+	t.open = frames
+}
+
+func (t *Timeline) spanName(f frame) string {
+	if f.kind == interruptFrame {
+		return f.rupt.String()
+	}
+	return t.Symbols.Symbolize(f.entry)
+}
+
+// timer records a timer rolling over.
+func (t *Timeline) timer(cycles uint64, name string) {
+	if t == nil {
+		return
+	}
+	t.event(traceEvent{Name: name, Phase: "i", Time: t.at(cycles), Scope: "t"})
+}
+
+// counter records a counter being incremented by an unprogrammed sequence.
+func (t *Timeline) counter(cycles uint64, seq *sequence) {
+	if t == nil {
+		return
+	}
+	if t.burst == nil {
+		t.burst = make(map[string]int)
+		t.burstStart = cycles
+	}
+	t.burst[seq.name]++
+}
+
+// endBurst records the counter increments since the last instruction as one event.
+func (t *Timeline) endBurst() {
+	if t.burst == nil {
+		return
+	}
+	args := make(map[string]interface{}, len(t.burst))
+	for name, n := range t.burst {
+		args[name] = n
+	}
+	t.event(traceEvent{Name: "counters", Phase: "i", Time: t.at(t.burstStart), Scope: "t", Args: args})
+	t.burst = nil
+}
+
+// Close ends the spans which are still open and finishes the trace. It
+// should be called once the CPU has stopped running.
+func (t *Timeline) Close() error {
+	t.endBurst()
+	for i := len(t.open) - 1; i >= 0; i-- {
+		t.event(traceEvent{Name: t.spanName(t.open[i]), Phase: "E", Time: t.at(t.now)})
+	}
+	t.open = nil
+	if !t.started {
+		t.w.WriteString("{\"traceEvents\":[")
+	}
+	t.w.WriteString("\n]}\n")
+	return t.w.Flush()
+}
@@ -0,0 +1,102 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/symtab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTimeline parses the events of a timeline, leaving out the metadata.
+func readTimeline(t *testing.T, b []byte) []traceEvent {
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(b, &trace))
+	require.NotEmpty(t, trace.TraceEvents)
+	return trace.TraceEvents[1:]
+}
+
+func TestTimelineInterrupts(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, ruptProgram...)
+	out := new(bytes.Buffer)
+	c.Timeline = NewTimeline(out)
+	c.reg.Set(regTIME4, 077777)
+
+	// act
+	for i := 0; i < 3000 && !c.inRupt; i++ {
+		c.step()
+	}
+	require.True(t, c.inRupt, "arrange failed")
+	c.step()
+	c.step()
+	require.NoError(t, c.Timeline.Close())
+
+	// assert
+	var got []string
+	var begin, end float64
+	for _, e := range readTimeline(t, out.Bytes()) {
+		if e.Name == "TIME4" || e.Name == "T4RUPT" || e.Name == "counters" && e.Args["PINC TIME4"] != nil {
+			got = append(got, e.Phase+" "+e.Name)
+		}
+		if e.Name == "T4RUPT" && e.Phase == "B" {
+			begin = e.Time
+		}
+		if e.Name == "T4RUPT" && e.Phase == "E" {
+			end = e.Time
+		}
+	}
+	assert.Equal(t, []string{"i TIME4", "i counters", "B T4RUPT", "E T4RUPT"}, got)
+	// RESUME takes 2 MCTs
+	assert.Equal(t, 2*mctMicroseconds, end-begin)
+}
+
+func TestTimelineCalls(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, callProgram...)
+	out := new(bytes.Buffer)
+	c.Timeline = NewTimeline(out)
+	c.Timeline.Symbols = symtab.New()
+	c.Timeline.Symbols.Define("SUB", 04003)
+
+	// act
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+	require.NoError(t, c.Timeline.Close())
+
+	// assert
+	events := readTimeline(t, out.Bytes())
+	require.Len(t, events, 2)
+	assert.Equal(t, traceEvent{Name: "SUB", Phase: "B", Time: mctMicroseconds, PID: 1, TID: 1}, events[0])
+	assert.Equal(t, traceEvent{Name: "SUB", Phase: "E", Time: 2 * mctMicroseconds, PID: 1, TID: 1}, events[1])
+}
+
+func TestTimelineReplay(t *testing.T) {
+	timeline := func(d Debugger) []traceEvent {
+		c := newTestCPU(t, ruptProgram...)
+		c.RecordHistory(10)
+		c.Debugger = d
+		out := new(bytes.Buffer)
+		c.Timeline = NewTimeline(out)
+		c.reg.Set(regTIME4, 077777)
+		for c.cycles < 2000 {
+			c.step()
+		}
+		require.NoError(t, c.Timeline.Close())
+		return readTimeline(t, out.Bytes())
+	}
+
+	// arrange
+	want := timeline(new(noDebugger))
+
+	// act
+	got := timeline(&rewindingDebugger{pa: 04020})
+
+	// assert
+	assert.Equal(t, want, got)
+}
@@ -1,16 +1,20 @@
 package cpu
 
 type timer struct {
-	n string
-	v int
-	i int
+	n   string
+	v   int
+	i   int
+	seq *sequence
 }
 
-func newTimer(name string, interval, offset int) *timer {
+// newTimer creates a timer which queues up the given
+// unprogrammed sequence every time it wraps around.
+func newTimer(name string, interval, offset int, seq *sequence) *timer {
 	return &timer{
-		n: name,
-		v: offset,
-		i: interval,
+		n:   name,
+		v:   offset,
+		i:   interval,
+		seq: seq,
 	}
 }
 
@@ -0,0 +1,27 @@
+package cpu
+
+import (
+	"fmt"
+)
+
+// The execution trace has a line for each instruction giving the state of the
+// CPU before it executes, as space separated key=value pairs in octal (apart
+// from the cycle count, which is decimal). A and Q are 16 bit registers, so
+// they have six digits to keep the columns lined up:
+//
+//	Z=04001 EB=00000 FB=00000 BB=00000 A=000006 L=00000 Q=000000 CYCLES=3
+//
+// These are the registers yaAGC keeps too, so a trace from it can be
+// rewritten into this format and the two compared with tracediff. The tool
+// only compares the keys both lines have, so a reference trace can leave
+// out registers it doesn't record.
+
+// trace writes a line to the trace for the instruction about to execute.
+func (c *CPU) trace() {
+	if c.Trace == nil {
+		return
+	}
+	fmt.Fprintf(c.Trace, "Z=%05o EB=%05o FB=%05o BB=%05o A=%06o L=%05o Q=%06o CYCLES=%d\n",
+		c.reg[regZ], c.reg[regEB], c.reg[regFB], c.reg[regBB],
+		c.reg[regA], c.reg[regL], c.reg[regQ], c.cycles)
+}
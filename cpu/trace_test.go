@@ -0,0 +1,45 @@
+package cpu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	c.Trace = out
+
+	// act
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+
+	// assert
+	lines := strings.Split(out.String(), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "Z=04000 EB=00000 FB=00000 BB=00000 A=000000 L=00000 Q=000000 CYCLES=0", lines[0])
+	assert.Equal(t, "Z=04001 EB=00000 FB=00000 BB=00000 A=000005 L=00000 Q=000000 CYCLES=2", lines[1])
+	assert.Equal(t, "Z=04002 EB=00000 FB=00000 BB=00000 A=000005 L=00000 Q=000000 CYCLES=4", lines[2])
+}
+
+func TestTraceOverflow(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	c.Trace = out
+	c.reg.Set(regA, 0100005)
+	c.reg.Set(regQ, 0140000)
+
+	// act
+	c.step()
+
+	// assert
+	assert.Equal(t, "Z=04000 EB=00000 FB=00000 BB=00000 A=100005 L=00000 Q=140000 CYCLES=0\n", out.String(),
+		"the 16 bit registers keep the same width")
+}
@@ -0,0 +1,218 @@
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// vcdTicksPerMCT is the length of a memory cycle (11.71875µs) in the
+// 10ps ticks of the dump's timescale, so the times are exact.
+const vcdTicksPerMCT = 1171875
+
+// VCD writes a Value Change Dump of chosen I/O channels and counters, along
+// with the interrupt lines, the interrupt inhibit and GOJAM, which can be
+// viewed as waveforms in a viewer like GTKWave. Time is the CPU's virtual
+// clock of memory cycles, so the dump is the same however fast it runs.
+//
+// The CPU is sampled before every instruction and unprogrammed sequence, so
+// a change shows up at the time of the first step after it. The steps the
+// debugger replays after running the CPU backwards are left out.
+type VCD struct {
+	w       *bufio.Writer
+	signals []*vcdSignal
+	started bool
+	// time is the last time written to the dump and now the time of the last sample
+	time, now uint64
+
+	// jamAt is the time of the last GOJAM, for pulsing its line
+	jammed bool
+	jamAt  uint64
+}
+
+type vcdSignal struct {
+	scope string
+	name  string
+	width int
+	id    string
+	value func(c *CPU) uint16
+	last  uint16
+}
+
+// NewVCD creates a VCD writing to w, with the given channels (by number)
+// and counters (by register name or octal address).
+func NewVCD(w io.Writer, channels []uint16, counters []string) (*VCD, error) {
+	v := &VCD{w: bufio.NewWriter(w)}
+
+	for _, ch := range channels {
+		ch := ch
+		if ch >= channelCount {
+			return nil, errors.Errorf("channel %o is out of range", ch)
+		}
+		v.add("channels", fmt.Sprintf("ch%02o", ch), 15, func(c *CPU) uint16 {
+			return c.readChannel(ch) & 077777
+		})
+	}
+
+	for _, name := range counters {
+		r, err := parseRegister(name)
+		if err != nil {
+			return nil, err
+		}
+		v.add("counters", r.String(), 15, func(c *CPU) uint16 {
+			return c.reg[r] & 077777
+		})
+	}
+
+	for i := interrupt(0); i < interruptCount; i++ {
+		i := i
+		v.add("interrupts", i.String()+"_pending", 1, func(c *CPU) uint16 {
+			return c.pendingInts >> uint(i) & 1
+		})
+	}
+	for i := interrupt(0); i < interruptCount; i++ {
+		i := i
+		v.add("interrupts", i.String()+"_active", 1, func(c *CPU) uint16 {
+			if rupt, ok := c.frames.interrupt(); c.inRupt && ok && rupt == i {
+				return 1
+			}
+			return 0
+		})
+	}
+	v.add("interrupts", "intsOff", 1, func(c *CPU) uint16 {
+		if c.intsOff {
+			return 1
+		}
+		return 0
+	})
+	v.add("", "GOJAM", 1, func(c *CPU) uint16 {
+		if v.jammed && v.jamAt == c.cycles {
+			return 1
+		}
+		return 0
+	})
+
+	return v, nil
+}
+
+// parseRegister finds a register by name or octal address.
+func parseRegister(name string) (register, error) {
+	for r := register(0); int(r) < len(registerNames); r++ {
+		if strings.EqualFold(name, r.String()) {
+			return r, nil
+		}
+	}
+	if a, err := strconv.ParseUint(name, 8, 16); err == nil && a < uint64(len(registers{})) {
+		return register(a), nil
+	}
+	return 0, errors.Errorf("unknown register %q", name)
+}
+
+func (v *VCD) add(scope, name string, width int, value func(c *CPU) uint16) {
+	v.signals = append(v.signals, &vcdSignal{
+		scope: scope,
+		name:  name,
+		width: width,
+		id:    vcdID(len(v.signals)),
+		value: value,
+	})
+}
+
+// vcdID makes the short identifier code for the nth signal out of the printable characters.
+func vcdID(n int) string {
+	const first, count = '!', '~' - '!' + 1
+	id := string(rune(first + n%count))
+	for n /= count; n > 0; n /= count {
+		id += string(rune(first + n%count))
+	}
+	return id
+}
+
+// jam records a GOJAM, which pulses its line until the clock next moves on.
+func (v *VCD) jam(cycles uint64) {
+	if v == nil {
+		return
+	}
+	v.jammed, v.jamAt = true, cycles
+}
+
+// sample writes the signals which have changed since the last sample.
+func (v *VCD) sample(c *CPU) {
+	if v == nil {
+		return
+	}
+	if !v.started {
+		v.header(c)
+		return
+	}
+	v.now = c.cycles
+
+	stamped := false
+	for _, s := range v.signals {
+		val := s.value(c)
+		if val == s.last {
+			continue
+		}
+		if !stamped {
+			fmt.Fprintf(v.w, "#%d\n", c.cycles*vcdTicksPerMCT)
+			v.time = c.cycles
+			stamped = true
+		}
+		v.write(s, val)
+	}
+}
+
+func (v *VCD) header(c *CPU) {
+	v.started = true
+	fmt.Fprintln(v.w, "$version go-agc $end")
+	fmt.Fprintln(v.w, "$timescale 10ps $end")
+	fmt.Fprintln(v.w, "$scope module agc $end")
+	scope := ""
+	for _, s := range v.signals {
+		if s.scope != scope {
+			if scope != "" {
+				fmt.Fprintln(v.w, "$upscope $end")
+			}
+			if s.scope != "" {
+				fmt.Fprintf(v.w, "$scope module %s $end\n", s.scope)
+			}
+			scope = s.scope
+		}
+		fmt.Fprintf(v.w, "$var wire %d %s %s $end\n", s.width, s.id, s.name)
+	}
+	if scope != "" {
+		fmt.Fprintln(v.w, "$upscope $end")
+	}
+	fmt.Fprintln(v.w, "$upscope $end")
+	fmt.Fprintln(v.w, "$enddefinitions $end")
+
+	v.time, v.now = c.cycles, c.cycles
+	fmt.Fprintf(v.w, "#%d\n$dumpvars\n", c.cycles*vcdTicksPerMCT)
+	for _, s := range v.signals {
+		v.write(s, s.value(c))
+	}
+	fmt.Fprintln(v.w, "$end")
+}
+
+func (v *VCD) write(s *vcdSignal, val uint16) {
+	s.last = val
+	if s.width == 1 {
+		fmt.Fprintf(v.w, "%d%s\n", val, s.id)
+	} else {
+		fmt.Fprintf(v.w, "b%s %s\n", strconv.FormatUint(uint64(val), 2), s.id)
+	}
+}
+
+// Flush writes out anything buffered, ending the dump at the time of the
+// last sample. It should be called once the CPU has stopped running.
+func (v *VCD) Flush() error {
+	if v.now > v.time {
+		fmt.Fprintf(v.w, "#%d\n", v.now*vcdTicksPerMCT)
+		v.time = v.now
+	}
+	return v.w.Flush()
+}
@@ -0,0 +1,73 @@
+package cpu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVCD(t *testing.T) {
+	// arrange
+	c := newTestCPU(t, historyProgram...)
+	out := new(bytes.Buffer)
+	vcd, err := NewVCD(out, []uint16{010}, []string{"TIME1"})
+	require.NoError(t, err)
+	c.VCD = vcd
+
+	// act
+	for i := 0; i < 5; i++ {
+		c.step()
+	}
+	c.jam = "test"
+	for i := 0; i < 3; i++ {
+		c.step()
+	}
+	require.NoError(t, vcd.Flush())
+
+	// assert
+	dump := out.String()
+	assert.Contains(t, dump, "$var wire 15 ! ch10 $end\n")
+	assert.Contains(t, dump, "$var wire 15 \" TIME1 $end\n")
+	assert.Contains(t, dump, "$var wire 1 : GOJAM $end\n")
+	assert.Contains(t, dump, "$enddefinitions $end\n#0\n$dumpvars\nb0 !\n")
+	// WRITE 010 finishes after 7 MCTs
+	assert.Contains(t, dump, "#8203125\nb101 !\n")
+	// the GOJAM clears the channel and pulses its line until the next instruction
+	assert.Contains(t, dump, "#9375000\nb0 !\n1:\n#11718750\n0:\n")
+}
+
+func TestVCDReplay(t *testing.T) {
+	dump := func(d Debugger, steps int) string {
+		c := newTestCPU(t, historyProgram...)
+		c.RecordHistory(10)
+		c.Debugger = d
+		out := new(bytes.Buffer)
+		vcd, err := NewVCD(out, []uint16{010}, nil)
+		require.NoError(t, err)
+		c.VCD = vcd
+		for i := 0; i < steps; i++ {
+			c.step()
+		}
+		require.NoError(t, vcd.Flush())
+		return out.String()
+	}
+
+	// arrange
+	want := dump(new(noDebugger), 6)
+
+	// act
+	got := dump(&rewindingDebugger{pa: 04004}, 5+6)
+
+	// assert
+	assert.Equal(t, want, got)
+}
+
+func TestNewVCDBadOptions(t *testing.T) {
+	_, err := NewVCD(new(bytes.Buffer), []uint16{01000}, nil)
+	assert.Error(t, err, "channel")
+
+	_, err = NewVCD(new(bytes.Buffer), nil, []string{"TIME9"})
+	assert.Error(t, err, "counter")
+}
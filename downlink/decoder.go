@@ -0,0 +1,207 @@
+package downlink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// List defines one of the program's downlink lists.
+type List struct {
+	// ID is the first word of the list, which identifies it.
+	ID uint16
+	// Type names the list, such as "CM powered list".
+	Type string
+	// Fields are the quantities in the list in the order they are sent,
+	// starting with the ID word itself.
+	Fields []Field
+}
+
+// Field is a quantity in a downlink list.
+type Field struct {
+	Name string `json:"name"`
+	// Words is 1 for a single precision quantity (the default) or 2
+	// for a double precision one.
+	Words int `json:"words"`
+	// Scale, if set, is what a fraction of 1 is worth, and the field is
+	// shown as a number in Unit instead of as octal words.
+	Scale float64 `json:"scale"`
+	Unit  string  `json:"unit"`
+}
+
+// size is the number of words the field takes.
+func (f Field) size() int {
+	if f.Words == 0 {
+		return 1
+	}
+	return f.Words
+}
+
+func (l *List) length() int {
+	n := 0
+	for _, f := range l.Fields {
+		n += f.size()
+	}
+	return n
+}
+
+// ReadLists reads downlink list definitions, which are a JSON array of
+// lists with their IDs in octal, such as:
+//
+//	[{"id": "77776", "type": "CM powered list", "fields": [
+//		{"name": "ID"}, {"name": "SYNC"},
+//		{"name": "RN", "words": 2, "scale": 67108864, "unit": "m"}
+//	]}]
+func ReadLists(r io.Reader) ([]*List, error) {
+	var defs []struct {
+		ID     string  `json:"id"`
+		Type   string  `json:"type"`
+		Fields []Field `json:"fields"`
+	}
+	if err := json.NewDecoder(r).Decode(&defs); err != nil {
+		return nil, errors.Wrap(err, "failed to read downlink lists")
+	}
+	var lists []*List
+	for _, def := range defs {
+		id, err := strconv.ParseUint(def.ID, 8, 15)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad ID for downlink list %q", def.Type)
+		}
+		l := &List{ID: uint16(id), Type: def.Type, Fields: def.Fields}
+		lists = append(lists, l)
+		if len(l.Fields) == 0 {
+			return nil, errors.Errorf("downlink list %05o has no fields", l.ID)
+		}
+		for _, f := range l.Fields {
+			if f.size() != 1 && f.size() != 2 {
+				return nil, errors.Errorf("field %s of downlink list %05o has %d words", f.Name, l.ID, f.Words)
+			}
+		}
+	}
+	return lists, nil
+}
+
+// Snapshot is a downlink list as the program sent it.
+type Snapshot struct {
+	// Cycles is when the first pair of the list was read.
+	Cycles uint64
+	List   *List
+	// Values holds the words of each of the list's fields.
+	Values [][]uint16
+}
+
+// WriteText writes the list's type and then each field on its own line.
+func (s *Snapshot) WriteText(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%d %s (%05o)\n", s.Cycles, s.List.Type, s.List.ID)
+	width := 0
+	for _, f := range s.List.Fields {
+		if len(f.Name) > width {
+			width = len(f.Name)
+		}
+	}
+	for i, f := range s.List.Fields {
+		fmt.Fprintf(b, "  %-*s %s\n", width, f.Name, formatValue(f, s.Values[i]))
+	}
+	return b.Flush()
+}
+
+func formatValue(f Field, words []uint16) string {
+	if f.Scale == 0 {
+		octal := make([]string, len(words))
+		for i, w := range words {
+			octal[i] = fmt.Sprintf("%05o", w)
+		}
+		return strings.Join(octal, " ")
+	}
+
+	// the words are ones' complement fractions, with the second
+	// of a double precision quantity worth 2^-14 of the first
+	v := 0.0
+	for _, w := range words {
+		v = v*(1<<14) + float64(signed(w))
+	}
+	v /= float64(int(1) << (14 * uint(len(words))))
+	s := fmt.Sprintf("%g", v*f.Scale)
+	if f.Unit != "" {
+		s += " " + f.Unit
+	}
+	return s
+}
+
+// signed converts a 15 bit ones' complement word to an integer.
+func signed(w uint16) int {
+	if w&040000 != 0 {
+		return -int(^w & 037777)
+	}
+	return int(w)
+}
+
+// Decoder puts the pairs the program sends back together into lists.
+type Decoder struct {
+	lists map[uint16]*List
+	// list is the list being collected, if any
+	list  *List
+	start uint64
+	words []uint16
+}
+
+// NewDecoder creates a Decoder for the given lists.
+func NewDecoder(lists []*List) (*Decoder, error) {
+	d := &Decoder{lists: make(map[uint16]*List)}
+	for _, l := range lists {
+		if _, ok := d.lists[l.ID]; ok {
+			return nil, errors.Errorf("there are two downlink lists with the ID %05o", l.ID)
+		}
+		d.lists[l.ID] = l
+	}
+	return d, nil
+}
+
+// Add adds the next pair, returning the list it completes if it does.
+// Pairs of lists which aren't defined are ignored, as are lists cut short
+// by the start of another one.
+func (d *Decoder) Add(p Pair) *Snapshot {
+	if p.First {
+		d.list, d.start, d.words = d.lists[p.Words[0]], p.Cycles, d.words[:0]
+	}
+	if d.list == nil {
+		return nil
+	}
+	d.words = append(d.words, p.Words[:]...)
+	if len(d.words) < d.list.length() {
+		return nil
+	}
+
+	s := &Snapshot{Cycles: d.start, List: d.list}
+	words := d.words
+	for _, f := range d.list.Fields {
+		s.Values = append(s.Values, append([]uint16(nil), words[:f.size()]...))
+		words = words[f.size():]
+	}
+	d.list = nil
+	return s
+}
+
+// ReadCapture reads the pairs written to a Downlink's Capture.
+func ReadCapture(r io.Reader) ([]Pair, error) {
+	var pairs []Pair
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		var (
+			p     Pair
+			order int
+		)
+		if _, err := fmt.Sscanf(s.Text(), "%d %d %o %o", &p.Cycles, &order, &p.Words[0], &p.Words[1]); err != nil {
+			return nil, errors.Wrapf(err, "bad downlink pair on line %d", line)
+		}
+		p.First = order == 0
+		pairs = append(pairs, p)
+	}
+	return pairs, errors.Wrap(s.Err(), "failed to read downlink capture")
+}
@@ -0,0 +1,122 @@
+package downlink
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLists = `[
+	{"id": "1776", "type": "powered list", "fields": [
+		{"name": "ID"}, {"name": "SYNC"},
+		{"name": "RN", "words": 2, "scale": 2, "unit": "m"},
+		{"name": "TIME", "words": 2}
+	]},
+	{"id": "1777", "type": "coast list", "fields": [{"name": "ID"}, {"name": "SYNC"}, {"name": "FLAGS"}]}
+]`
+
+func TestReadLists(t *testing.T) {
+	// act
+	lists, err := ReadLists(strings.NewReader(testLists))
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, lists, 2)
+	assert.Equal(t, "powered list", lists[0].Type)
+	assert.Equal(t, 1, lists[0].Fields[0].size(), "words default to 1")
+	assert.Equal(t, 6, lists[0].length())
+}
+
+func TestReadListsBadWords(t *testing.T) {
+	// act
+	_, err := ReadLists(strings.NewReader(`[{"id": "1", "fields": [{"name": "X", "words": 3}]}]`))
+
+	// assert
+	assert.EqualError(t, err, "field X of downlink list 00001 has 3 words")
+}
+
+func TestReadListsBadID(t *testing.T) {
+	// act
+	_, err := ReadLists(strings.NewReader(`[{"id": "9", "type": "X", "fields": [{"name": "ID"}]}]`))
+
+	// assert
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `bad ID for downlink list "X"`)
+}
+
+func TestNewDecoderDuplicateID(t *testing.T) {
+	// act
+	_, err := NewDecoder([]*List{{ID: 1}, {ID: 1}})
+
+	// assert
+	assert.EqualError(t, err, "there are two downlink lists with the ID 00001")
+}
+
+func TestDecoder(t *testing.T) {
+	// arrange
+	lists, err := ReadLists(strings.NewReader(testLists))
+	require.NoError(t, err)
+	d, err := NewDecoder(lists)
+	require.NoError(t, err)
+
+	// act
+	var got []*Snapshot
+	for _, p := range []Pair{
+		{Cycles: 10, First: false, Words: [2]uint16{1, 2}},    // before any list
+		{Cycles: 20, First: true, Words: [2]uint16{01777, 5}}, // cut short
+		{Cycles: 30, First: true, Words: [2]uint16{01776, 077340}},
+		{Cycles: 40, Words: [2]uint16{020000, 020000}}, // RN = 0.5 + 0.5 * 2^-14
+		{Cycles: 50, Words: [2]uint16{1, 2}},
+		{Cycles: 60, Words: [2]uint16{3, 4}}, // after the list
+	} {
+		if s := d.Add(p); s != nil {
+			got = append(got, s)
+		}
+	}
+
+	// assert
+	require.Len(t, got, 1)
+	s := got[0]
+	assert.Equal(t, uint64(30), s.Cycles)
+	assert.Equal(t, "powered list", s.List.Type)
+	assert.Equal(t, [][]uint16{{01776}, {077340}, {020000, 020000}, {1, 2}}, s.Values)
+	out := new(bytes.Buffer)
+	require.NoError(t, s.WriteText(out))
+	assert.Equal(t, "30 powered list (01776)\n"+
+		"  ID   01776\n"+
+		"  SYNC 77340\n"+
+		"  RN   1.00006103515625 m\n"+
+		"  TIME 00001 00002\n", out.String())
+}
+
+func TestFormatNegative(t *testing.T) {
+	// act
+	v := formatValue(Field{Scale: 1}, []uint16{077776, 077777})
+
+	// assert
+	assert.Equal(t, "-6.103515625e-05", v)
+}
+
+func TestReadCapture(t *testing.T) {
+	// act
+	pairs, err := ReadCapture(strings.NewReader("1706 0 00001 77340\n3412 1 12345 54321\n"))
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, []Pair{
+		{Cycles: 1706, First: true, Words: [2]uint16{1, 077340}},
+		{Cycles: 3412, Words: [2]uint16{012345, 054321}},
+	}, pairs)
+}
+
+func TestReadCaptureBadLine(t *testing.T) {
+	// act
+	_, err := ReadCapture(strings.NewReader("1706 0 00001 77340\nnope\n"))
+
+	// assert
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bad downlink pair on line 2")
+}
@@ -0,0 +1,126 @@
+// Package downlink collects the telemetry the AGC sends to the ground.
+//
+// The telemetry system reads a pair of words from channels 34 and 35 every
+// 20ms and then raises DOWNRUPT, so the program can put the next pair there
+// in time. The program sends one of its downlink lists at a time, starting
+// each with its ID word, and clears the word order code (bit 7 of channel
+// 13) while it sends the first pair of a list so that the ground can find
+// where lists start.
+package downlink
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/pkg/errors"
+)
+
+// interval is the time in memory cycles between DOWNRUPTs, which is two
+// ticks of the CPU's 10ms timers.
+const interval = 2 * cpu.CyclesPer10ms
+
+const (
+	chanDNTM1 = 034
+	chanDNTM2 = 035
+	// chanWordOrder holds the word order code bit.
+	chanWordOrder = 013
+	wordOrderBit  = 0100
+)
+
+// Pair is a pair of words the program sent.
+type Pair struct {
+	// Cycles is when the telemetry system read the words.
+	Cycles uint64
+	// First is set when the word order code marked this as the first pair
+	// of a list.
+	First bool
+	// Words are the words from channels 34 and 35.
+	Words [2]uint16
+}
+
+// String formats a pair as it is written to a capture: the time, 0 if the
+// pair is the first of a list or 1 if it isn't, and the words in octal.
+func (p Pair) String() string {
+	order := 1
+	if p.First {
+		order = 0
+	}
+	return fmt.Sprintf("%d %d %05o %05o", p.Cycles, order, p.Words[0], p.Words[1])
+}
+
+// Downlink is a cpu.Device which raises DOWNRUPT every 20ms and collects
+// the word pairs the program sends.
+type Downlink struct {
+	// Capture, if set, receives every pair, one per line.
+	Capture io.Writer
+	// Decoder, if set, decodes the pairs into lists.
+	Decoder *Decoder
+	// Lists, if set, receives the lists the decoder completes.
+	Lists io.Writer
+
+	next uint64
+	// written says whether the program has written the channels since
+	// the last pair was read.
+	written bool
+	// err is the first error writing the capture or lists, after which
+	// nothing more is written.
+	err error
+}
+
+// New creates a Downlink which raises the first DOWNRUPT 20ms after
+// the CPU starts.
+func New() *Downlink {
+	return &Downlink{next: interval}
+}
+
+// ChannelWritten implements cpu.Device.
+func (d *Downlink) ChannelWritten(ch, val uint16) {
+	if ch == chanDNTM1 || ch == chanDNTM2 {
+		d.written = true
+	}
+}
+
+// Step implements cpu.Device, reading a pair and raising DOWNRUPT every 20ms.
+func (d *Downlink) Step(io cpu.DeviceIO) {
+	now := io.Cycles()
+	if now < d.next {
+		return
+	}
+	d.next += interval
+
+	if d.written {
+		d.written = false
+		d.add(Pair{
+			Cycles: now,
+			First:  io.Channel(chanWordOrder)&wordOrderBit == 0,
+			Words:  [2]uint16{io.Channel(chanDNTM1), io.Channel(chanDNTM2)},
+		})
+	}
+	io.Interrupt("DOWNRUPT")
+}
+
+// Err returns the first error writing the capture or lists, if there was one.
+func (d *Downlink) Err() error {
+	return d.err
+}
+
+func (d *Downlink) add(p Pair) {
+	if d.err != nil {
+		return
+	}
+	if d.Capture != nil {
+		if _, err := fmt.Fprintln(d.Capture, p); err != nil {
+			d.err = errors.Wrap(err, "failed to write the downlink capture")
+			return
+		}
+	}
+	if d.Decoder == nil {
+		return
+	}
+	if l := d.Decoder.Add(p); l != nil && d.Lists != nil {
+		if err := l.WriteText(d.Lists); err != nil {
+			d.err = errors.Wrap(err, "failed to write the downlink lists")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package downlink
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIO stands in for the CPU.
+type fakeIO struct {
+	cycles     uint64
+	chans      map[uint16]uint16
+	interrupts []string
+}
+
+func newFakeIO() *fakeIO {
+	return &fakeIO{chans: make(map[uint16]uint16)}
+}
+
+func (io *fakeIO) Cycles() uint64                             { return io.cycles }
+func (io *fakeIO) Channel(ch uint16) uint16                   { return io.chans[ch] }
+func (io *fakeIO) SetChannel(ch, val uint16)                  { io.chans[ch] = val }
+func (io *fakeIO) Counter(counter uint16) uint16              { return 0 }
+func (io *fakeIO) Interrupt(name string)                      { io.interrupts = append(io.interrupts, name) }
+func (io *fakeIO) Count(counter uint16, kind cpu.CounterKind) {}
+
+// write stands in for the program writing a channel.
+func (io *fakeIO) write(d *Downlink, ch, val uint16) {
+	io.chans[ch] = val
+	d.ChannelWritten(ch, val)
+}
+
+func TestDownrupt(t *testing.T) {
+	// arrange
+	d := New()
+	io := newFakeIO()
+
+	// act
+	io.cycles = interval - 1
+	d.Step(io)
+	early := len(io.interrupts)
+	io.cycles++
+	d.Step(io)
+	d.Step(io)
+	io.cycles += interval
+	d.Step(io)
+
+	// assert
+	assert.Zero(t, early)
+	assert.Equal(t, []string{"DOWNRUPT", "DOWNRUPT"}, io.interrupts)
+}
+
+func TestCapture(t *testing.T) {
+	// arrange
+	d := New()
+	out := new(bytes.Buffer)
+	d.Capture = out
+	io := newFakeIO()
+
+	// act
+	io.write(d, 034, 1)
+	io.write(d, 035, 077340)
+	io.cycles = interval
+	d.Step(io)
+
+	io.write(d, 013, wordOrderBit)
+	io.write(d, 034, 012345)
+	io.write(d, 035, 054321)
+	io.cycles += interval
+	d.Step(io)
+
+	// nothing is written this time
+	io.cycles += interval
+	d.Step(io)
+
+	// assert
+	assert.Equal(t, "1706 0 00001 77340\n3412 1 12345 54321\n", out.String())
+	assert.Len(t, io.interrupts, 3)
+}
+
+// errorWriter fails once it has written delay bytes, counting the writes.
+type errorWriter struct {
+	delay  int
+	writes int
+}
+
+func (e *errorWriter) Write(p []byte) (n int, err error) {
+	e.writes++
+	if e.delay < len(p) {
+		return e.delay, errors.New("errorWriter eventually returns an error")
+	}
+	e.delay -= len(p)
+	return len(p), nil
+}
+
+func TestCaptureError(t *testing.T) {
+	// arrange
+	d := New()
+	w := &errorWriter{delay: len("1706 0 00001 77340\n")}
+	d.Capture = w
+	io := newFakeIO()
+
+	// act
+	for i := 0; i < 3; i++ {
+		io.write(d, 034, 1)
+		io.write(d, 035, 077340)
+		io.cycles += interval
+		d.Step(io)
+	}
+
+	// assert
+	assert.Error(t, d.Err())
+	assert.Equal(t, 2, w.writes, "writes after the error")
+	assert.Len(t, io.interrupts, 3)
+}
+
+func TestDecodeWhileCapturing(t *testing.T) {
+	// arrange
+	d := New()
+	var err error
+	d.Decoder, err = NewDecoder([]*List{{ID: 1, Type: "test list", Fields: []Field{
+		{Name: "ID"}, {Name: "SYNC"},
+	}}})
+	require.NoError(t, err)
+	out := new(bytes.Buffer)
+	d.Lists = out
+	io := newFakeIO()
+
+	// act
+	io.write(d, 034, 1)
+	io.write(d, 035, 077340)
+	io.cycles = interval
+	d.Step(io)
+
+	// assert
+	assert.Equal(t, "1706 test list (00001)\n  ID   00001\n  SYNC 77340\n", out.String())
+}
@@ -0,0 +1,385 @@
+// Package dsky models the DSKY, the display and keyboard the crew used to
+// talk to the AGC, as a device attached to the CPU's I/O channels.
+//
+// The program drives the display through channel 10, whose words each set
+// two digits (and perhaps a sign) of one row of relays, and the warning
+// lamps through channels 11 and 13. Keys are sent to the program as 5 bit
+// codes on channel 15 along with KEYRUPT1, apart from PRO which is read
+// from bit 14 of channel 32.
+//
+// The CM had a second DSKY in the lower equipment bay, for the navigator.
+// It showed the same display, but its keys came in on channel 16 with
+// KEYRUPT2 (its PRO key being wired to the same bit as the main one's).
+package dsky
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+)
+
+// The times are in memory cycles.
+const (
+	// keySpacing leaves the program time to handle one key before the next.
+	keySpacing = 5 * cpu.CyclesPer10ms
+	// proHold is how long PRO is held down when it's pressed.
+	proHold = 50 * cpu.CyclesPer10ms
+)
+
+// Key is a key on the DSKY keyboard, whose value is its keycode.
+type Key uint16
+
+// The keys, with their codes for channel 15 (apart from PRO).
+const (
+	Key0     Key = 020
+	Key1     Key = 001
+	Key2     Key = 002
+	Key3     Key = 003
+	Key4     Key = 004
+	Key5     Key = 005
+	Key6     Key = 006
+	Key7     Key = 007
+	Key8     Key = 010
+	Key9     Key = 011
+	KeyVerb  Key = 021
+	KeyReset Key = 022
+	KeyRel   Key = 031
+	KeyPlus  Key = 032
+	KeyMinus Key = 033
+	KeyEnter Key = 034
+	KeyClear Key = 036
+	KeyNoun  Key = 037
+	// KeyPro isn't a keycode, as PRO has its own line.
+	KeyPro Key = 0100
+)
+
+var keyNames = map[Key]string{
+	Key0: "0", Key1: "1", Key2: "2", Key3: "3", Key4: "4",
+	Key5: "5", Key6: "6", Key7: "7", Key8: "8", Key9: "9",
+	KeyVerb: "VERB", KeyNoun: "NOUN", KeyPlus: "+", KeyMinus: "-",
+	KeyEnter: "ENTR", KeyClear: "CLR", KeyReset: "RSET", KeyRel: "KEY REL",
+	KeyPro: "PRO",
+}
+
+func (k Key) String() string {
+	if name, ok := keyNames[k]; ok {
+		return name
+	}
+	return "?"
+}
+
+// Station is where a DSKY is, which decides how its keys reach the program.
+type Station int
+
+const (
+	// MainPanel is the DSKY on the main display console.
+	MainPanel Station = iota
+	// NavBay is the CM's second DSKY, in the lower equipment bay.
+	NavBay
+)
+
+// stations hold the channel each station's keycodes are sent on and the
+// interrupt sent with them.
+var stations = [...]struct {
+	name    string
+	channel uint16
+	rupt    string
+}{
+	MainPanel: {"main panel", 015, "KEYRUPT1"},
+	NavBay:    {"nav bay", 016, "KEYRUPT2"},
+}
+
+func (s Station) String() string {
+	if s < 0 || int(s) >= len(stations) {
+		return "?"
+	}
+	return stations[s].name
+}
+
+// Lamps are the DSKY's warning and status lights.
+type Lamps struct {
+	CompActy   bool
+	UplinkActy bool
+	Temp       bool
+	KeyRel     bool
+	OprErr     bool
+	NoAtt      bool
+	GimbalLock bool
+	Prog       bool
+	Tracker    bool
+	PrioDisp   bool
+	NoDAP      bool
+	Vel        bool
+	Alt        bool
+}
+
+// Display is what the DSKY is showing. The digits are '0' to '9', or a
+// space when they are blank, and the signs are '+', '-' or a space.
+type Display struct {
+	Prog, Verb, Noun [2]byte
+	// R holds the three registers, each with a sign and five digits.
+	R [3][6]byte
+	// Flash is set while VERB and NOUN are flashing, when the
+	// program is waiting for the crew to respond.
+	Flash bool
+	Lamps Lamps
+}
+
+// String formats a display as PROG, VERB, NOUN and the three registers.
+func (d Display) String() string {
+	return "P" + string(d.Prog[:]) + " V" + string(d.Verb[:]) + " N" + string(d.Noun[:]) +
+		" " + string(d.R[0][:]) + " " + string(d.R[1][:]) + " " + string(d.R[2][:])
+}
+
+// digits maps the 5 bit relay codes to the digits they light.
+var digits = map[uint16]byte{
+	000: ' ',
+	025: '0', 003: '1', 031: '2', 033: '3', 017: '4',
+	036: '5', 034: '6', 023: '7', 035: '8', 037: '9',
+}
+
+func digit(code uint16) byte {
+	if d, ok := digits[code]; ok {
+		return d
+	}
+	return '?'
+}
+
+// digitCell is where one digit is shown, for decoding channel 10.
+type digitCell struct {
+	// row is 0 for PROG, 1 for VERB, 2 for NOUN and 3 - 5 for R1 - R3
+	row, col int
+}
+
+// relayRow says what the two digits and the flag of a channel 10 row drive.
+type relayRow struct {
+	left, right digitCell
+	// sign is the register whose sign the flag sets, or -1
+	sign     int
+	negative bool
+}
+
+// noCell is the left digit of row 8, which isn't wired to anything.
+var noCell = digitCell{-1, -1}
+
+// relayRows is indexed by the row number in the top four bits of a channel 10 word.
+var relayRows = map[uint16]relayRow{
+	013: {digitCell{0, 0}, digitCell{0, 1}, -1, false},
+	012: {digitCell{1, 0}, digitCell{1, 1}, -1, false},
+	011: {digitCell{2, 0}, digitCell{2, 1}, -1, false},
+	010: {noCell, digitCell{3, 1}, -1, false},
+	007: {digitCell{3, 2}, digitCell{3, 3}, 0, false},
+	006: {digitCell{3, 4}, digitCell{3, 5}, 0, true},
+	005: {digitCell{4, 1}, digitCell{4, 2}, 1, false},
+	004: {digitCell{4, 3}, digitCell{4, 4}, 1, true},
+	003: {digitCell{4, 5}, digitCell{5, 1}, -1, false},
+	002: {digitCell{5, 2}, digitCell{5, 3}, 2, false},
+	001: {digitCell{5, 4}, digitCell{5, 5}, 2, true},
+}
+
+// DSKY is a DSKY attached to a CPU. The front end reads the display with
+// Display and presses keys with Press, from any goroutine.
+type DSKY struct {
+	station Station
+
+	mu      sync.Mutex
+	display Display
+	// plus and minus are the sign relays of each register
+	plus, minus [3]bool
+	// row12 and ch11 are the lamp bits of the channel 10 row 12 and channel 11
+	row12, ch11 uint16
+	lampTest    bool
+
+	keys    []Key
+	pending int32
+	// nextKey is the earliest time the next key can be sent
+	nextKey uint64
+	// proUntil is when PRO is let go, if it is held down
+	proHeld  bool
+	proUntil uint64
+	started  bool
+
+	changed chan struct{}
+}
+
+// New creates the main panel DSKY with a blank display.
+func New() *DSKY {
+	return NewStation(MainPanel)
+}
+
+// NewStation creates a DSKY at a station with a blank display.
+func NewStation(s Station) *DSKY {
+	d := &DSKY{station: s, changed: make(chan struct{}, 1)}
+	blank := func(digits []byte) {
+		for i := range digits {
+			digits[i] = ' '
+		}
+	}
+	blank(d.display.Prog[:])
+	blank(d.display.Verb[:])
+	blank(d.display.Noun[:])
+	for i := range d.display.R {
+		blank(d.display.R[i][:])
+	}
+	d.update()
+	return d
+}
+
+// Station returns where the DSKY is.
+func (d *DSKY) Station() Station {
+	return d.station
+}
+
+// Display returns what the DSKY is showing.
+func (d *DSKY) Display() Display {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.display
+}
+
+// Changed returns a channel which receives a value when the display changes
+// after having been read. Changes which happen in between are merged.
+func (d *DSKY) Changed() <-chan struct{} {
+	return d.changed
+}
+
+// Press queues a key to be sent to the program.
+func (d *DSKY) Press(k Key) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys = append(d.keys, k)
+	atomic.StoreInt32(&d.pending, 1)
+}
+
+// ChannelWritten implements cpu.Device.
+func (d *DSKY) ChannelWritten(ch, val uint16) {
+	switch ch {
+	case 010, 011, 013:
+	default:
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch ch {
+	case 010:
+		d.relayWord(val)
+	case 011:
+		d.ch11 = val
+	case 013:
+		d.lampTest = val&01000 != 0
+	}
+	d.update()
+}
+
+// relayWord sets the relays of one row from a channel 10 word, which is
+// made up of the row (4 bits), a flag, and the codes of the two digits.
+func (d *DSKY) relayWord(val uint16) {
+	row := val >> 11 & 017
+	if row == 014 {
+		d.row12 = val & 03777
+		return
+	}
+	r, ok := relayRows[row]
+	if !ok {
+		return
+	}
+	flag := val&02000 != 0
+	if r.sign >= 0 {
+		if r.negative {
+			d.minus[r.sign] = flag
+		} else {
+			d.plus[r.sign] = flag
+		}
+	}
+	if r.left != noCell {
+		d.setDigit(r.left, digit(val>>5&037))
+	}
+	d.setDigit(r.right, digit(val&037))
+}
+
+func (d *DSKY) setDigit(cell digitCell, c byte) {
+	switch cell.row {
+	case 0:
+		d.display.Prog[cell.col] = c
+	case 1:
+		d.display.Verb[cell.col] = c
+	case 2:
+		d.display.Noun[cell.col] = c
+	default:
+		d.display.R[cell.row-3][cell.col] = c
+	}
+}
+
+// update works out the signs and lamps and tells anyone waiting the display has changed.
+func (d *DSKY) update() {
+	for i := range d.display.R {
+		sign := byte(' ')
+		switch {
+		case d.plus[i] && !d.minus[i]:
+			sign = '+'
+		case d.minus[i] && !d.plus[i]:
+			sign = '-'
+		}
+		d.display.R[i][0] = sign
+	}
+	on := func(bits, mask uint16) bool { return d.lampTest || bits&mask != 0 }
+	d.display.Flash = d.ch11&040 != 0
+	d.display.Lamps = Lamps{
+		CompActy:   on(d.ch11, 002),
+		UplinkActy: on(d.ch11, 004),
+		Temp:       on(d.ch11, 010),
+		KeyRel:     on(d.ch11, 020),
+		OprErr:     on(d.ch11, 0100),
+		PrioDisp:   on(d.row12, 001),
+		NoDAP:      on(d.row12, 002),
+		Vel:        on(d.row12, 004),
+		NoAtt:      on(d.row12, 010),
+		Alt:        on(d.row12, 020),
+		GimbalLock: on(d.row12, 040),
+		Tracker:    on(d.row12, 0200),
+		Prog:       on(d.row12, 0400),
+	}
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Step implements cpu.Device, sending the next key if it's time to.
+func (d *DSKY) Step(io cpu.DeviceIO) {
+	if !d.started {
+		// the inputs of channel 32 are active low, so PRO starts up
+		d.started = true
+		io.SetChannel(032, io.Channel(032)|020000)
+	}
+	now := io.Cycles()
+	if d.proHeld && now >= d.proUntil {
+		d.proHeld = false
+		io.SetChannel(032, io.Channel(032)|020000)
+	}
+	if atomic.LoadInt32(&d.pending) == 0 || now < d.nextKey {
+		return
+	}
+
+	d.mu.Lock()
+	k := d.keys[0]
+	d.keys = d.keys[1:]
+	if len(d.keys) == 0 {
+		atomic.StoreInt32(&d.pending, 0)
+	}
+	d.mu.Unlock()
+
+	d.nextKey = now + keySpacing
+	if k == KeyPro {
+		d.proHeld, d.proUntil = true, now+proHold
+		io.SetChannel(032, io.Channel(032)&^020000)
+		return
+	}
+	// the nav bay's keys share channel 16 with the optics' mark buttons
+	st := stations[d.station]
+	io.SetChannel(st.channel, io.Channel(st.channel)&^037|uint16(k))
+	io.Interrupt(st.rupt)
+}
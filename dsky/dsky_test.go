@@ -0,0 +1,180 @@
+package dsky
+
+import (
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIO stands in for the CPU.
+type fakeIO struct {
+	cycles     uint64
+	chans      map[uint16]uint16
+	interrupts []string
+}
+
+func newFakeIO() *fakeIO {
+	return &fakeIO{chans: make(map[uint16]uint16)}
+}
+
+func (io *fakeIO) Cycles() uint64                             { return io.cycles }
+func (io *fakeIO) Channel(ch uint16) uint16                   { return io.chans[ch] }
+func (io *fakeIO) SetChannel(ch, val uint16)                  { io.chans[ch] = val }
+func (io *fakeIO) Counter(counter uint16) uint16              { return 0 }
+func (io *fakeIO) Interrupt(name string)                      { io.interrupts = append(io.interrupts, name) }
+func (io *fakeIO) Count(counter uint16, kind cpu.CounterKind) {}
+
+// relay makes a channel 10 word.
+func relay(row uint16, flag bool, left, right uint16) uint16 {
+	w := row<<11 | left<<5 | right
+	if flag {
+		w |= 02000
+	}
+	return w
+}
+
+func TestDisplayDigits(t *testing.T) {
+	// arrange
+	d := New()
+
+	// act
+	d.ChannelWritten(010, relay(013, false, 036, 017)) // PROG 54
+	d.ChannelWritten(010, relay(012, false, 003, 031)) // VERB 12
+	d.ChannelWritten(010, relay(011, false, 025, 023)) // NOUN 07
+	d.ChannelWritten(010, relay(010, false, 0, 003))   // R1 1....
+	d.ChannelWritten(010, relay(007, true, 031, 033))  // +R1 .23..
+	d.ChannelWritten(010, relay(006, false, 017, 036)) //    ...45
+	d.ChannelWritten(010, relay(004, true, 0, 0))      // -R2 blank
+	d.ChannelWritten(010, relay(003, false, 0, 035))   // R3 8....
+	d.ChannelWritten(010, relay(002, false, 037, 034)) // R3 .96..
+
+	// assert
+	got := d.Display()
+	assert.Equal(t, "P54 V12 N07 +12345 -       896  ", got.String())
+}
+
+func TestDisplaySignsCancel(t *testing.T) {
+	// arrange
+	d := New()
+
+	// act
+	d.ChannelWritten(010, relay(002, true, 003, 003))
+	d.ChannelWritten(010, relay(001, true, 003, 003))
+
+	// assert
+	assert.Equal(t, [6]byte{' ', ' ', '1', '1', '1', '1'}, d.Display().R[2])
+}
+
+func TestLamps(t *testing.T) {
+	// arrange
+	d := New()
+
+	// act
+	d.ChannelWritten(011, 0142)                         // COMP ACTY, flash, OPR ERR
+	d.ChannelWritten(010, relay(014, false, 0, 0)|0410) // PROG and NO ATT
+
+	// assert
+	got := d.Display()
+	assert.True(t, got.Flash)
+	assert.Equal(t, Lamps{CompActy: true, OprErr: true, Prog: true, NoAtt: true}, got.Lamps)
+}
+
+func TestLampTest(t *testing.T) {
+	// arrange
+	d := New()
+
+	// act
+	d.ChannelWritten(013, 01000)
+
+	// assert
+	lamps := d.Display().Lamps
+	assert.True(t, lamps.KeyRel)
+	assert.True(t, lamps.GimbalLock)
+	assert.True(t, lamps.Alt)
+}
+
+func TestChanged(t *testing.T) {
+	// arrange
+	d := New()
+	<-d.Changed()
+
+	// act
+	d.ChannelWritten(011, 002)
+	d.ChannelWritten(011, 000)
+	d.ChannelWritten(030, 077777)
+
+	// assert
+	select {
+	case <-d.Changed():
+	default:
+		t.Fatal("no change signalled")
+	}
+	select {
+	case <-d.Changed():
+		t.Fatal("changes weren't merged")
+	default:
+	}
+}
+
+func TestPressKeys(t *testing.T) {
+	// arrange
+	d := New()
+	io := newFakeIO()
+	d.Press(KeyVerb)
+	d.Press(Key3)
+
+	// act
+	d.Step(io)
+	first, firstRupts := io.chans[015], len(io.interrupts)
+	io.cycles += keySpacing - 1
+	d.Step(io)
+	early := len(io.interrupts)
+	io.cycles++
+	d.Step(io)
+
+	// assert
+	assert.Equal(t, uint16(KeyVerb), first)
+	assert.Equal(t, 1, firstRupts)
+	assert.Equal(t, 1, early, "the next key waits")
+	assert.Equal(t, uint16(Key3), io.chans[015])
+	assert.Equal(t, []string{"KEYRUPT1", "KEYRUPT1"}, io.interrupts)
+}
+
+func TestPressPro(t *testing.T) {
+	// arrange
+	d := New()
+	io := newFakeIO()
+	d.Step(io)
+	require.Equal(t, uint16(020000), io.chans[032], "PRO is active low")
+
+	// act
+	d.Press(KeyPro)
+	d.Step(io)
+	held := io.chans[032]
+	io.cycles += proHold
+	d.Step(io)
+
+	// assert
+	assert.Zero(t, held&020000)
+	assert.Equal(t, uint16(020000), io.chans[032])
+	assert.Empty(t, io.interrupts)
+}
+
+func TestNavBayKeys(t *testing.T) {
+	// arrange
+	d := NewStation(NavBay)
+	io := newFakeIO()
+	io.chans[016] = 0140 // the mark buttons
+	d.Press(KeyEnter)
+
+	// act
+	d.Step(io)
+
+	// assert
+	assert.Equal(t, uint16(0140|KeyEnter), io.chans[016])
+	assert.Zero(t, io.chans[015])
+	assert.Equal(t, []string{"KEYRUPT2"}, io.interrupts)
+	assert.Equal(t, "nav bay", d.Station().String())
+}
@@ -0,0 +1,58 @@
+package imu
+
+import "math"
+
+// matrix transforms a vector's components from one frame to another.
+type matrix [3][3]float64
+
+func (a matrix) mul(b matrix) matrix {
+	var m matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+// rotation is the matrix which transforms components into a frame turned
+// by angle radians about the given axis (0 for X, 1 for Y and 2 for Z).
+func rotation(axis int, angle float64) matrix {
+	s, c := math.Sin(angle), math.Cos(angle)
+	m := matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	i, j := (axis+1)%3, (axis+2)%3
+	m[i][i], m[i][j] = c, s
+	m[j][i], m[j][j] = -s, c
+	return m
+}
+
+// gimbalMatrix is the transformation from the stable member's frame to the
+// vehicle's for the given outer, inner and middle gimbal angles in degrees.
+// The inner gimbal turns about the stable member's Y axis, then the middle
+// about the new Z axis and the outer about the vehicle's X axis.
+func gimbalMatrix(g [3]float64) matrix {
+	return rotation(0, radians(g[outer])).
+		mul(rotation(2, radians(g[middle]))).
+		mul(rotation(1, radians(g[inner])))
+}
+
+// gimbalAngles is the inverse of gimbalMatrix, giving middle gimbal angles
+// between -90 and 90 degrees.
+func gimbalAngles(m matrix) [3]float64 {
+	var g [3]float64
+	g[middle] = degrees(math.Asin(math.Max(-1, math.Min(1, m[0][1]))))
+	g[inner] = degrees(math.Atan2(-m[0][2], m[0][0]))
+	g[outer] = degrees(math.Atan2(-m[2][1], m[1][1]))
+	return g
+}
+
+// torque turns the stable member by angle degrees about one of its axes,
+// with the vehicle holding still, returning the new gimbal angles.
+func torque(g [3]float64, axis int, angle float64) [3]float64 {
+	return gimbalAngles(gimbalMatrix(g).mul(rotation(axis, -radians(angle))))
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
@@ -0,0 +1,40 @@
+package imu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGimbalAngles(t *testing.T) {
+	// arrange
+	g := [3]float64{30, -50, 20}
+
+	// act
+	got := gimbalAngles(gimbalMatrix(g))
+
+	// assert
+	assert.InDeltaSlice(t, g[:], got[:], 1e-9)
+}
+
+func TestTorque(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		axis     int
+		expected [3]float64
+	}{
+		{"X turns the outer gimbal", 0, [3]float64{-1, 0, 0}},
+		{"Y turns the inner gimbal", 1, [3]float64{0, -1, 0}},
+		{"Z turns the middle gimbal", 2, [3]float64{0, 0, -1}},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			// act
+			g := torque([3]float64{}, scenario.axis, 1)
+
+			// assert
+			assert.InDeltaSlice(t, scenario.expected[:], g[:], 1e-9)
+		})
+	}
+}
@@ -0,0 +1,328 @@
+// Package imu models the inertial measurement unit and the CDUs which
+// connect it to the AGC.
+//
+// The IMU's stable member holds still in inertial space on three gimbals
+// while the vehicle turns around it. A CDU reads each gimbal's angle,
+// counting CDUX (outer), CDUY (inner) or CDUZ (middle) up or down by PCDU
+// and MCDU as it turns. The PIPAs on the stable member count PIPAX, PIPAY
+// and PIPAZ up or down by PINC and MINC for each increment of velocity.
+//
+// The program turns the stable member by loading GYROCTR with a number of
+// pulses for a gyro, which it selects and enables on channel 14. For coarse
+// alignment it loads CDUXCMD, CDUYCMD and CDUZCMD with the number of CDU
+// counts to drive each gimbal, enabling the drives on channel 14 and coarse
+// alignment on channel 12. The hardware counts these down by DINC at 3200
+// pulses a second. The IMU's status is on channels 30 and 33, active low.
+package imu
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+)
+
+// The times are in memory cycles.
+const (
+	// pulseSpacing sends gyro and CDU drive pulses about 3200 a second.
+	pulseSpacing = cpu.CyclesPer10ms / 32
+	// cduSpacing lets a CDU count about 6400 times a second as it follows
+	// its gimbal.
+	cduSpacing = cpu.CyclesPer10ms / 64
+)
+
+const (
+	// CountAngle is the angle in degrees of a CDU count, 2^-15 of a turn.
+	CountAngle = 360.0 / (1 << 15)
+	// GyroPulseAngle is the angle in degrees a gyro torquing pulse turns
+	// the stable member, 2^-21 of a turn.
+	GyroPulseAngle = 360.0 / (1 << 21)
+	// CMPIPAScale is the velocity in m/s of a PIPA count in the CM's IMU.
+	CMPIPAScale = 0.0585
+)
+
+const (
+	counterCDUX    = 032
+	counterPIPAX   = 037
+	counterGYROCTR = 047
+	counterCDUXCMD = 050
+
+	chanIMUControl = 012
+	coarseAlignBit = 010
+	zeroCDUsBit    = 020
+
+	chanDrive    = 014
+	gyroPowerBit = 040
+	// gyroSelectBits are 1, 2 or 3 for the X, Y or Z gyro
+	gyroSelectBits  = 0300
+	gyroMinusBit    = 0400
+	gyroActivityBit = 01000
+	// driveXBit enables driving the outer gimbal, with the next two bits
+	// down for the inner and middle ones
+	driveXBit = 040000
+)
+
+// The gimbals, in the order of the CDUs which read them.
+const (
+	outer = iota
+	inner
+	middle
+)
+
+// Discrete is one of the IMU's status discretes.
+type Discrete int
+
+// The discretes, which are all on channel 30 except for PIPAFail.
+const (
+	Operate Discrete = iota
+	Cage
+	CDUFail
+	IMUFail
+	TurnOn
+	TempInLimits
+	PIPAFail
+	discreteCount
+)
+
+var discreteNames = [discreteCount]string{
+	"operate", "cage", "cdu-fail", "imu-fail", "turn-on", "temp", "pipa-fail",
+}
+
+func (d Discrete) String() string {
+	if d < 0 || d >= discreteCount {
+		return "DISCRETE?"
+	}
+	return discreteNames[d]
+}
+
+// discreteBits are the channels and bits of the discretes, which are 0
+// when the discrete is on.
+var discreteBits = [discreteCount]struct{ ch, bit uint16 }{
+	{030, 0400}, {030, 02000}, {030, 04000}, {030, 010000},
+	{030, 020000}, {030, 040000}, {033, 010000},
+}
+
+// Inputs are what the IMU senses.
+type Inputs struct {
+	// Gimbals are the angles of the outer, inner and middle gimbals in
+	// degrees, from 0 to 360.
+	Gimbals [3]float64
+	// Rates are how fast the vehicle turns the gimbals, in degrees a second.
+	Rates [3]float64
+	// Acceleration is the vehicle's acceleration in m/s² along the stable
+	// member's X, Y and Z axes, not counting gravity.
+	Acceleration [3]float64
+	// Discretes are the discretes which are on.
+	Discretes map[Discrete]bool
+}
+
+type change struct {
+	at    uint64
+	apply func(in *Inputs)
+}
+
+// IMU is a cpu.Device which counts the gimbal angles into the CDU counters
+// and the velocity into the PIPA counters, and carries out the program's
+// gyro torquing and coarse alignment. While the IMU isn't operating, the
+// PIPAs don't count and gyro torquing doesn't turn the stable member.
+type IMU struct {
+	// PIPAScale is the velocity in m/s of a PIPA count.
+	PIPAScale float64
+
+	in      Inputs
+	changes []change
+
+	started bool
+	// last is the time of the last step
+	last uint64
+	// velocity is the velocity the PIPAs have yet to count
+	velocity  [3]float64
+	nextCDU   [3]uint64
+	nextDrive [3]uint64
+	nextGyro  uint64
+}
+
+// New creates an operating IMU with all its gimbal angles 0, holding still.
+func New() *IMU {
+	return &IMU{
+		PIPAScale: CMPIPAScale,
+		in: Inputs{Discretes: map[Discrete]bool{
+			Operate:      true,
+			TempInLimits: true,
+		}},
+	}
+}
+
+// At schedules a change to the inputs at a time after the CPU starts. The
+// change is made from the goroutine running the CPU, so it must only touch
+// the inputs it is given.
+func (m *IMU) At(t time.Duration, apply func(in *Inputs)) {
+	at := uint64(t * cpu.CyclesPer10ms / (10 * time.Millisecond))
+	i := sort.Search(len(m.changes), func(i int) bool { return m.changes[i].at > at })
+	m.changes = append(m.changes, change{})
+	copy(m.changes[i+1:], m.changes[i:])
+	m.changes[i] = change{at, apply}
+}
+
+// Inputs returns what the IMU senses now. It must not be called while the
+// CPU is running.
+func (m *IMU) Inputs() Inputs {
+	return m.in
+}
+
+// ChannelWritten implements cpu.Device. The IMU reads the channels it
+// needs when it steps.
+func (m *IMU) ChannelWritten(ch, val uint16) {}
+
+// Step implements cpu.Device.
+func (m *IMU) Step(io cpu.DeviceIO) {
+	now := io.Cycles()
+	if !m.started {
+		m.started = true
+		m.last = now
+		m.setDiscretes(io)
+	}
+	dt := float64(now-m.last) / cpu.CyclesPer10ms / 100
+	m.last = now
+
+	changed := false
+	for len(m.changes) > 0 && m.changes[0].at <= now {
+		m.changes[0].apply(&m.in)
+		m.changes = m.changes[1:]
+		changed = true
+	}
+	if changed {
+		for i, g := range m.in.Gimbals {
+			m.in.Gimbals[i] = normalize(g)
+		}
+		m.setDiscretes(io)
+	}
+	for i, r := range m.in.Rates {
+		m.in.Gimbals[i] = normalize(m.in.Gimbals[i] + r*dt)
+	}
+
+	control, drive := io.Channel(chanIMUControl), io.Channel(chanDrive)
+	m.torqueGyro(io, now, drive)
+	m.driveGimbals(io, now, control, drive)
+	m.countPIPAs(io, dt)
+	m.followGimbals(io, now, control)
+}
+
+// torqueGyro sends a pulse from GYROCTR to the selected gyro.
+func (m *IMU) torqueGyro(io cpu.DeviceIO, now uint64, drive uint16) {
+	if drive&gyroActivityBit == 0 || now < m.nextGyro {
+		return
+	}
+	sign, ok := pulse(io, counterGYROCTR)
+	if !ok {
+		return
+	}
+	m.nextGyro = now + pulseSpacing
+
+	axis := int(drive&gyroSelectBits>>6) - 1
+	if axis < 0 || drive&gyroPowerBit == 0 || !m.in.Discretes[Operate] {
+		return
+	}
+	if drive&gyroMinusBit != 0 {
+		sign = -sign
+	}
+	g := torque(m.in.Gimbals, axis, sign*GyroPulseAngle)
+	for i := range g {
+		m.in.Gimbals[i] = normalize(g[i])
+	}
+}
+
+// driveGimbals sends a pulse from each of CDUXCMD, CDUYCMD and CDUZCMD whose
+// drive is enabled, which turns the gimbal during coarse alignment.
+func (m *IMU) driveGimbals(io cpu.DeviceIO, now uint64, control, drive uint16) {
+	for i := range m.nextDrive {
+		if drive&(driveXBit>>uint(i)) == 0 || now < m.nextDrive[i] {
+			continue
+		}
+		sign, ok := pulse(io, counterCDUXCMD+uint16(i))
+		if !ok {
+			continue
+		}
+		m.nextDrive[i] = now + pulseSpacing
+		if control&coarseAlignBit != 0 {
+			m.in.Gimbals[i] = normalize(m.in.Gimbals[i] + sign*CountAngle)
+		}
+	}
+}
+
+// countPIPAs counts the velocity gained since the last step.
+func (m *IMU) countPIPAs(io cpu.DeviceIO, dt float64) {
+	if !m.in.Discretes[Operate] {
+		return
+	}
+	for i, a := range m.in.Acceleration {
+		counter := counterPIPAX + uint16(i)
+		m.velocity[i] += a * dt
+		for ; m.velocity[i] >= m.PIPAScale; m.velocity[i] -= m.PIPAScale {
+			io.Count(counter, cpu.PINC)
+		}
+		for ; m.velocity[i] <= -m.PIPAScale; m.velocity[i] += m.PIPAScale {
+			io.Count(counter, cpu.MINC)
+		}
+	}
+}
+
+// followGimbals counts each CDU a step towards its gimbal's angle, or
+// towards 0 while the program has the CDUs zeroed.
+func (m *IMU) followGimbals(io cpu.DeviceIO, now uint64, control uint16) {
+	for i, g := range m.in.Gimbals {
+		if now < m.nextCDU[i] {
+			continue
+		}
+		var target uint16
+		if control&zeroCDUsBit == 0 {
+			target = uint16(int(math.Floor(g/CountAngle+0.5))) & 077777
+		}
+		counter := counterCDUX + uint16(i)
+		diff := (target - io.Counter(counter)) & 077777
+		if diff == 0 {
+			continue
+		}
+		kind := cpu.PCDU
+		if diff >= 040000 {
+			kind = cpu.MCDU
+		}
+		io.Count(counter, kind)
+		m.nextCDU[i] = now + cduSpacing
+	}
+}
+
+// setDiscretes puts the discretes on their channels.
+func (m *IMU) setDiscretes(io cpu.DeviceIO) {
+	for d, b := range discreteBits {
+		val := io.Channel(b.ch) | b.bit
+		if m.in.Discretes[Discrete(d)] {
+			val &^= b.bit
+		}
+		io.SetChannel(b.ch, val)
+	}
+}
+
+// pulse counts a command counter down by DINC if it isn't zero, returning
+// the sign of the pulse that sends.
+func pulse(io cpu.DeviceIO, counter uint16) (float64, bool) {
+	val := io.Counter(counter)
+	if val == 0 || val == 077777 {
+		return 0, false
+	}
+	io.Count(counter, cpu.DINC)
+	if val&040000 != 0 {
+		return -1, true
+	}
+	return 1, true
+}
+
+// normalize puts an angle in degrees between 0 and 360.
+func normalize(angle float64) float64 {
+	angle = math.Mod(angle, 360)
+	if angle < 0 {
+		angle += 360
+	}
+	return angle
+}
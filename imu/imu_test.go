@@ -0,0 +1,194 @@
+package imu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIO stands in for the CPU, counting the counters straight away.
+type fakeIO struct {
+	cycles   uint64
+	chans    map[uint16]uint16
+	counters map[uint16]uint16
+	// counts are the counts asked for of each kind
+	counts map[cpu.CounterKind]int
+}
+
+func newFakeIO() *fakeIO {
+	return &fakeIO{
+		chans:    make(map[uint16]uint16),
+		counters: make(map[uint16]uint16),
+		counts:   make(map[cpu.CounterKind]int),
+	}
+}
+
+func (io *fakeIO) Cycles() uint64                { return io.cycles }
+func (io *fakeIO) Channel(ch uint16) uint16      { return io.chans[ch] }
+func (io *fakeIO) SetChannel(ch, val uint16)     { io.chans[ch] = val }
+func (io *fakeIO) Counter(counter uint16) uint16 { return io.counters[counter] }
+func (io *fakeIO) Interrupt(name string)         {}
+func (io *fakeIO) Count(counter uint16, kind cpu.CounterKind) {
+	io.counts[kind]++
+	io.counters[counter] = count(io.counters[counter], kind)
+}
+
+// count changes a counter the way the CPU's unprogrammed sequences do.
+func count(val uint16, kind cpu.CounterKind) uint16 {
+	const minusZero, maxPositive, maxNegative = 077777, 037777, 040000
+	switch kind {
+	case cpu.PINC:
+		switch val {
+		case maxPositive:
+			return 0
+		case minusZero:
+			return 1
+		}
+		return val + 1
+	case cpu.MINC:
+		switch val {
+		case maxNegative:
+			return minusZero
+		case 0:
+			return minusZero - 1
+		}
+		return val - 1
+	case cpu.PCDU:
+		return (val + 1) & 077777
+	case cpu.MCDU:
+		return (val - 1) & 077777
+	case cpu.DINC:
+		switch {
+		case val == 0 || val == minusZero:
+			return val
+		case val < maxNegative:
+			return val - 1
+		}
+		return val + 1
+	case cpu.SHINC:
+		return val << 1 & 077777
+	case cpu.SHANC:
+		return (val<<1 | 1) & 077777
+	}
+	return val
+}
+
+// run steps m once every memory cycle until the given time.
+func (io *fakeIO) run(m *IMU, until time.Duration) {
+	end := uint64(until * cpu.CyclesPer10ms / (10 * time.Millisecond))
+	for ; io.cycles < end; io.cycles++ {
+		m.Step(io)
+	}
+}
+
+func TestDiscretes(t *testing.T) {
+	// arrange
+	m := New()
+	m.At(time.Second, func(in *Inputs) {
+		in.Discretes[Operate] = false
+		in.Discretes[PIPAFail] = true
+	})
+	io := newFakeIO()
+	io.chans[030] = 1
+
+	// act
+	io.run(m, 10*time.Millisecond)
+	before := io.chans[030]
+	io.run(m, 2*time.Second)
+
+	// assert
+	assert.Equal(t, uint16(036001), before, "operate and temp in limits are on")
+	assert.Equal(t, uint16(036401), io.chans[030])
+	assert.Equal(t, uint16(0), io.chans[033], "PIPA fail is on")
+}
+
+func TestCDUsFollowGimbals(t *testing.T) {
+	// arrange
+	m := New()
+	m.At(0, func(in *Inputs) {
+		in.Gimbals = [3]float64{90, 0, 0}
+		in.Rates = [3]float64{0, 10, 0}
+	})
+	m.At(time.Second, func(in *Inputs) { in.Rates = [3]float64{0, -10, 0} })
+	io := newFakeIO()
+
+	// act
+	io.run(m, 1500*time.Millisecond)
+
+	// assert
+	assert.Equal(t, uint16(020000), io.counters[counterCDUX])
+	assert.InDelta(t, 5, m.Inputs().Gimbals[inner], 0.01)
+	assert.Equal(t, uint16(455), io.counters[counterCDUX+1])
+	assert.Equal(t, uint16(0), io.counters[counterCDUX+2])
+}
+
+func TestZeroCDUs(t *testing.T) {
+	// arrange
+	m := New()
+	m.At(0, func(in *Inputs) { in.Gimbals = [3]float64{0, 0, -1} })
+	io := newFakeIO()
+	io.run(m, time.Second)
+	io.chans[chanIMUControl] = zeroCDUsBit
+
+	// act
+	io.run(m, 2*time.Second)
+
+	// assert
+	assert.Equal(t, 91, io.counts[cpu.MCDU])
+	assert.Equal(t, 91, io.counts[cpu.PCDU])
+	assert.Zero(t, io.counters[counterCDUX+2])
+}
+
+func TestPIPAs(t *testing.T) {
+	// arrange
+	m := New()
+	m.At(0, func(in *Inputs) { in.Acceleration = [3]float64{1, -2, 0} })
+	io := newFakeIO()
+
+	// act
+	io.run(m, 2*time.Second)
+
+	// assert
+	assert.Equal(t, uint16(34), io.counters[counterPIPAX], "2 m/s in 0.0585 m/s counts")
+	assert.Equal(t, uint16(077777-68), io.counters[counterPIPAX+1], "-68 in ones' complement")
+	assert.Zero(t, io.counters[counterPIPAX+2])
+}
+
+func TestGyroTorquing(t *testing.T) {
+	// arrange
+	m := New()
+	io := newFakeIO()
+	io.counters[counterGYROCTR] = 1000
+	io.chans[chanDrive] = gyroActivityBit | gyroPowerBit | gyroMinusBit | 3<<6
+
+	// act
+	io.run(m, time.Second)
+
+	// assert
+	assert.Zero(t, io.counters[counterGYROCTR])
+	assert.Equal(t, 1000, io.counts[cpu.DINC])
+	assert.InDelta(t, 1000*GyroPulseAngle, m.Inputs().Gimbals[middle], 1e-9)
+}
+
+func TestCoarseAlign(t *testing.T) {
+	// arrange
+	m := New()
+	io := newFakeIO()
+	io.counters[counterCDUXCMD] = 100
+	io.counters[counterCDUXCMD+1] = ^uint16(200) & 077777
+	io.counters[counterCDUXCMD+2] = 300
+	io.chans[chanIMUControl] = coarseAlignBit
+	io.chans[chanDrive] = driveXBit | driveXBit>>1
+
+	// act
+	io.run(m, time.Second)
+
+	// assert
+	assert.Equal(t, uint16(0), io.counters[counterCDUXCMD])
+	assert.Equal(t, uint16(077777), io.counters[counterCDUXCMD+1], "DINC stops at -0")
+	assert.Equal(t, uint16(300), io.counters[counterCDUXCMD+2], "the middle gimbal isn't driven")
+	assert.Equal(t, uint16(100), io.counters[counterCDUX])
+	assert.Equal(t, uint16(-200&077777), io.counters[counterCDUX+1])
+}
@@ -0,0 +1,109 @@
+package imu
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Load schedules the changes in an IMU script, one per line, each starting
+// with the time after the CPU starts to make it, such as 500ms or 2s:
+//
+//	<time> gimbals <outer> <inner> <middle>   set the gimbal angles in degrees
+//	<time> rates <outer> <inner> <middle>     turn the gimbals in degrees a second
+//	<time> accel <x> <y> <z>                  accelerate in m/s² along the
+//	                                          stable member's axes
+//	<time> on <discrete>                      turn a discrete on
+//	<time> off <discrete>                     or off
+//
+// The discretes are operate, cage, cdu-fail, imu-fail, turn-on, temp (the
+// stable member's temperature is in limits) and pipa-fail. Anything after
+// a # is a comment.
+func (m *IMU) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := m.command(fields); err != nil {
+			return errors.Wrapf(err, "IMU script line %d", line)
+		}
+	}
+	return errors.Wrap(s.Err(), "failed to read IMU script")
+}
+
+func (m *IMU) command(fields []string) error {
+	if len(fields) < 2 {
+		return errors.New("expected a time and a command")
+	}
+	at, err := time.ParseDuration(fields[0])
+	if err != nil {
+		return errors.Errorf("bad time %q", fields[0])
+	}
+	cmd, args := fields[1], fields[2:]
+
+	switch cmd {
+	case "gimbals", "rates", "accel":
+		v, err := vector(cmd, args)
+		if err != nil {
+			return err
+		}
+		m.At(at, func(in *Inputs) {
+			switch cmd {
+			case "gimbals":
+				in.Gimbals = v
+			case "rates":
+				in.Rates = v
+			default:
+				in.Acceleration = v
+			}
+		})
+	case "on", "off":
+		if len(args) != 1 {
+			return errors.Errorf("%s takes a discrete", cmd)
+		}
+		d, ok := discreteNamed(args[0])
+		if !ok {
+			return errors.Errorf("unknown discrete %q", args[0])
+		}
+		on := cmd == "on"
+		m.At(at, func(in *Inputs) { in.Discretes[d] = on })
+	default:
+		return errors.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// vector parses the three numbers of a command.
+func vector(cmd string, args []string) ([3]float64, error) {
+	var v [3]float64
+	if len(args) != 3 {
+		return v, errors.Errorf("%s takes three numbers", cmd)
+	}
+	for i, arg := range args {
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return v, errors.Errorf("bad number %q", arg)
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+func discreteNamed(name string) (Discrete, bool) {
+	for d := Discrete(0); d < discreteCount; d++ {
+		if d.String() == name {
+			return d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,65 @@
+package imu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	// arrange
+	m := New()
+	script := `
+# turn onto the burn attitude
+1s    rates   0 0 5   # degrees a second
+0s    gimbals 10 20 30
+2s    rates   0 0 0
+2s    accel   1.5 0 -3
+3s    off     operate
+3s    on      imu-fail
+`
+
+	// act
+	err := m.Load(strings.NewReader(script))
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, m.changes, 6)
+	assert.Equal(t, uint64(0), m.changes[0].at, "the changes are in time order")
+	assert.Equal(t, uint64(100*cpu.CyclesPer10ms), m.changes[1].at)
+	for _, c := range m.changes {
+		c.apply(&m.in)
+	}
+	assert.Equal(t, [3]float64{10, 20, 30}, m.in.Gimbals)
+	assert.Equal(t, [3]float64{}, m.in.Rates)
+	assert.Equal(t, [3]float64{1.5, 0, -3}, m.in.Acceleration)
+	assert.False(t, m.in.Discretes[Operate])
+	assert.True(t, m.in.Discretes[IMUFail])
+}
+
+func TestLoadErrors(t *testing.T) {
+	scenarios := []struct {
+		script, err string
+	}{
+		{"1s", "IMU script line 1: expected a time and a command"},
+		{"\nsoon rates 0 0 0", `IMU script line 2: bad time "soon"`},
+		{"0s accel 1 2", "IMU script line 1: accel takes three numbers"},
+		{"0s gimbals 1 x 2", `IMU script line 1: bad number "x"`},
+		{"0s on", "IMU script line 1: on takes a discrete"},
+		{"0s off gyro", `IMU script line 1: unknown discrete "gyro"`},
+		{"0s align", `IMU script line 1: unknown command "align"`},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.script, func(t *testing.T) {
+			// act
+			err := New().Load(strings.NewReader(scenario.script))
+
+			// assert
+			assert.EqualError(t, err, scenario.err)
+		})
+	}
+}
@@ -19,6 +19,13 @@ const (
 
 // test
 
+// ErasableBanks is the number of erasable banks and FixedBanks the number of
+// fixed banks, including the superbanks 040 - 047.
+const (
+	ErasableBanks = erasableBankCount
+	FixedBanks    = fixedBankCount + fixedSBBankCount
+)
+
 type ebank [erasableBankSize]uint16
 type fbank [fixedBankSize]uint16
 type bank []uint16
@@ -61,6 +68,39 @@ func (mm *Main) Write(address int, val uint16) error {
 	return nil
 }
 
+// SelectBanks chooses the erasable bank seen at 01400 - 01777 and the fixed
+// bank seen at 02000 - 03777. With the super-bit set, fixed banks 030 - 037
+// are replaced by 040 - 047.
+func (mm *Main) SelectBanks(eb, fb int, superBit bool) {
+	mm.eb = eb
+	mm.fb = fb
+	mm.sb = superBit
+}
+
+// ReadErasable gets a word from an erasable bank regardless of which bank
+// is currently selected, for tools which need to look at all of memory.
+func (mm *Main) ReadErasable(bank, offset int) (uint16, error) {
+	if bank < 0 || bank >= erasableBankCount {
+		return 0, errors.Errorf("erasable bank %o is out of range", bank)
+	}
+	if offset < 0 || offset >= erasableBankSize {
+		return 0, errors.Errorf("offset %o is out of range", offset)
+	}
+	return mm.erasable[bank][offset], nil
+}
+
+// ReadFixed gets a word from a fixed bank (including the superbanks 040 - 047)
+// regardless of which bank is currently selected.
+func (mm *Main) ReadFixed(bank, offset int) (uint16, error) {
+	if bank < 0 || bank >= fixedBankCount+fixedSBBankCount {
+		return 0, errors.Errorf("fixed bank %o is out of range", bank)
+	}
+	if offset < 0 || offset >= fixedBankSize {
+		return 0, errors.Errorf("offset %o is out of range", offset)
+	}
+	return mm.fixed[bank][offset], nil
+}
+
 func (mm *Main) selectBank(address int) (bank, error) {
 	if address < 0 || address >= totalMemorySize {
 		return nil, errors.Errorf("address %o is out of range", address)
@@ -114,6 +114,24 @@ func TestFixedBankSelection(t *testing.T) {
 	}
 }
 
+func TestSelectBanks(t *testing.T) {
+	// arrange
+	var mm Main
+	mm.erasable[5][012] = 0123
+	mm.fixed[042][012] = 0456
+
+	// act
+	mm.SelectBanks(5, 032, true)
+	erasable, errErasable := mm.Read(01400 + 012)
+	fixed, errFixed := mm.Read(02000 + 012)
+
+	// assert
+	assert.NoError(t, errErasable)
+	assert.NoError(t, errFixed)
+	assert.Equal(t, uint16(0123), erasable, "erasable")
+	assert.Equal(t, uint16(0456), fixed, "fixed")
+}
+
 func TestRoundTripOverflowCorrection(t *testing.T) {
 	var mm Main
 
@@ -150,6 +168,42 @@ func TestReadOutOfRange(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestReadErasable(t *testing.T) {
+	// arrange
+	var mm Main
+	mm.eb = 5
+	assert.NoError(t, mm.Write(01400+012, 0123))
+	mm.eb = 0
+
+	// act
+	val, err := mm.ReadErasable(5, 012)
+	_, errBank := mm.ReadErasable(erasableBankCount, 0)
+	_, errOffset := mm.ReadErasable(0, erasableBankSize)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0123), val)
+	assert.Error(t, errBank)
+	assert.Error(t, errOffset)
+}
+
+func TestReadFixed(t *testing.T) {
+	// arrange
+	var mm Main
+	mm.fixed[041][012] = 0123
+
+	// act
+	val, err := mm.ReadFixed(041, 012)
+	_, errBank := mm.ReadFixed(fixedBankCount+fixedSBBankCount, 0)
+	_, errOffset := mm.ReadFixed(0, fixedBankSize)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0123), val)
+	assert.Error(t, errBank)
+	assert.Error(t, errOffset)
+}
+
 func TestWriteOutOfRange(t *testing.T) {
 	var (
 		mm  Main
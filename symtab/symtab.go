@@ -0,0 +1,291 @@
+// Package symtab holds the symbol and source line information produced by the
+// assembler so that other tools (like the debugger) can refer to code by name.
+//
+// All addresses in a Table are psudo-addresses, which uniquely identify every
+// word of erasable and fixed memory regardless of bank switching.
+package symtab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	startOfFixed     = 004000
+	erasableBankBits = 8
+	fixedBankBits    = 10
+)
+
+// Location identifies a line in an assembly source file.
+type Location struct {
+	File string
+	Line int
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+type symbol struct {
+	name    string
+	address uint16
+}
+
+// Table maps symbol names to psudo-addresses and psudo-addresses
+// to the source lines that generated them.
+type Table struct {
+	symbols map[string]uint16
+	lines   map[uint16]Location
+
+	// sorted is built lazily for address to symbol lookups
+	sorted []symbol
+}
+
+// New creates an empty Table.
+func New() *Table {
+	return &Table{
+		symbols: make(map[string]uint16),
+		lines:   make(map[uint16]Location),
+	}
+}
+
+// Define adds (or replaces) a symbol in the table.
+func (t *Table) Define(name string, address uint16) {
+	t.symbols[name] = address
+	t.sorted = nil
+}
+
+// SetLine records the source line that generated the word at the given address.
+func (t *Table) SetLine(address uint16, loc Location) {
+	t.lines[address] = loc
+}
+
+// Lookup finds the address of the named symbol.
+func (t *Table) Lookup(name string) (uint16, bool) {
+	if a, ok := t.symbols[name]; ok {
+		return a, true
+	}
+	// the assembler upper cases all of its symbols
+	a, ok := t.symbols[strings.ToUpper(name)]
+	return a, ok
+}
+
+// Line finds the source line that generated the word at the given address.
+func (t *Table) Line(address uint16) (Location, bool) {
+	loc, ok := t.lines[address]
+	return loc, ok
+}
+
+// Addresses returns, in order, every address which has a source line.
+func (t *Table) Addresses() []uint16 {
+	addrs := make([]uint16, 0, len(t.lines))
+	for a := range t.lines {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Address finds the lowest address generated by the given source line.
+func (t *Table) Address(loc Location) (uint16, bool) {
+	var (
+		best  uint16
+		found bool
+	)
+	for a, l := range t.lines {
+		if l.Line == loc.Line && sameFile(l.File, loc.File) && (!found || a < best) {
+			best = a
+			found = true
+		}
+	}
+	return best, found
+}
+
+func sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+	// allow a bare file name to match a full path
+	return strings.HasSuffix(a, "/"+b) || strings.HasSuffix(b, "/"+a)
+}
+
+// Names returns all of the symbol names in the table in alphabetical order.
+func (t *Table) Names() []string {
+	names := make([]string, 0, len(t.symbols))
+	for n := range t.symbols {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Nearest finds the closest symbol at or below the given address within the
+// same memory bank, along with the offset of the address from that symbol.
+func (t *Table) Nearest(address uint16) (name string, offset uint16, ok bool) {
+	if t.sorted == nil {
+		t.sorted = make([]symbol, 0, len(t.symbols))
+		for n, a := range t.symbols {
+			t.sorted = append(t.sorted, symbol{name: n, address: a})
+		}
+		sort.Slice(t.sorted, func(i, j int) bool {
+			if t.sorted[i].address == t.sorted[j].address {
+				return t.sorted[i].name < t.sorted[j].name
+			}
+			return t.sorted[i].address < t.sorted[j].address
+		})
+	}
+
+	// find the first symbol past the address and step back one
+	i := sort.Search(len(t.sorted), func(i int) bool {
+		return t.sorted[i].address > address
+	})
+	if i == 0 {
+		return "", 0, false
+	}
+	s := t.sorted[i-1]
+	if bankOf(s.address) != bankOf(address) {
+		return "", 0, false
+	}
+
+	// prefer the first name defined at this address
+	for i > 1 && t.sorted[i-2].address == s.address {
+		i--
+		s = t.sorted[i-1]
+	}
+	return s.name, address - s.address, true
+}
+
+func bankOf(address uint16) uint16 {
+	if address < startOfFixed {
+		return address >> erasableBankBits
+	}
+	return 0100 + address>>fixedBankBits
+}
+
+// Symbolize formats an address as SYMBOL+offset, falling
+// back to octal if there is no suitable symbol.
+func (t *Table) Symbolize(address uint16) string {
+	if t != nil {
+		if n, off, ok := t.Nearest(address); ok {
+			if off == 0 {
+				return n
+			}
+			return fmt.Sprintf("%s+%o", n, off)
+		}
+	}
+	return fmt.Sprintf("%05o", address)
+}
+
+// Resolve parses an address specification, which may be an octal
+// address, a symbol (optionally with a +offset in octal), or a
+// source location in the form file:line.
+func (t *Table) Resolve(spec string) (uint16, error) {
+	if v, err := strconv.ParseUint(spec, 8, 16); err == nil {
+		return uint16(v), nil
+	}
+	if t == nil {
+		return 0, errors.Errorf("no symbols loaded to resolve %q", spec)
+	}
+
+	if c := strings.LastIndex(spec, ":"); c >= 0 {
+		line, err := strconv.Atoi(spec[c+1:])
+		if err != nil {
+			return 0, errors.Errorf("bad line number in %q", spec)
+		}
+		a, ok := t.Address(Location{File: spec[:c], Line: line})
+		if !ok {
+			return 0, errors.Errorf("no code at %s", spec)
+		}
+		return a, nil
+	}
+
+	name, off := spec, uint64(0)
+	if p := strings.Index(spec, "+"); p >= 0 {
+		var err error
+		if off, err = strconv.ParseUint(spec[p+1:], 8, 16); err != nil {
+			return 0, errors.Errorf("bad offset in %q", spec)
+		}
+		name = spec[:p]
+	}
+	a, ok := t.Lookup(name)
+	if !ok {
+		return 0, errors.Errorf("symbol %s is undefined", name)
+	}
+	return a + uint16(off), nil
+}
+
+// WriteTo writes the table out in its text format, which consists of
+// tab separated records:
+//
+//	SYM	<name>	<octal address>
+//	LINE	<octal address>	<line>	<file>
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	write := func(format string, a ...interface{}) error {
+		c, err := fmt.Fprintf(bw, format, a...)
+		n += int64(c)
+		return err
+	}
+
+	for _, name := range t.Names() {
+		if err := write("SYM\t%s\t%05o\n", name, t.symbols[name]); err != nil {
+			return n, err
+		}
+	}
+
+	for _, a := range t.Addresses() {
+		loc := t.lines[a]
+		if err := write("LINE\t%05o\t%d\t%s\n", a, loc.Line, loc.File); err != nil {
+			return n, err
+		}
+	}
+
+	return n, bw.Flush()
+}
+
+// Read parses a table previously written with WriteTo.
+func Read(r io.Reader) (*Table, error) {
+	t := New()
+	s := bufio.NewScanner(r)
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 4)
+		switch {
+		case fields[0] == "SYM" && len(fields) == 3:
+			a, err := strconv.ParseUint(fields[2], 8, 16)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad address on line %d", lineNum)
+			}
+			t.Define(fields[1], uint16(a))
+		case fields[0] == "LINE" && len(fields) == 4:
+			a, err := strconv.ParseUint(fields[1], 8, 16)
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad address on line %d", lineNum)
+			}
+			l, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, errors.Wrapf(err, "bad line number on line %d", lineNum)
+			}
+			t.SetLine(uint16(a), Location{File: fields[3], Line: l})
+		default:
+			return nil, errors.Errorf("unrecognized record on line %d", lineNum)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
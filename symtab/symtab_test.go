@@ -0,0 +1,94 @@
+package symtab
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTable() *Table {
+	t := New()
+	t.Define("ARUPT", 010)
+	t.Define("T3RUPT", 04060)
+	t.Define("STARTUP", 04100)
+	t.Define("BANKED", 020000)
+	t.SetLine(04060, Location{File: "src/framework.agc", Line: 57})
+	t.SetLine(04061, Location{File: "src/framework.agc", Line: 58})
+	return t
+}
+
+func TestSymbolize(t *testing.T) {
+	tbl := buildTable()
+
+	assert.Equal(t, "T3RUPT", tbl.Symbolize(04060))
+	assert.Equal(t, "T3RUPT+2", tbl.Symbolize(04062))
+	assert.Equal(t, "STARTUP+12", tbl.Symbolize(04112))
+	assert.Equal(t, "ARUPT+1", tbl.Symbolize(011))
+	// symbols never cross banks
+	assert.Equal(t, "04000", tbl.Symbolize(04000))
+	assert.Equal(t, "06000", tbl.Symbolize(06000))
+	assert.Equal(t, "00400", tbl.Symbolize(0400))
+	assert.Equal(t, "BANKED+1", tbl.Symbolize(020001))
+}
+
+func TestSymbolizeNilTable(t *testing.T) {
+	var tbl *Table
+
+	assert.Equal(t, "04060", tbl.Symbolize(04060))
+}
+
+func TestAddresses(t *testing.T) {
+	tbl := buildTable()
+	tbl.SetLine(020000, Location{File: "src/banked.agc", Line: 3})
+	tbl.SetLine(04000, Location{File: "src/framework.agc", Line: 1})
+
+	assert.Equal(t, []uint16{04000, 04060, 04061, 020000}, tbl.Addresses())
+}
+
+func TestResolve(t *testing.T) {
+	tbl := buildTable()
+
+	cases := map[string]uint16{
+		"4000":                 04000,
+		"T3RUPT":               04060,
+		"t3rupt":               04060,
+		"T3RUPT+10":            04070,
+		"framework.agc:58":     04061,
+		"src/framework.agc:57": 04060,
+	}
+	for spec, expected := range cases {
+		a, err := tbl.Resolve(spec)
+		if assert.NoError(t, err, spec) {
+			assert.Equal(t, expected, a, spec)
+		}
+	}
+
+	for _, spec := range []string{"NOPE", "T3RUPT+9", "framework.agc:1", "framework.agc:x"} {
+		_, err := tbl.Resolve(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// arrange
+	tbl := buildTable()
+	buf := new(bytes.Buffer)
+
+	// act
+	_, err := tbl.WriteTo(buf)
+	require.NoError(t, err)
+	result, err := Read(buf)
+
+	// assert
+	require.NoError(t, err)
+	assert.Equal(t, tbl.symbols, result.symbols)
+	assert.Equal(t, tbl.lines, result.lines)
+}
+
+func TestReadBadRecord(t *testing.T) {
+	_, err := Read(bytes.NewBufferString("SYM\tFOO\n"))
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+// Package uplink sends the AGC data from the ground, as the CM's up-data
+// link did.
+//
+// Each 15 bit word is sent one bit at a time into the INLINK counter (045),
+// by SHINC for a 0 and SHANC for a 1, behind a 1 bit which raises UPRUPT
+// when it is shifted out of the top. Most uplinks were DSKY keys, each sent
+// as a word holding its keycode three times, the middle one complemented,
+// so that the program can reject words garbled on the way.
+//
+// The crew could block the uplink with a switch, which the program sees
+// on bit 10 of channel 33. The program lights UPLINK ACTY (bit 3 of channel
+// 11) while it is handling an uplink.
+package uplink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/Elsewhen-Studios/go-agc/dsky"
+	"github.com/pkg/errors"
+)
+
+// The times are in memory cycles.
+const (
+	// bitSpacing sends about 1000 bits a second.
+	bitSpacing = cpu.CyclesPer10ms / 10
+	// wordSpacing is the time from the start of one word to the next,
+	// leaving the program time to handle each one.
+	wordSpacing = 10 * cpu.CyclesPer10ms
+)
+
+const (
+	counterINLINK = 045
+	// chanBlock holds the uplink block discrete, which is active low like
+	// the other inputs of channels 30 to 33.
+	chanBlock = 033
+	blockBit  = 01000
+	// chanActy holds the UPLINK ACTY lamp.
+	chanActy = 011
+	actyBit  = 04
+)
+
+// Keyword makes the word which uplinks a DSKY key (any but PRO, which
+// has no keycode).
+func Keyword(k dsky.Key) uint16 {
+	code := uint16(k) & 037
+	return code<<10 | (^code&037)<<5 | code
+}
+
+type actionKind int
+
+const (
+	sendWord actionKind = iota
+	wait
+	block
+	unblock
+)
+
+type action struct {
+	kind   actionKind
+	word   uint16
+	cycles uint64
+}
+
+// Uplink is a cpu.Device which sends words into INLINK. The words are
+// queued up before the CPU starts, by Send, SendKeys, Wait and Load.
+type Uplink struct {
+	// Log, if set, receives a line for each word sent or blocked and for
+	// each time the program turns UPLINK ACTY on or off.
+	Log io.Writer
+
+	actions []action
+	blocked bool
+	acty    bool
+
+	started bool
+	// now is the time of the last step
+	now uint64
+	// next is when the next bit or action is due
+	next uint64
+	// bits is how many bits of word (and the 1 before it) are still to be
+	// shifted in
+	bits      int
+	word      uint16
+	wordStart uint64
+}
+
+// New creates an Uplink with nothing to send.
+func New() *Uplink {
+	return new(Uplink)
+}
+
+// Send queues words to be sent.
+func (u *Uplink) Send(words ...uint16) {
+	for _, w := range words {
+		u.actions = append(u.actions, action{kind: sendWord, word: w & 077777})
+	}
+}
+
+// SendKeys queues DSKY keys to be sent.
+func (u *Uplink) SendKeys(keys ...dsky.Key) {
+	for _, k := range keys {
+		u.Send(Keyword(k))
+	}
+}
+
+// Wait queues a pause before anything queued after it is sent.
+func (u *Uplink) Wait(d time.Duration) {
+	cycles := uint64(d * cpu.CyclesPer10ms / (10 * time.Millisecond))
+	u.actions = append(u.actions, action{kind: wait, cycles: cycles})
+}
+
+// Block queues the crew blocking (or unblocking) the uplink. Words sent
+// while it is blocked never reach INLINK.
+func (u *Uplink) Block(blocked bool) {
+	kind := unblock
+	if blocked {
+		kind = block
+	}
+	u.actions = append(u.actions, action{kind: kind})
+}
+
+// scriptKeys are the characters which stand for the DSKY keys in a script.
+var scriptKeys = map[rune]dsky.Key{
+	'0': dsky.Key0, '1': dsky.Key1, '2': dsky.Key2, '3': dsky.Key3, '4': dsky.Key4,
+	'5': dsky.Key5, '6': dsky.Key6, '7': dsky.Key7, '8': dsky.Key8, '9': dsky.Key9,
+	'V': dsky.KeyVerb, 'N': dsky.KeyNoun, 'E': dsky.KeyEnter, 'C': dsky.KeyClear,
+	'R': dsky.KeyReset, 'K': dsky.KeyRel, '+': dsky.KeyPlus, '-': dsky.KeyMinus,
+}
+
+// Load queues the commands of an uplink script, one per line:
+//
+//	keys <keys>       send DSKY keys: digits, + and -, and V (VERB),
+//	                  N (NOUN), E (ENTR), C (CLR), R (RSET) and K (KEY REL)
+//	word <octal>...   send words
+//	wait <duration>   pause, for a duration such as 500ms or 2s
+//	block             block the uplink
+//	unblock           unblock it again
+//
+// Anything after a # is a comment.
+func (u *Uplink) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := u.command(fields[0], fields[1:]); err != nil {
+			return errors.Wrapf(err, "uplink script line %d", line)
+		}
+	}
+	return errors.Wrap(s.Err(), "failed to read uplink script")
+}
+
+func (u *Uplink) command(cmd string, args []string) error {
+	switch cmd {
+	case "keys":
+		if len(args) == 0 {
+			return errors.New("no keys to send")
+		}
+		for _, k := range strings.Join(args, "") {
+			key, ok := scriptKeys[k]
+			if !ok {
+				return errors.Errorf("unknown key %q", k)
+			}
+			u.SendKeys(key)
+		}
+	case "word":
+		if len(args) == 0 {
+			return errors.New("no words to send")
+		}
+		for _, arg := range args {
+			w, err := strconv.ParseUint(arg, 8, 15)
+			if err != nil {
+				return errors.Errorf("bad word %q", arg)
+			}
+			u.Send(uint16(w))
+		}
+	case "wait":
+		if len(args) != 1 {
+			return errors.New("wait takes a duration")
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		u.Wait(d)
+	case "block", "unblock":
+		if len(args) != 0 {
+			return errors.Errorf("%s takes no arguments", cmd)
+		}
+		u.Block(cmd == "block")
+	default:
+		return errors.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
+
+// ChannelWritten implements cpu.Device, watching UPLINK ACTY.
+func (u *Uplink) ChannelWritten(ch, val uint16) {
+	if ch != chanActy || (val&actyBit != 0) == u.acty {
+		return
+	}
+	u.acty = !u.acty
+	state := "off"
+	if u.acty {
+		state = "on"
+	}
+	u.log("UPLINK ACTY %s", state)
+}
+
+// Step implements cpu.Device, shifting in the next bit or starting the next
+// action when it is due.
+func (u *Uplink) Step(io cpu.DeviceIO) {
+	if !u.started {
+		u.started = true
+		u.setBlock(io, false)
+	}
+	now := io.Cycles()
+	u.now = now
+	if now < u.next {
+		return
+	}
+
+	if u.bits == 0 {
+		if len(u.actions) == 0 {
+			return
+		}
+		a := u.actions[0]
+		u.actions = u.actions[1:]
+		switch a.kind {
+		case wait:
+			u.next = now + a.cycles
+			return
+		case block, unblock:
+			u.setBlock(io, a.kind == block)
+			return
+		}
+		if u.blocked {
+			u.log("word %05o blocked", a.word)
+		} else {
+			u.log("word %05o", a.word)
+		}
+		u.word, u.bits, u.wordStart = a.word, 16, now
+	}
+
+	u.bits--
+	kind := cpu.SHINC
+	if (1<<15|u.word)>>uint(u.bits)&1 != 0 {
+		kind = cpu.SHANC
+	}
+	if !u.blocked {
+		io.Count(counterINLINK, kind)
+	}
+	u.next = now + bitSpacing
+	if u.bits == 0 {
+		u.next = u.wordStart + wordSpacing
+	}
+}
+
+// setBlock sets the block discrete, which is 0 when the uplink is blocked.
+func (u *Uplink) setBlock(io cpu.DeviceIO, blocked bool) {
+	u.blocked = blocked
+	val := io.Channel(chanBlock) | blockBit
+	if blocked {
+		val &^= blockBit
+	}
+	io.SetChannel(chanBlock, val)
+}
+
+// log writes a line to the log, starting with the time.
+func (u *Uplink) log(format string, args ...interface{}) {
+	if u.Log != nil {
+		fmt.Fprintf(u.Log, "%d %s\n", u.now, fmt.Sprintf(format, args...))
+	}
+}
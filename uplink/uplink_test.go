@@ -0,0 +1,194 @@
+package uplink
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/Elsewhen-Studios/go-agc/dsky"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIO stands in for the CPU, keeping the bits shifted into INLINK.
+type fakeIO struct {
+	cycles uint64
+	chans  map[uint16]uint16
+	bits   []int
+	times  []uint64
+}
+
+func newFakeIO() *fakeIO {
+	return &fakeIO{chans: make(map[uint16]uint16)}
+}
+
+func (io *fakeIO) Cycles() uint64                { return io.cycles }
+func (io *fakeIO) Channel(ch uint16) uint16      { return io.chans[ch] }
+func (io *fakeIO) SetChannel(ch, val uint16)     { io.chans[ch] = val }
+func (io *fakeIO) Counter(counter uint16) uint16 { return 0 }
+func (io *fakeIO) Interrupt(name string)         {}
+func (io *fakeIO) Count(counter uint16, kind cpu.CounterKind) {
+	if counter != counterINLINK {
+		panic("not INLINK")
+	}
+	bit := 0
+	if kind == cpu.SHANC {
+		bit = 1
+	}
+	io.bits = append(io.bits, bit)
+	io.times = append(io.times, io.cycles)
+}
+
+// run steps u once every memory cycle until the given time.
+func (io *fakeIO) run(u *Uplink, until uint64) {
+	for ; io.cycles < until; io.cycles++ {
+		u.Step(io)
+	}
+}
+
+// word puts together the bits shifted in, which should start with a 1.
+func word(t *testing.T, bits []int) uint16 {
+	require.Len(t, bits, 16)
+	require.Equal(t, 1, bits[0], "the word is sent after a 1")
+	var w uint16
+	for _, b := range bits[1:] {
+		w = w<<1 | uint16(b)
+	}
+	return w
+}
+
+func TestKeyword(t *testing.T) {
+	// act
+	w := Keyword(dsky.KeyVerb)
+
+	// assert
+	assert.Equal(t, uint16(021<<10|016<<5|021), w)
+}
+
+func TestSend(t *testing.T) {
+	// arrange
+	u := New()
+	log := new(bytes.Buffer)
+	u.Log = log
+	u.Send(012345, 054321)
+	io := newFakeIO()
+
+	// act
+	io.run(u, 2*wordSpacing)
+
+	// assert
+	require.Len(t, io.bits, 32)
+	assert.Equal(t, uint16(012345), word(t, io.bits[:16]))
+	assert.Equal(t, uint16(054321), word(t, io.bits[16:]))
+	assert.Equal(t, uint64(bitSpacing), io.times[1], "the bits are spaced out")
+	assert.Equal(t, uint64(wordSpacing), io.times[16], "the words are spaced out")
+	assert.Equal(t, "0 word 12345\n8530 word 54321\n", log.String())
+	assert.Equal(t, uint16(blockBit), io.chans[chanBlock], "the block discrete is active low")
+}
+
+func TestWait(t *testing.T) {
+	// arrange
+	u := New()
+	u.Wait(100 * time.Millisecond)
+	u.Send(1)
+	io := newFakeIO()
+
+	// act
+	io.run(u, 2*wordSpacing)
+
+	// assert
+	require.NotEmpty(t, io.times)
+	assert.Equal(t, uint64(10*cpu.CyclesPer10ms), io.times[0])
+}
+
+func TestBlock(t *testing.T) {
+	// arrange
+	u := New()
+	log := new(bytes.Buffer)
+	u.Log = log
+	u.Block(true)
+	u.Send(1)
+	u.Block(false)
+	u.Send(2)
+	io := newFakeIO()
+
+	// act
+	io.run(u, 1)
+	blocked := io.chans[chanBlock]
+	io.run(u, 3*wordSpacing)
+
+	// assert
+	assert.Zero(t, blocked&blockBit)
+	assert.Equal(t, uint16(blockBit), io.chans[chanBlock])
+	assert.Equal(t, uint16(2), word(t, io.bits), "only the second word gets through")
+	assert.Contains(t, log.String(), "word 00001 blocked\n")
+}
+
+func TestUplinkActy(t *testing.T) {
+	// arrange
+	u := New()
+	log := new(bytes.Buffer)
+	u.Log = log
+	io := newFakeIO()
+	io.cycles = 100
+	u.Step(io)
+
+	// act
+	u.ChannelWritten(011, 04)
+	u.ChannelWritten(011, 06)
+	u.ChannelWritten(010, 0)
+	u.ChannelWritten(011, 0)
+
+	// assert
+	assert.Equal(t, "100 UPLINK ACTY on\n100 UPLINK ACTY off\n", log.String())
+}
+
+func TestLoad(t *testing.T) {
+	// arrange
+	u := New()
+	script := `
+# load a state vector
+keys V71E
+word 12345 00001   # raw words
+wait 1s
+block
+unblock
+`
+
+	// act
+	err := u.Load(strings.NewReader(script))
+
+	// assert
+	require.NoError(t, err)
+	require.Len(t, u.actions, 9)
+	assert.Equal(t, action{kind: sendWord, word: Keyword(dsky.KeyVerb)}, u.actions[0])
+	assert.Equal(t, action{kind: sendWord, word: Keyword(dsky.KeyEnter)}, u.actions[3])
+	assert.Equal(t, action{kind: sendWord, word: 012345}, u.actions[4])
+	assert.Equal(t, action{kind: wait, cycles: 100 * cpu.CyclesPer10ms}, u.actions[6])
+	assert.Equal(t, action{kind: block}, u.actions[7])
+	assert.Equal(t, action{kind: unblock}, u.actions[8])
+}
+
+func TestLoadErrors(t *testing.T) {
+	scenarios := []struct {
+		script, err string
+	}{
+		{"keys V7X", `uplink script line 1: unknown key 'X'`},
+		{"\nword 9", `uplink script line 2: bad word "9"`},
+		{"wait", "uplink script line 1: wait takes a duration"},
+		{"block now", "uplink script line 1: block takes no arguments"},
+		{"send 1", `uplink script line 1: unknown command "send"`},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.script, func(t *testing.T) {
+			// act
+			err := New().Load(strings.NewReader(scenario.script))
+
+			// assert
+			assert.EqualError(t, err, scenario.err)
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Package yaagc lets the peripherals of the Virtual AGC project, such as
+// yaDSKY2, yaTelemetry and the hand controller simulators, drive the CPU's
+// I/O channels in the same way they drive yaAGC: over TCP (on port 19697
+// by convention), as a stream of 4 byte packets.
+//
+// A packet carries a 7 bit channel and a 15 bit value, with the two top
+// bits of each byte numbering it so a reader can find the start of one:
+//
+//	00utpppp 01pppddd 10dddddd 11dddddd
+//
+// The server sends a packet to every client whenever the program writes to
+// a channel. Clients send packets to set input channels, with the u bit
+// set when the value is instead a mask of the bits later packets for the
+// channel change (all of them to begin with). When the t bit is set the
+// channel is the address of a counter and the value is the kind of
+// unprogrammed sequence to apply to it, in the order of cpu.CounterKind
+// (PINC, PCDU, MINC, MCDU, DINC, SHINC then SHANC).
+package yaagc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+)
+
+// clientBuffer is how many packets can be waiting to go to a client before
+// the server starts dropping them rather than hold up the CPU.
+const clientBuffer = 4096
+
+const (
+	uBit = 0x20
+	tBit = 0x10
+)
+
+// packet is a decoded packet.
+type packet struct {
+	channel, value uint16
+	// mask is set by the u bit
+	mask bool
+	// counter is set by the t bit
+	counter bool
+}
+
+func (p packet) encode() [4]byte {
+	b := [4]byte{
+		byte(p.channel >> 3 & 017),
+		0x40 | byte(p.channel&07)<<3 | byte(p.value>>12&07),
+		0x80 | byte(p.value>>6&077),
+		0xc0 | byte(p.value&077),
+	}
+	if p.mask {
+		b[0] |= uBit
+	}
+	if p.counter {
+		b[0] |= tBit
+	}
+	return b
+}
+
+func decode(b [4]byte) packet {
+	return packet{
+		channel: uint16(b[0]&017)<<3 | uint16(b[1]>>3&07),
+		value:   uint16(b[1]&07)<<12 | uint16(b[2]&077)<<6 | uint16(b[3]&077),
+		mask:    b[0]&uBit != 0,
+		counter: b[0]&tBit != 0,
+	}
+}
+
+// readPacket reads the next packet, skipping any bytes which are out of
+// place so that it can find its way back after a bad one.
+func readPacket(r io.ByteReader) (packet, error) {
+	var b [4]byte
+	for n := 0; n < len(b); {
+		c, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		switch {
+		case int(c>>6) == n:
+			b[n] = c
+			n++
+		case c>>6 == 0:
+			// the start of another packet
+			b[0] = c
+			n = 1
+		default:
+			n = 0
+		}
+	}
+	return decode(b), nil
+}
+
+// input is a change to an input channel or counter from a client.
+type input struct {
+	packet
+	// bits are the bits of the channel the value sets
+	bits uint16
+}
+
+// Server is a cpu.Device which serves the CPU's I/O channels to yaAGC
+// clients. Clients can connect and disconnect while the CPU runs.
+type Server struct {
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	inputs  []input
+	pending int32
+}
+
+// NewServer creates a Server with no clients.
+func NewServer() *Server {
+	return &Server{clients: make(map[*client]struct{})}
+}
+
+// Serve accepts clients from l, serving each of them until they disconnect.
+// It returns when l fails to accept a connection.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// client is a connected peripheral.
+type client struct {
+	out chan [4]byte
+	// masks are the bits of each channel the client's packets set
+	masks [0200]uint16
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	cl := &client{out: make(chan [4]byte, clientBuffer)}
+	for i := range cl.masks {
+		cl.masks[i] = 077777
+	}
+	s.mu.Lock()
+	s.clients[cl] = struct{}{}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// closing the connection stops the reader if a write fails
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		for b := range cl.out {
+			if _, err := w.Write(b[:]); err != nil {
+				return
+			}
+			if len(cl.out) > 0 {
+				continue
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			break
+		}
+		switch {
+		case p.mask:
+			cl.masks[p.channel] = p.value
+		case p.counter:
+			s.queue(input{packet: p})
+		default:
+			s.queue(input{packet: p, bits: cl.masks[p.channel]})
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.clients, cl)
+	s.mu.Unlock()
+	close(cl.out)
+	<-done
+}
+
+func (s *Server) queue(in input) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputs = append(s.inputs, in)
+	atomic.StoreInt32(&s.pending, 1)
+}
+
+// ChannelWritten implements cpu.Device, sending the value to every client.
+func (s *Server) ChannelWritten(ch, val uint16) {
+	if ch >= 0200 {
+		return
+	}
+	b := packet{channel: ch, value: val}.encode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for cl := range s.clients {
+		select {
+		case cl.out <- b:
+		default:
+			// the client isn't keeping up
+		}
+	}
+}
+
+// Step implements cpu.Device, applying the packets the clients have sent.
+func (s *Server) Step(io cpu.DeviceIO) {
+	if atomic.LoadInt32(&s.pending) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	inputs := s.inputs
+	s.inputs = nil
+	atomic.StoreInt32(&s.pending, 0)
+	s.mu.Unlock()
+
+	for _, in := range inputs {
+		apply(io, in)
+	}
+}
+
+// apply makes the change a client asked for, ignoring packets for counters
+// which don't exist and for the registers at the bottom of the channels.
+func apply(io cpu.DeviceIO, in input) {
+	if in.counter {
+		kind := cpu.CounterKind(in.value)
+		if in.channel >= 032 && in.channel <= 060 && kind >= cpu.PINC && kind <= cpu.SHANC {
+			io.Count(in.channel, kind)
+		}
+		return
+	}
+
+	if in.channel < 3 {
+		return
+	}
+	io.SetChannel(in.channel, io.Channel(in.channel)&^in.bits|in.value&in.bits)
+	switch in.channel {
+	case 015:
+		io.Interrupt("KEYRUPT1")
+	case 016:
+		io.Interrupt("KEYRUPT2")
+	}
+}
@@ -0,0 +1,157 @@
+package yaagc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Elsewhen-Studios/go-agc/cpu"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIO stands in for the CPU.
+type fakeIO struct {
+	chans      map[uint16]uint16
+	interrupts []string
+	counts     []string
+}
+
+func newFakeIO() *fakeIO {
+	return &fakeIO{chans: make(map[uint16]uint16)}
+}
+
+func (io *fakeIO) Cycles() uint64                { return 0 }
+func (io *fakeIO) Channel(ch uint16) uint16      { return io.chans[ch] }
+func (io *fakeIO) SetChannel(ch, val uint16)     { io.chans[ch] = val }
+func (io *fakeIO) Counter(counter uint16) uint16 { return 0 }
+func (io *fakeIO) Interrupt(name string)         { io.interrupts = append(io.interrupts, name) }
+func (io *fakeIO) Count(counter uint16, kind cpu.CounterKind) {
+	io.counts = append(io.counts, fmt.Sprintf("%s %03o", kind, counter))
+}
+
+func TestPacketEncode(t *testing.T) {
+	// arrange
+	p := packet{channel: 015, value: 021}
+
+	// act
+	b := p.encode()
+
+	// assert
+	assert.Equal(t, [4]byte{0x01, 0x68, 0x80, 0xd1}, b)
+	assert.Equal(t, p, decode(b))
+}
+
+func TestPacketFlags(t *testing.T) {
+	// arrange
+	p := packet{channel: 0177, value: 077777, mask: true, counter: true}
+
+	// act
+	b := p.encode()
+
+	// assert
+	assert.Equal(t, [4]byte{0x3f, 0x7f, 0xbf, 0xff}, b)
+	assert.Equal(t, p, decode(b))
+}
+
+func TestReadPacketResyncs(t *testing.T) {
+	// arrange
+	key := packet{channel: 015, value: 021}.encode()
+	lamps := packet{channel: 011, value: 2}.encode()
+	var stream []byte
+	stream = append(stream, key[:2]...) // cut short
+	stream = append(stream, 0xc0, 0x80) // out of place
+	stream = append(stream, key[:]...)
+	stream = append(stream, lamps[:]...)
+	r := bytes.NewReader(stream)
+
+	// act
+	first, err1 := readPacket(r)
+	second, err2 := readPacket(r)
+	_, err3 := readPacket(r)
+
+	// assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.Equal(t, packet{channel: 015, value: 021}, first)
+	assert.Equal(t, packet{channel: 011, value: 2}, second)
+	assert.Equal(t, io.EOF, err3)
+}
+
+// stepUntil steps the server until done returns true.
+func stepUntil(t *testing.T, s *Server, io cpu.DeviceIO, done func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the client")
+		}
+		time.Sleep(time.Millisecond)
+		s.Step(io)
+	}
+}
+
+func TestServer(t *testing.T) {
+	// arrange
+	s := NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	send := func(p packet) {
+		b := p.encode()
+		_, err := conn.Write(b[:])
+		require.NoError(t, err)
+	}
+	fio := newFakeIO()
+	fio.chans[032] = 027777
+
+	// act
+	send(packet{channel: 032, value: 020000, mask: true})
+	send(packet{channel: 032, value: 0})                // PRO pressed
+	send(packet{channel: 015, value: 021})              // VERB
+	send(packet{channel: 032, value: 1, counter: true}) // PCDU CDUX
+	stepUntil(t, s, fio, func() bool { return len(fio.counts) > 0 })
+
+	s.ChannelWritten(011, 2)
+	s.ChannelWritten(0200, 1) // can't be sent
+	s.ChannelWritten(010, 054321)
+
+	// assert
+	assert.Equal(t, uint16(007777), fio.chans[032], "only the masked bit changes")
+	assert.Equal(t, uint16(021), fio.chans[015])
+	assert.Equal(t, []string{"KEYRUPT1"}, fio.interrupts)
+	assert.Equal(t, []string{"PCDU 032"}, fio.counts)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	first, err := readPacket(r)
+	require.NoError(t, err)
+	second, err := readPacket(r)
+	require.NoError(t, err)
+	assert.Equal(t, packet{channel: 011, value: 2}, first)
+	assert.Equal(t, packet{channel: 010, value: 054321}, second)
+}
+
+func TestServerIgnoresBadInputs(t *testing.T) {
+	// arrange
+	s := NewServer()
+	fio := newFakeIO()
+
+	// act
+	s.queue(input{packet: packet{channel: 031, value: 0, counter: true}})
+	s.queue(input{packet: packet{channel: 032, value: 7, counter: true}})
+	s.queue(input{packet: packet{channel: 2, value: 1}, bits: 077777})
+	s.Step(fio)
+
+	// assert
+	assert.Empty(t, fio.counts)
+	assert.Empty(t, fio.chans)
+}